@@ -14,40 +14,412 @@ import (
 )
 
 const AddItem = `-- name: AddItem :exec
-INSERT INTO cart_items (owner_id, product_id, price_amount, price_currency)
-VALUES ($1, $2, $3, $4)
-ON CONFLICT (owner_id, product_id) DO UPDATE
-    SET price_amount = EXCLUDED.price_amount, price_currency = EXCLUDED.price_currency
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
 `
 
 type AddItemParams struct {
 	OwnerID       string
+	CartName      string
 	ProductID     uuid.UUID
 	PriceAmount   decimal.Decimal
 	PriceCurrency string
+	Quantity      int32
+	Note          *string
+	CreatedAt     time.Time
 }
 
 func (q *Queries) AddItem(ctx context.Context, arg AddItemParams) error {
 	_, err := q.db.Exec(ctx, AddItem,
 		arg.OwnerID,
+		arg.CartName,
 		arg.ProductID,
 		arg.PriceAmount,
 		arg.PriceCurrency,
+		arg.Quantity,
+		arg.Note,
+		arg.CreatedAt,
 	)
 	return err
 }
 
+const AddItemReturning = `-- name: AddItemReturning :one
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
+RETURNING product_id, price_amount, price_currency, quantity, created_at, note
+`
+
+type AddItemReturningParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	Note          *string
+	CreatedAt     time.Time
+}
+
+type AddItemReturningRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) AddItemReturning(ctx context.Context, arg AddItemReturningParams) (AddItemReturningRow, error) {
+	row := q.db.QueryRow(ctx, AddItemReturning,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+		arg.Quantity,
+		arg.Note,
+		arg.CreatedAt,
+	)
+	var i AddItemReturningRow
+	err := row.Scan(
+		&i.ProductID,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.Note,
+	)
+	return i, err
+}
+
+const AddItemReturningPrevious = `-- name: AddItemReturningPrevious :one
+WITH previous AS (
+    SELECT product_id, price_amount, price_currency, quantity, created_at, note
+    FROM cart_items
+    WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
+    FOR UPDATE
+), upserted AS (
+    INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+        SET price_amount   = EXCLUDED.price_amount,
+            price_currency = EXCLUDED.price_currency,
+            note           = EXCLUDED.note,
+            quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+            created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+            version        = cart_items.version + 1,
+            deleted_at     = NULL
+)
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM previous
+`
+
+type AddItemReturningPreviousParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	Note          *string
+	CreatedAt     time.Time
+}
+
+type AddItemReturningPreviousRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) AddItemReturningPrevious(ctx context.Context, arg AddItemReturningPreviousParams) (AddItemReturningPreviousRow, error) {
+	row := q.db.QueryRow(ctx, AddItemReturningPrevious,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+		arg.Quantity,
+		arg.Note,
+		arg.CreatedAt,
+	)
+	var i AddItemReturningPreviousRow
+	err := row.Scan(
+		&i.ProductID,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.Note,
+	)
+	return i, err
+}
+
+const ApplyPriceFactor = `-- name: ApplyPriceFactor :execrows
+UPDATE cart_items
+SET price_amount = price_amount * $1::DECIMAL, version = version + 1
+WHERE owner_id = $2 AND cart_name = $3 AND price_currency = $4 AND deleted_at IS NULL
+`
+
+type ApplyPriceFactorParams struct {
+	Factor        decimal.Decimal
+	OwnerID       string
+	CartName      string
+	PriceCurrency string
+}
+
+func (q *Queries) ApplyPriceFactor(ctx context.Context, arg ApplyPriceFactorParams) (int64, error) {
+	result, err := q.db.Exec(ctx, ApplyPriceFactor,
+		arg.Factor,
+		arg.OwnerID,
+		arg.CartName,
+		arg.PriceCurrency,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const ApplyQuantityDelta = `-- name: ApplyQuantityDelta :one
+UPDATE cart_items
+SET quantity   = CASE WHEN quantity + $1::INTEGER > 0 THEN quantity + $1::INTEGER ELSE quantity END,
+    deleted_at = CASE WHEN quantity + $1::INTEGER > 0 THEN NULL ELSE $2::TIMESTAMPTZ END,
+    version    = version + 1
+WHERE owner_id = $3 AND cart_name = $4 AND product_id = $5 AND deleted_at IS NULL
+  AND quantity + $1::INTEGER >= 0
+RETURNING deleted_at
+`
+
+type ApplyQuantityDeltaParams struct {
+	Delta     int32
+	DeletedAt time.Time
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+func (q *Queries) ApplyQuantityDelta(ctx context.Context, arg ApplyQuantityDeltaParams) (*time.Time, error) {
+	row := q.db.QueryRow(ctx, ApplyQuantityDelta,
+		arg.Delta,
+		arg.DeletedAt,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+	)
+	var deleted_at *time.Time
+	err := row.Scan(&deleted_at)
+	return deleted_at, err
+}
+
+const CartExists = `-- name: CartExists :one
+SELECT EXISTS(SELECT 1 FROM cart_items WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL)
+`
+
+type CartExistsParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) CartExists(ctx context.Context, arg CartExistsParams) (bool, error) {
+	row := q.db.QueryRow(ctx, CartExists, arg.OwnerID, arg.CartName)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const CartKnown = `-- name: CartKnown :one
+SELECT EXISTS(SELECT 1 FROM cart_items WHERE owner_id = $1 AND cart_name = $2)
+`
+
+type CartKnownParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) CartKnown(ctx context.Context, arg CartKnownParams) (bool, error) {
+	row := q.db.QueryRow(ctx, CartKnown, arg.OwnerID, arg.CartName)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const ClearCart = `-- name: ClearCart :execrows
+UPDATE cart_items
+SET deleted_at = $3::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+`
+
+type ClearCartParams struct {
+	OwnerID   string
+	CartName  string
+	DeletedAt time.Time
+}
+
+func (q *Queries) ClearCart(ctx context.Context, arg ClearCartParams) (int64, error) {
+	result, err := q.db.Exec(ctx, ClearCart, arg.OwnerID, arg.CartName, arg.DeletedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const CountItems = `-- name: CountItems :one
+SELECT COUNT(*)
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+`
+
+type CountItemsParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) CountItems(ctx context.Context, arg CountItemsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, CountItems, arg.OwnerID, arg.CartName)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const DecrementItem = `-- name: DecrementItem :one
+UPDATE cart_items
+SET quantity   = CASE WHEN quantity > $1 THEN quantity - $1 ELSE quantity END,
+    deleted_at = CASE WHEN quantity > $1 THEN NULL ELSE $2::TIMESTAMPTZ END,
+    version    = version + 1
+WHERE owner_id = $3 AND cart_name = $4 AND product_id = $5 AND deleted_at IS NULL
+RETURNING product_id, price_amount, price_currency, quantity, created_at, note, deleted_at
+`
+
+type DecrementItemParams struct {
+	By        int32
+	DeletedAt time.Time
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+type DecrementItemRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+	DeletedAt     *time.Time
+}
+
+func (q *Queries) DecrementItem(ctx context.Context, arg DecrementItemParams) (DecrementItemRow, error) {
+	row := q.db.QueryRow(ctx, DecrementItem,
+		arg.By,
+		arg.DeletedAt,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+	)
+	var i DecrementItemRow
+	err := row.Scan(
+		&i.ProductID,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.Note,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
 const DeleteItem = `-- name: DeleteItem :execrows
-DELETE FROM cart_items WHERE owner_id = $1 AND product_id = $2
+UPDATE cart_items
+SET deleted_at = $4::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
 `
 
 type DeleteItemParams struct {
 	OwnerID   string
+	CartName  string
 	ProductID uuid.UUID
+	DeletedAt time.Time
 }
 
 func (q *Queries) DeleteItem(ctx context.Context, arg DeleteItemParams) (int64, error) {
-	result, err := q.db.Exec(ctx, DeleteItem, arg.OwnerID, arg.ProductID)
+	result, err := q.db.Exec(ctx, DeleteItem,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.DeletedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const DeleteItems = `-- name: DeleteItems :execrows
+UPDATE cart_items
+SET deleted_at = $1::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $2 AND cart_name = $3 AND product_id = ANY($4::UUID[]) AND deleted_at IS NULL
+`
+
+type DeleteItemsParams struct {
+	DeletedAt  time.Time
+	OwnerID    string
+	CartName   string
+	ProductIds []uuid.UUID
+}
+
+func (q *Queries) DeleteItems(ctx context.Context, arg DeleteItemsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, DeleteItems,
+		arg.DeletedAt,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductIds,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const DeleteItemsByCurrency = `-- name: DeleteItemsByCurrency :execrows
+UPDATE cart_items
+SET deleted_at = $4::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND price_currency = $3 AND deleted_at IS NULL
+`
+
+type DeleteItemsByCurrencyParams struct {
+	OwnerID       string
+	CartName      string
+	PriceCurrency string
+	DeletedAt     time.Time
+}
+
+func (q *Queries) DeleteItemsByCurrency(ctx context.Context, arg DeleteItemsByCurrencyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, DeleteItemsByCurrency,
+		arg.OwnerID,
+		arg.CartName,
+		arg.PriceCurrency,
+		arg.DeletedAt,
+	)
 	if err != nil {
 		return 0, err
 	}
@@ -55,20 +427,27 @@ func (q *Queries) DeleteItem(ctx context.Context, arg DeleteItemParams) (int64,
 }
 
 const GetCart = `-- name: GetCart :many
-SELECT product_id, price_amount, price_currency, created_at
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
 FROM cart_items
-WHERE owner_id = $1
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
 `
 
+type GetCartParams struct {
+	OwnerID  string
+	CartName string
+}
+
 type GetCartRow struct {
 	ProductID     uuid.UUID
 	PriceAmount   decimal.Decimal
 	PriceCurrency string
+	Quantity      int32
 	CreatedAt     time.Time
+	Note          *string
 }
 
-func (q *Queries) GetCart(ctx context.Context, ownerID string) ([]GetCartRow, error) {
-	rows, err := q.db.Query(ctx, GetCart, ownerID)
+func (q *Queries) GetCart(ctx context.Context, arg GetCartParams) ([]GetCartRow, error) {
+	rows, err := q.db.Query(ctx, GetCart, arg.OwnerID, arg.CartName)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +459,68 @@ func (q *Queries) GetCart(ctx context.Context, ownerID string) ([]GetCartRow, er
 			&i.ProductID,
 			&i.PriceAmount,
 			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartAfter = `-- name: GetCartAfter :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+  AND (created_at, product_id) > ($3::TIMESTAMP, $4::UUID)
+ORDER BY created_at, product_id
+LIMIT $5
+`
+
+type GetCartAfterParams struct {
+	OwnerID        string
+	CartName       string
+	AfterCreatedAt time.Time
+	AfterProductID uuid.UUID
+	PageLimit      int32
+}
+
+type GetCartAfterRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) GetCartAfter(ctx context.Context, arg GetCartAfterParams) ([]GetCartAfterRow, error) {
+	rows, err := q.db.Query(ctx, GetCartAfter,
+		arg.OwnerID,
+		arg.CartName,
+		arg.AfterCreatedAt,
+		arg.AfterProductID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCartAfterRow
+	for rows.Next() {
+		var i GetCartAfterRow
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
 			&i.CreatedAt,
+			&i.Note,
 		); err != nil {
 			return nil, err
 		}
@@ -91,3 +531,907 @@ func (q *Queries) GetCart(ctx context.Context, ownerID string) ([]GetCartRow, er
 	}
 	return items, nil
 }
+
+const GetCartCurrencies = `-- name: GetCartCurrencies :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+ORDER BY price_currency
+`
+
+type GetCartCurrenciesParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) GetCartCurrencies(ctx context.Context, arg GetCartCurrenciesParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, GetCartCurrencies, arg.OwnerID, arg.CartName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var price_currency string
+		if err := rows.Scan(&price_currency); err != nil {
+			return nil, err
+		}
+		items = append(items, price_currency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartCurrency = `-- name: GetCartCurrency :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+LIMIT 2
+`
+
+type GetCartCurrencyParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) GetCartCurrency(ctx context.Context, arg GetCartCurrencyParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, GetCartCurrency, arg.OwnerID, arg.CartName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var price_currency string
+		if err := rows.Scan(&price_currency); err != nil {
+			return nil, err
+		}
+		items = append(items, price_currency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartInRange = `-- name: GetCartInRange :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+  AND ($3::TIMESTAMP IS NULL OR created_at >= $3)
+  AND ($4::TIMESTAMP IS NULL OR created_at < $4)
+ORDER BY created_at, product_id
+`
+
+type GetCartInRangeParams struct {
+	OwnerID     string
+	CartName    string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+}
+
+type GetCartInRangeRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) GetCartInRange(ctx context.Context, arg GetCartInRangeParams) ([]GetCartInRangeRow, error) {
+	rows, err := q.db.Query(ctx, GetCartInRange,
+		arg.OwnerID,
+		arg.CartName,
+		arg.CreatedFrom,
+		arg.CreatedTo,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCartInRangeRow
+	for rows.Next() {
+		var i GetCartInRangeRow
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartNames = `-- name: GetCartNames :many
+SELECT DISTINCT cart_name
+FROM cart_items
+WHERE owner_id = $1 AND deleted_at IS NULL
+ORDER BY cart_name
+`
+
+func (q *Queries) GetCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	rows, err := q.db.Query(ctx, GetCartNames, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var cart_name string
+		if err := rows.Scan(&cart_name); err != nil {
+			return nil, err
+		}
+		items = append(items, cart_name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartWithLineTotals = `-- name: GetCartWithLineTotals :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note, (price_amount * quantity)::DECIMAL AS line_total
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+ORDER BY created_at, product_id
+`
+
+type GetCartWithLineTotalsParams struct {
+	OwnerID  string
+	CartName string
+}
+
+type GetCartWithLineTotalsRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+	LineTotal     decimal.Decimal
+}
+
+func (q *Queries) GetCartWithLineTotals(ctx context.Context, arg GetCartWithLineTotalsParams) ([]GetCartWithLineTotalsRow, error) {
+	rows, err := q.db.Query(ctx, GetCartWithLineTotals, arg.OwnerID, arg.CartName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCartWithLineTotalsRow
+	for rows.Next() {
+		var i GetCartWithLineTotalsRow
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+			&i.LineTotal,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetCartsByOwners = `-- name: GetCartsByOwners :many
+SELECT owner_id, product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = ANY($1::VARCHAR[]) AND cart_name = $2 AND deleted_at IS NULL
+`
+
+type GetCartsByOwnersParams struct {
+	OwnerIds []string
+	CartName string
+}
+
+type GetCartsByOwnersRow struct {
+	OwnerID       string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) GetCartsByOwners(ctx context.Context, arg GetCartsByOwnersParams) ([]GetCartsByOwnersRow, error) {
+	rows, err := q.db.Query(ctx, GetCartsByOwners, arg.OwnerIds, arg.CartName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCartsByOwnersRow
+	for rows.Next() {
+		var i GetCartsByOwnersRow
+		if err := rows.Scan(
+			&i.OwnerID,
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetDemandByProduct = `-- name: GetDemandByProduct :many
+SELECT product_id, SUM(quantity)::BIGINT AS demand
+FROM cart_items
+WHERE product_id = ANY($1::UUID[]) AND deleted_at IS NULL
+GROUP BY product_id
+`
+
+type GetDemandByProductRow struct {
+	ProductID uuid.UUID
+	Demand    int64
+}
+
+func (q *Queries) GetDemandByProduct(ctx context.Context, productIds []uuid.UUID) ([]GetDemandByProductRow, error) {
+	rows, err := q.db.Query(ctx, GetDemandByProduct, productIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDemandByProductRow
+	for rows.Next() {
+		var i GetDemandByProductRow
+		if err := rows.Scan(&i.ProductID, &i.Demand); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetDistinctCurrencies = `-- name: GetDistinctCurrencies :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE deleted_at IS NULL
+ORDER BY price_currency
+`
+
+func (q *Queries) GetDistinctCurrencies(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, GetDistinctCurrencies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var price_currency string
+		if err := rows.Scan(&price_currency); err != nil {
+			return nil, err
+		}
+		items = append(items, price_currency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetItem = `-- name: GetItem :one
+SELECT product_id, price_amount, price_currency, quantity, created_at, note, version
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
+`
+
+type GetItemParams struct {
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+type GetItemRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+	Version       int32
+}
+
+func (q *Queries) GetItem(ctx context.Context, arg GetItemParams) (GetItemRow, error) {
+	row := q.db.QueryRow(ctx, GetItem, arg.OwnerID, arg.CartName, arg.ProductID)
+	var i GetItemRow
+	err := row.Scan(
+		&i.ProductID,
+		&i.PriceAmount,
+		&i.PriceCurrency,
+		&i.Quantity,
+		&i.CreatedAt,
+		&i.Note,
+		&i.Version,
+	)
+	return i, err
+}
+
+const GetItemCreatedAt = `-- name: GetItemCreatedAt :one
+SELECT created_at
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
+`
+
+type GetItemCreatedAtParams struct {
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+func (q *Queries) GetItemCreatedAt(ctx context.Context, arg GetItemCreatedAtParams) (time.Time, error) {
+	row := q.db.QueryRow(ctx, GetItemCreatedAt, arg.OwnerID, arg.CartName, arg.ProductID)
+	var created_at time.Time
+	err := row.Scan(&created_at)
+	return created_at, err
+}
+
+const GetItemRecencyRank = `-- name: GetItemRecencyRank :one
+WITH ranked AS (
+    SELECT product_id, ROW_NUMBER() OVER (ORDER BY created_at DESC, product_id) AS recency_rank
+    FROM cart_items
+    WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+)
+SELECT recency_rank::BIGINT AS recency_rank
+FROM ranked
+WHERE product_id = $3
+`
+
+type GetItemRecencyRankParams struct {
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+func (q *Queries) GetItemRecencyRank(ctx context.Context, arg GetItemRecencyRankParams) (int64, error) {
+	row := q.db.QueryRow(ctx, GetItemRecencyRank, arg.OwnerID, arg.CartName, arg.ProductID)
+	var recency_rank int64
+	err := row.Scan(&recency_rank)
+	return recency_rank, err
+}
+
+const GetItemsByCurrency = `-- name: GetItemsByCurrency :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND price_currency = $3 AND deleted_at IS NULL
+`
+
+type GetItemsByCurrencyParams struct {
+	OwnerID       string
+	CartName      string
+	PriceCurrency string
+}
+
+type GetItemsByCurrencyRow struct {
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) GetItemsByCurrency(ctx context.Context, arg GetItemsByCurrencyParams) ([]GetItemsByCurrencyRow, error) {
+	rows, err := q.db.Query(ctx, GetItemsByCurrency, arg.OwnerID, arg.CartName, arg.PriceCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetItemsByCurrencyRow
+	for rows.Next() {
+		var i GetItemsByCurrencyRow
+		if err := rows.Scan(
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetProductIDs = `-- name: GetProductIDs :many
+SELECT product_id
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+`
+
+type GetProductIDsParams struct {
+	OwnerID  string
+	CartName string
+}
+
+func (q *Queries) GetProductIDs(ctx context.Context, arg GetProductIDsParams) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, GetProductIDs, arg.OwnerID, arg.CartName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var product_id uuid.UUID
+		if err := rows.Scan(&product_id); err != nil {
+			return nil, err
+		}
+		items = append(items, product_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetProductWeightedAvgPrice = `-- name: GetProductWeightedAvgPrice :one
+SELECT COUNT(*) AS line_count, COALESCE(SUM(price_amount * quantity) / SUM(quantity), 0)::DECIMAL AS avg_price
+FROM cart_items
+WHERE product_id = $1 AND price_currency = $2 AND deleted_at IS NULL
+`
+
+type GetProductWeightedAvgPriceParams struct {
+	ProductID     uuid.UUID
+	PriceCurrency string
+}
+
+type GetProductWeightedAvgPriceRow struct {
+	LineCount int64
+	AvgPrice  decimal.Decimal
+}
+
+func (q *Queries) GetProductWeightedAvgPrice(ctx context.Context, arg GetProductWeightedAvgPriceParams) (GetProductWeightedAvgPriceRow, error) {
+	row := q.db.QueryRow(ctx, GetProductWeightedAvgPrice, arg.ProductID, arg.PriceCurrency)
+	var i GetProductWeightedAvgPriceRow
+	err := row.Scan(&i.LineCount, &i.AvgPrice)
+	return i, err
+}
+
+const GetRecentItems = `-- name: GetRecentItems :many
+SELECT owner_id, product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE deleted_at IS NULL
+ORDER BY created_at DESC, product_id
+LIMIT $1
+`
+
+type GetRecentItemsRow struct {
+	OwnerID       string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	CreatedAt     time.Time
+	Note          *string
+}
+
+func (q *Queries) GetRecentItems(ctx context.Context, limit int32) ([]GetRecentItemsRow, error) {
+	rows, err := q.db.Query(ctx, GetRecentItems, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentItemsRow
+	for rows.Next() {
+		var i GetRecentItemsRow
+		if err := rows.Scan(
+			&i.OwnerID,
+			&i.ProductID,
+			&i.PriceAmount,
+			&i.PriceCurrency,
+			&i.Quantity,
+			&i.CreatedAt,
+			&i.Note,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetTopProducts = `-- name: GetTopProducts :many
+SELECT product_id, COUNT(DISTINCT owner_id) AS cart_count
+FROM cart_items
+WHERE deleted_at IS NULL
+GROUP BY product_id
+ORDER BY cart_count DESC, product_id
+LIMIT $1
+`
+
+type GetTopProductsRow struct {
+	ProductID uuid.UUID
+	CartCount int64
+}
+
+func (q *Queries) GetTopProducts(ctx context.Context, limit int32) ([]GetTopProductsRow, error) {
+	rows, err := q.db.Query(ctx, GetTopProducts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTopProductsRow
+	for rows.Next() {
+		var i GetTopProductsRow
+		if err := rows.Scan(&i.ProductID, &i.CartCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetTotalExcluding = `-- name: GetTotalExcluding :many
+SELECT price_currency, SUM(price_amount * quantity)::DECIMAL AS total
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND product_id != ALL($3::UUID[]) AND deleted_at IS NULL
+GROUP BY price_currency
+`
+
+type GetTotalExcludingParams struct {
+	OwnerID  string
+	CartName string
+	Excluded []uuid.UUID
+}
+
+type GetTotalExcludingRow struct {
+	PriceCurrency string
+	Total         decimal.Decimal
+}
+
+func (q *Queries) GetTotalExcluding(ctx context.Context, arg GetTotalExcludingParams) ([]GetTotalExcludingRow, error) {
+	rows, err := q.db.Query(ctx, GetTotalExcluding, arg.OwnerID, arg.CartName, arg.Excluded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTotalExcludingRow
+	for rows.Next() {
+		var i GetTotalExcludingRow
+		if err := rows.Scan(&i.PriceCurrency, &i.Total); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ImportItem = `-- name: ImportItem :exec
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7,
+        COALESCE($8::TIMESTAMP, $9::TIMESTAMP))
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = EXCLUDED.quantity,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL AND $8::TIMESTAMP IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
+`
+
+type ImportItemParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	Note          *string
+	CreatedAt     *time.Time
+	Now           time.Time
+}
+
+func (q *Queries) ImportItem(ctx context.Context, arg ImportItemParams) error {
+	_, err := q.db.Exec(ctx, ImportItem,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+		arg.Quantity,
+		arg.Note,
+		arg.CreatedAt,
+		arg.Now,
+	)
+	return err
+}
+
+const ListOwners = `-- name: ListOwners :many
+SELECT DISTINCT owner_id
+FROM cart_items
+WHERE cart_name = $1 AND deleted_at IS NULL
+ORDER BY owner_id
+LIMIT $2 OFFSET $3
+`
+
+type ListOwnersParams struct {
+	CartName string
+	Limit    int32
+	Offset   int32
+}
+
+func (q *Queries) ListOwners(ctx context.Context, arg ListOwnersParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, ListOwners, arg.CartName, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var owner_id string
+		if err := rows.Scan(&owner_id); err != nil {
+			return nil, err
+		}
+		items = append(items, owner_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const MoveItems = `-- name: MoveItems :execrows
+WITH moved AS (
+    UPDATE cart_items
+    SET deleted_at = $1::TIMESTAMPTZ, version = version + 1
+    WHERE owner_id = $2 AND cart_name = $3 AND deleted_at IS NULL
+    RETURNING cart_name, product_id, price_amount, price_currency, quantity, created_at, note
+)
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, created_at, note)
+SELECT $4::VARCHAR, cart_name, product_id, price_amount, price_currency, quantity, created_at, note
+FROM moved
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.price_amount ELSE EXCLUDED.price_amount END,
+        price_currency = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.price_currency ELSE EXCLUDED.price_currency END,
+        note           = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.note ELSE EXCLUDED.note END,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
+`
+
+type MoveItemsParams struct {
+	DeletedAt   time.Time
+	FromOwnerID string
+	CartName    string
+	ToOwnerID   string
+}
+
+func (q *Queries) MoveItems(ctx context.Context, arg MoveItemsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, MoveItems,
+		arg.DeletedAt,
+		arg.FromOwnerID,
+		arg.CartName,
+		arg.ToOwnerID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const PurgeCartsOlderThan = `-- name: PurgeCartsOlderThan :many
+DELETE FROM cart_items
+WHERE cart_name = $1 AND owner_id IN (
+    SELECT owner_id
+    FROM cart_items
+    WHERE cart_name = $1
+    GROUP BY owner_id
+    HAVING COALESCE(MAX(created_at) FILTER (WHERE deleted_at IS NULL), '-infinity') < $2::TIMESTAMP
+)
+RETURNING owner_id
+`
+
+type PurgeCartsOlderThanParams struct {
+	CartName string
+	Cutoff   time.Time
+}
+
+func (q *Queries) PurgeCartsOlderThan(ctx context.Context, arg PurgeCartsOlderThanParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, PurgeCartsOlderThan, arg.CartName, arg.Cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var owner_id string
+		if err := rows.Scan(&owner_id); err != nil {
+			return nil, err
+		}
+		items = append(items, owner_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const RestoreItem = `-- name: RestoreItem :execrows
+UPDATE cart_items
+SET deleted_at = NULL, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NOT NULL
+`
+
+type RestoreItemParams struct {
+	OwnerID   string
+	CartName  string
+	ProductID uuid.UUID
+}
+
+func (q *Queries) RestoreItem(ctx context.Context, arg RestoreItemParams) (int64, error) {
+	result, err := q.db.Exec(ctx, RestoreItem, arg.OwnerID, arg.CartName, arg.ProductID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const SetItem = `-- name: SetItem :exec
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = EXCLUDED.quantity,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
+`
+
+type SetItemParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Quantity      int32
+	Note          *string
+	CreatedAt     time.Time
+}
+
+func (q *Queries) SetItem(ctx context.Context, arg SetItemParams) error {
+	_, err := q.db.Exec(ctx, SetItem,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+		arg.Quantity,
+		arg.Note,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const SwapQuantities = `-- name: SwapQuantities :execrows
+UPDATE cart_items AS item
+SET quantity = other.quantity, version = item.version + 1
+FROM cart_items AS other
+WHERE item.owner_id = $1 AND item.cart_name = $2 AND item.deleted_at IS NULL
+  AND other.owner_id = item.owner_id AND other.cart_name = item.cart_name AND other.deleted_at IS NULL
+  AND ((item.product_id = $3 AND other.product_id = $4)
+    OR (item.product_id = $4 AND other.product_id = $3))
+`
+
+type SwapQuantitiesParams struct {
+	OwnerID  string
+	CartName string
+	ProductA uuid.UUID
+	ProductB uuid.UUID
+}
+
+func (q *Queries) SwapQuantities(ctx context.Context, arg SwapQuantitiesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, SwapQuantities,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductA,
+		arg.ProductB,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const UpdateItemPrice = `-- name: UpdateItemPrice :execrows
+UPDATE cart_items
+SET price_amount = $4, price_currency = $5, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
+`
+
+type UpdateItemPriceParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+}
+
+func (q *Queries) UpdateItemPrice(ctx context.Context, arg UpdateItemPriceParams) (int64, error) {
+	result, err := q.db.Exec(ctx, UpdateItemPrice,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const UpdateItemPriceIfVersion = `-- name: UpdateItemPriceIfVersion :one
+UPDATE cart_items
+SET price_amount = $4, price_currency = $5, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND version = $6 AND deleted_at IS NULL
+RETURNING version
+`
+
+type UpdateItemPriceIfVersionParams struct {
+	OwnerID       string
+	CartName      string
+	ProductID     uuid.UUID
+	PriceAmount   decimal.Decimal
+	PriceCurrency string
+	Version       int32
+}
+
+func (q *Queries) UpdateItemPriceIfVersion(ctx context.Context, arg UpdateItemPriceIfVersionParams) (int32, error) {
+	row := q.db.QueryRow(ctx, UpdateItemPriceIfVersion,
+		arg.OwnerID,
+		arg.CartName,
+		arg.ProductID,
+		arg.PriceAmount,
+		arg.PriceCurrency,
+		arg.Version,
+	)
+	var version int32
+	err := row.Scan(&version)
+	return version, err
+}
@@ -17,4 +17,21 @@ type CartItem struct {
 	PriceAmount   decimal.Decimal
 	PriceCurrency string
 	CreatedAt     time.Time
+	CartName      string
+	Quantity      int32
+	DeletedAt     *time.Time
+	Version       int32
+	Note          *string
+}
+
+type CartItemIdempotency struct {
+	OwnerID        string
+	IdempotencyKey string
+	CreatedAt      time.Time
+}
+
+type OwnerSetting struct {
+	OwnerID         string
+	DefaultCurrency string
+	UpdatedAt       time.Time
 }
@@ -1,13 +1,303 @@
 -- name: GetCart :many
-SELECT product_id, price_amount, price_currency, created_at
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
 FROM cart_items
-WHERE owner_id = $1;
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL;
 
 -- name: AddItem :exec
-INSERT INTO cart_items (owner_id, product_id, price_amount, price_currency)
-VALUES ($1, $2, $3, $4)
-ON CONFLICT (owner_id, product_id) DO UPDATE
-    SET price_amount = EXCLUDED.price_amount, price_currency = EXCLUDED.price_currency;
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL;
 
 -- name: DeleteItem :execrows
-DELETE FROM cart_items WHERE owner_id = $1 AND product_id = $2;
\ No newline at end of file
+UPDATE cart_items
+SET deleted_at = $4::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL;
+
+-- name: GetProductIDs :many
+SELECT product_id
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL;
+
+-- name: GetItemCreatedAt :one
+SELECT created_at
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL;
+
+-- name: GetProductWeightedAvgPrice :one
+SELECT COUNT(*) AS line_count, COALESCE(SUM(price_amount * quantity) / SUM(quantity), 0)::DECIMAL AS avg_price
+FROM cart_items
+WHERE product_id = $1 AND price_currency = $2 AND deleted_at IS NULL;
+
+-- name: DeleteItemsByCurrency :execrows
+UPDATE cart_items
+SET deleted_at = $4::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND price_currency = $3 AND deleted_at IS NULL;
+
+-- name: AddItemReturningPrevious :one
+WITH previous AS (
+    SELECT product_id, price_amount, price_currency, quantity, created_at, note
+    FROM cart_items
+    WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL
+    FOR UPDATE
+), upserted AS (
+    INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+        SET price_amount   = EXCLUDED.price_amount,
+            price_currency = EXCLUDED.price_currency,
+            note           = EXCLUDED.note,
+            quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+            created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+            version        = cart_items.version + 1,
+            deleted_at     = NULL
+)
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM previous;
+
+-- name: GetDemandByProduct :many
+SELECT product_id, SUM(quantity)::BIGINT AS demand
+FROM cart_items
+WHERE product_id = ANY(@product_ids::UUID[]) AND deleted_at IS NULL
+GROUP BY product_id;
+
+-- name: GetDistinctCurrencies :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE deleted_at IS NULL
+ORDER BY price_currency;
+
+-- name: GetCartCurrencies :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+ORDER BY price_currency;
+
+-- name: GetCartNames :many
+SELECT DISTINCT cart_name
+FROM cart_items
+WHERE owner_id = $1 AND deleted_at IS NULL
+ORDER BY cart_name;
+
+-- name: GetTotalExcluding :many
+SELECT price_currency, SUM(price_amount * quantity)::DECIMAL AS total
+FROM cart_items
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND product_id != ALL(@excluded::UUID[]) AND deleted_at IS NULL
+GROUP BY price_currency;
+
+-- name: GetItemRecencyRank :one
+WITH ranked AS (
+    SELECT product_id, ROW_NUMBER() OVER (ORDER BY created_at DESC, product_id) AS recency_rank
+    FROM cart_items
+    WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+)
+SELECT recency_rank::BIGINT AS recency_rank
+FROM ranked
+WHERE product_id = $3;
+
+-- name: GetCartCurrency :many
+SELECT DISTINCT price_currency
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+LIMIT 2;
+
+-- name: SetItem :exec
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = EXCLUDED.quantity,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL;
+
+-- name: ClearCart :execrows
+UPDATE cart_items
+SET deleted_at = $3::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL;
+
+-- name: GetItem :one
+SELECT product_id, price_amount, price_currency, quantity, created_at, note, version
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL;
+
+-- name: GetCartAfter :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND deleted_at IS NULL
+  AND (created_at, product_id) > (@after_created_at::TIMESTAMP, @after_product_id::UUID)
+ORDER BY created_at, product_id
+LIMIT @page_limit;
+
+-- name: CountItems :one
+SELECT COUNT(*)
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL;
+
+-- name: UpdateItemPrice :execrows
+UPDATE cart_items
+SET price_amount = $4, price_currency = $5, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NULL;
+
+-- name: MoveItems :execrows
+WITH moved AS (
+    UPDATE cart_items
+    SET deleted_at = @deleted_at::TIMESTAMPTZ, version = version + 1
+    WHERE owner_id = @from_owner_id AND cart_name = @cart_name AND deleted_at IS NULL
+    RETURNING cart_name, product_id, price_amount, price_currency, quantity, created_at, note
+)
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, created_at, note)
+SELECT @to_owner_id::VARCHAR, cart_name, product_id, price_amount, price_currency, quantity, created_at, note
+FROM moved
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.price_amount ELSE EXCLUDED.price_amount END,
+        price_currency = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.price_currency ELSE EXCLUDED.price_currency END,
+        note           = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.note ELSE EXCLUDED.note END,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL;
+
+-- name: RestoreItem :execrows
+UPDATE cart_items
+SET deleted_at = NULL, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND deleted_at IS NOT NULL;
+
+-- name: GetCartsByOwners :many
+SELECT owner_id, product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = ANY(@owner_ids::VARCHAR[]) AND cart_name = @cart_name AND deleted_at IS NULL;
+
+-- name: DeleteItems :execrows
+UPDATE cart_items
+SET deleted_at = @deleted_at::TIMESTAMPTZ, version = version + 1
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND product_id = ANY(@product_ids::UUID[]) AND deleted_at IS NULL;
+
+-- name: GetItemsByCurrency :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND price_currency = $3 AND deleted_at IS NULL;
+
+-- name: GetCartInRange :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND deleted_at IS NULL
+  AND (sqlc.narg(created_from)::TIMESTAMP IS NULL OR created_at >= sqlc.narg(created_from))
+  AND (sqlc.narg(created_to)::TIMESTAMP IS NULL OR created_at < sqlc.narg(created_to))
+ORDER BY created_at, product_id;
+
+-- name: GetRecentItems :many
+SELECT owner_id, product_id, price_amount, price_currency, quantity, created_at, note
+FROM cart_items
+WHERE deleted_at IS NULL
+ORDER BY created_at DESC, product_id
+LIMIT $1;
+
+-- name: AddItemReturning :one
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL
+RETURNING product_id, price_amount, price_currency, quantity, created_at, note;
+
+-- name: UpdateItemPriceIfVersion :one
+UPDATE cart_items
+SET price_amount = $4, price_currency = $5, version = version + 1
+WHERE owner_id = $1 AND cart_name = $2 AND product_id = $3 AND version = $6 AND deleted_at IS NULL
+RETURNING version;
+
+-- name: CartExists :one
+SELECT EXISTS(SELECT 1 FROM cart_items WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL);
+
+-- name: CartKnown :one
+SELECT EXISTS(SELECT 1 FROM cart_items WHERE owner_id = $1 AND cart_name = $2);
+
+-- name: ListOwners :many
+SELECT DISTINCT owner_id
+FROM cart_items
+WHERE cart_name = $1 AND deleted_at IS NULL
+ORDER BY owner_id
+LIMIT $2 OFFSET $3;
+
+-- name: GetTopProducts :many
+SELECT product_id, COUNT(DISTINCT owner_id) AS cart_count
+FROM cart_items
+WHERE deleted_at IS NULL
+GROUP BY product_id
+ORDER BY cart_count DESC, product_id
+LIMIT $1;
+
+-- name: PurgeCartsOlderThan :many
+DELETE FROM cart_items
+WHERE cart_name = @cart_name AND owner_id IN (
+    SELECT owner_id
+    FROM cart_items
+    WHERE cart_name = @cart_name
+    GROUP BY owner_id
+    HAVING COALESCE(MAX(created_at) FILTER (WHERE deleted_at IS NULL), '-infinity') < @cutoff::TIMESTAMP
+)
+RETURNING owner_id;
+
+-- name: DecrementItem :one
+UPDATE cart_items
+SET quantity   = CASE WHEN quantity > @by THEN quantity - @by ELSE quantity END,
+    deleted_at = CASE WHEN quantity > @by THEN NULL ELSE @deleted_at::TIMESTAMPTZ END,
+    version    = version + 1
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND product_id = @product_id AND deleted_at IS NULL
+RETURNING product_id, price_amount, price_currency, quantity, created_at, note, deleted_at;
+
+-- name: ImportItem :exec
+INSERT INTO cart_items (owner_id, cart_name, product_id, price_amount, price_currency, quantity, note, created_at)
+VALUES (@owner_id, @cart_name, @product_id, @price_amount, @price_currency, @quantity, @note,
+        COALESCE(sqlc.narg(created_at)::TIMESTAMP, @now::TIMESTAMP))
+ON CONFLICT (owner_id, cart_name, product_id) DO UPDATE
+    SET price_amount   = EXCLUDED.price_amount,
+        price_currency = EXCLUDED.price_currency,
+        note           = EXCLUDED.note,
+        quantity       = EXCLUDED.quantity,
+        created_at     = CASE WHEN cart_items.deleted_at IS NULL AND sqlc.narg(created_at)::TIMESTAMP IS NULL THEN cart_items.created_at ELSE EXCLUDED.created_at END,
+        version        = cart_items.version + 1,
+        deleted_at     = NULL;
+
+-- name: ApplyPriceFactor :execrows
+UPDATE cart_items
+SET price_amount = price_amount * @factor::DECIMAL, version = version + 1
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND price_currency = @price_currency AND deleted_at IS NULL;
+
+-- name: ApplyQuantityDelta :one
+UPDATE cart_items
+SET quantity   = CASE WHEN quantity + @delta::INTEGER > 0 THEN quantity + @delta::INTEGER ELSE quantity END,
+    deleted_at = CASE WHEN quantity + @delta::INTEGER > 0 THEN NULL ELSE @deleted_at::TIMESTAMPTZ END,
+    version    = version + 1
+WHERE owner_id = @owner_id AND cart_name = @cart_name AND product_id = @product_id AND deleted_at IS NULL
+  AND quantity + @delta::INTEGER >= 0
+RETURNING deleted_at;
+
+-- name: GetCartWithLineTotals :many
+SELECT product_id, price_amount, price_currency, quantity, created_at, note, (price_amount * quantity)::DECIMAL AS line_total
+FROM cart_items
+WHERE owner_id = $1 AND cart_name = $2 AND deleted_at IS NULL
+ORDER BY created_at, product_id;
+
+-- name: SwapQuantities :execrows
+UPDATE cart_items AS item
+SET quantity = other.quantity, version = item.version + 1
+FROM cart_items AS other
+WHERE item.owner_id = @owner_id AND item.cart_name = @cart_name AND item.deleted_at IS NULL
+  AND other.owner_id = item.owner_id AND other.cart_name = item.cart_name AND other.deleted_at IS NULL
+  AND ((item.product_id = @product_a AND other.product_id = @product_b)
+    OR (item.product_id = @product_b AND other.product_id = @product_a));
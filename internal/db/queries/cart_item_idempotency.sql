@@ -0,0 +1,4 @@
+-- name: AddIdempotencyKey :execrows
+INSERT INTO cart_item_idempotency (owner_id, idempotency_key, created_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (owner_id, idempotency_key) DO NOTHING;
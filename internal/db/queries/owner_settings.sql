@@ -0,0 +1,10 @@
+-- name: GetOwnerCurrency :one
+SELECT default_currency
+FROM owner_settings
+WHERE owner_id = $1;
+
+-- name: SetOwnerCurrency :exec
+INSERT INTO owner_settings (owner_id, default_currency, updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (owner_id) DO UPDATE
+    SET default_currency = EXCLUDED.default_currency, updated_at = EXCLUDED.updated_at;
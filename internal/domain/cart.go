@@ -1,7 +1,12 @@
 package domain
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
 	"time"
 )
 
@@ -13,6 +18,177 @@ type Cart struct {
 type CartItem struct {
 	ProductID uuid.UUID
 	Price     Money
+	Quantity  int32
+	// Note is optional free text, nil when there is none.
+	Note *string
 
 	CreatedAt time.Time
+	// Version grows with every change of the stored item, it is only read by GetItem.
+	Version int32
+}
+
+// Validate returns all problems of the item joined, the currency must not be XXX, the zero value of currency.Unit.
+func (i CartItem) Validate() error {
+	var errs []error
+
+	if i.ProductID == uuid.Nil {
+		errs = append(errs, errors.New("productID is empty"))
+	}
+	if i.Quantity <= 0 {
+		errs = append(errs, fmt.Errorf("quantity[%d] is not positive", i.Quantity))
+	}
+	if i.Price.Amount.LessThanOrEqual(decimal.Zero) {
+		errs = append(errs, fmt.Errorf("price amount[%s] is not positive", i.Price.Amount))
+	}
+	if i.Price.Currency == currency.XXX {
+		errs = append(errs, errors.New("currency is empty"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// cartItemJSON relies on Money and time.Time JSON encodings, the latter being RFC 3339.
+type cartItemJSON struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Price     Money     `json:"price"`
+	Quantity  int32     `json:"quantity"`
+	Note      *string   `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version,omitempty"`
+}
+
+func (i CartItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(cartItemJSON(i))
+}
+
+func (i *CartItem) UnmarshalJSON(data []byte) error {
+	var v cartItemJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	*i = CartItem(v)
+	return nil
+}
+
+// Subtotal is the price multiplied by the quantity in the price currency,
+// a zero or negative quantity gives a zero amount.
+func (i CartItem) Subtotal() Money {
+	if i.Quantity <= 0 {
+		return Money{Amount: decimal.Zero, Currency: i.Price.Currency}
+	}
+
+	return i.Price.Multiply(decimal.NewFromInt32(i.Quantity))
+}
+
+// Total sums the item subtotals, the items must share a currency.
+// An empty cart totals to a zero amount without a currency.
+func (c Cart) Total() (Money, error) {
+	if len(c.Items) == 0 {
+		return Money{Amount: decimal.Zero}, nil
+	}
+
+	total := Money{Amount: decimal.Zero, Currency: c.Items[0].Price.Currency}
+	for _, item := range c.Items {
+		var err error
+		if total, err = total.Add(item.Subtotal()); err != nil {
+			return Money{}, fmt.Errorf("%w: %w", ErrMixedCurrency, err)
+		}
+	}
+
+	return total, nil
+}
+
+// TotalIn sums the item subtotals converted to the target currency, rates maps
+// a currency code to the target amount of one unit of it. Items in the target currency need no rate,
+// an empty cart totals to zero in the target currency.
+func (c Cart) TotalIn(target currency.Unit, rates map[string]decimal.Decimal) (Money, error) {
+	total := Money{Amount: decimal.Zero, Currency: target}
+
+	var errs []error
+	failed := make(map[currency.Unit]bool)
+
+	for _, item := range c.Items {
+		amount := item.Subtotal().Amount
+
+		if cur := item.Price.Currency; cur != target {
+			rate, ok := rates[cur.String()]
+			switch {
+			case failed[cur]:
+				continue
+			case !ok:
+				errs = append(errs, fmt.Errorf("rate[%s] is missing", cur))
+				failed[cur] = true
+				continue
+			case rate.LessThanOrEqual(decimal.Zero):
+				errs = append(errs, fmt.Errorf("rate[%s] is not positive", cur))
+				failed[cur] = true
+				continue
+			}
+
+			amount = amount.Mul(rate)
+		}
+
+		total.Amount = total.Amount.Add(amount)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Money{}, err
+	}
+
+	return total, nil
+}
+
+// OwnedCartItem is a cart item along with the owner of its cart.
+type OwnedCartItem struct {
+	OwnerID string
+	Item    CartItem
+}
+
+// CartLine is a cart item along with its line total, the price multiplied by the quantity in the price currency.
+type CartLine struct {
+	Item      CartItem
+	LineTotal Money
+}
+
+// ProductCount is the number of carts holding the product.
+type ProductCount struct {
+	ProductID uuid.UUID
+	CartCount int64
+}
+
+// ItemResult is the outcome of adding a single item in a batch, Err is nil on success.
+type ItemResult struct {
+	ProductID uuid.UUID
+	Err       error
+}
+
+// CurrencyReport lists the currencies of a cart sorted by code,
+// SingleCurrency is also true for an empty cart as there is nothing inconsistent in it.
+type CurrencyReport struct {
+	Currencies     []currency.Unit
+	SingleCurrency bool
+}
+
+// CartsEqual reports whether both carts hold the same products at the same prices and quantities,
+// ignoring item order, owners and CreatedAt.
+func CartsEqual(a, b Cart) bool {
+	if len(a.Items) != len(b.Items) {
+		return false
+	}
+
+	byProduct := make(map[uuid.UUID]CartItem, len(a.Items))
+	for _, item := range a.Items {
+		byProduct[item.ProductID] = item
+	}
+
+	for _, item := range b.Items {
+		other, ok := byProduct[item.ProductID]
+		if !ok || !other.Price.Equal(item.Price) || other.Quantity != item.Quantity {
+			return false
+		}
+		delete(byProduct, item.ProductID)
+	}
+
+	return true
 }
@@ -0,0 +1,346 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestCartsEqual(t *testing.T) {
+	first := cartItem(currency.USD, "10.50")
+	second := cartItem(currency.EUR, "3")
+
+	repriced := second
+	repriced.Price.Amount = decimal.RequireFromString("4")
+
+	recurrenced := second
+	recurrenced.Price.Currency = currency.USD
+
+	requantified := second
+	requantified.Quantity = 2
+
+	sameAmountOtherScale := first
+	sameAmountOtherScale.Price.Amount = decimal.RequireFromString("10.500")
+
+	tests := []struct {
+		name string
+		a    domain.Cart
+		b    domain.Cart
+		want bool
+	}{
+		{
+			name: "empty carts: equal",
+			want: true,
+		},
+		{
+			name: "same items: equal",
+			a:    domain.Cart{OwnerID: "a", Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{OwnerID: "b", Items: []domain.CartItem{first, second}},
+			want: true,
+		},
+		{
+			name: "reordered items: equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{second, first}},
+			want: true,
+		},
+		{
+			name: "different created at and amount scale: equal",
+			a:    domain.Cart{Items: []domain.CartItem{first}},
+			b:    domain.Cart{Items: []domain.CartItem{withCreatedAt(sameAmountOtherScale)}},
+			want: true,
+		},
+		{
+			name: "different amount: not equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{first, repriced}},
+		},
+		{
+			name: "different currency: not equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{first, recurrenced}},
+		},
+		{
+			name: "different quantity: not equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{first, requantified}},
+		},
+		{
+			name: "missing item: not equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{first}},
+		},
+		{
+			name: "duplicated item instead of another: not equal",
+			a:    domain.Cart{Items: []domain.CartItem{first, second}},
+			b:    domain.Cart{Items: []domain.CartItem{first, first}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, domain.CartsEqual(tt.a, tt.b))
+			assert.Equal(t, tt.want, domain.CartsEqual(tt.b, tt.a))
+		})
+	}
+}
+
+func TestCartTotal(t *testing.T) {
+	doubled := cartItem(currency.USD, "2.50")
+	doubled.Quantity = 2
+
+	tests := []struct {
+		name       string
+		items      []domain.CartItem
+		want       domain.Money
+		wantTarget error
+	}{
+		{
+			name: "empty cart: zero",
+			want: domain.Money{Amount: decimal.Zero},
+		},
+		{
+			name:  "single currency: sum of prices times quantities",
+			items: []domain.CartItem{cartItem(currency.USD, "10.50"), doubled, cartItem(currency.USD, "-1")},
+			want:  domain.Money{Amount: decimal.RequireFromString("14.50"), Currency: currency.USD},
+		},
+		{
+			name:       "mixed currencies: error",
+			items:      []domain.CartItem{cartItem(currency.USD, "1"), cartItem(currency.EUR, "1")},
+			wantTarget: domain.ErrMixedCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, err := domain.Cart{Items: tt.items}.Total()
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				require.ErrorIs(t, err, domain.ErrCurrencyMismatch)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(total), "want %v, got %v", tt.want, total)
+		})
+	}
+}
+
+func TestCartItemSubtotal(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int32
+		want     domain.Money
+	}{
+		{
+			name:     "quantity 1: price",
+			quantity: 1,
+			want:     money(currency.USD, "2.50"),
+		},
+		{
+			name:     "quantity 3: price times 3",
+			quantity: 3,
+			want:     money(currency.USD, "7.50"),
+		},
+		{
+			name:     "quantity 0: zero",
+			quantity: 0,
+			want:     money(currency.USD, "0"),
+		},
+		{
+			name:     "negative quantity: zero",
+			quantity: -2,
+			want:     money(currency.USD, "0"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := cartItem(currency.USD, "2.50")
+			item.Quantity = tt.quantity
+
+			subtotal := item.Subtotal()
+			assert.True(t, tt.want.Equal(subtotal), "want %v, got %v", tt.want, subtotal)
+		})
+	}
+}
+
+func TestCartTotalIn(t *testing.T) {
+	rates := map[string]decimal.Decimal{
+		"EUR": decimal.RequireFromString("1.10"),
+		"GBP": decimal.RequireFromString("1.25"),
+		"CHF": decimal.Zero,
+	}
+
+	doubled := cartItem(currency.EUR, "2.50")
+	doubled.Quantity = 2
+
+	tests := []struct {
+		name      string
+		items     []domain.CartItem
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name: "empty cart: zero in target currency",
+			want: domain.Money{Amount: decimal.Zero, Currency: currency.USD},
+		},
+		{
+			name:  "target currency: passed through without rate",
+			items: []domain.CartItem{cartItem(currency.USD, "10.50")},
+			want:  domain.Money{Amount: decimal.RequireFromString("10.50"), Currency: currency.USD},
+		},
+		{
+			name:  "other currencies: converted and summed",
+			items: []domain.CartItem{cartItem(currency.USD, "1"), doubled, cartItem(currency.GBP, "4")},
+			want:  domain.Money{Amount: decimal.RequireFromString("11.50"), Currency: currency.USD},
+		},
+		{
+			name:      "missing rates: error naming each currency",
+			items:     []domain.CartItem{cartItem(currency.JPY, "100"), cartItem(currency.EUR, "1"), cartItem(currency.JPY, "5"), cartItem(currency.SEK, "1")},
+			wantError: "rate[JPY] is missing\nrate[SEK] is missing",
+		},
+		{
+			name:      "zero rate: error",
+			items:     []domain.CartItem{cartItem(currency.CHF, "1")},
+			wantError: "rate[CHF] is not positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, err := domain.Cart{Items: tt.items}.TotalIn(currency.USD, rates)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(total), "want %v, got %v", tt.want, total)
+		})
+	}
+}
+
+func TestCartItemValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(*domain.CartItem)
+		wantError string
+	}{
+		{
+			name: "valid item: ok",
+		},
+		{
+			name:      "nil product ID: error",
+			modify:    func(i *domain.CartItem) { i.ProductID = uuid.Nil },
+			wantError: "productID is empty",
+		},
+		{
+			name:      "zero quantity: error",
+			modify:    func(i *domain.CartItem) { i.Quantity = 0 },
+			wantError: "quantity[0] is not positive",
+		},
+		{
+			name:      "negative price: error",
+			modify:    func(i *domain.CartItem) { i.Price.Amount = decimal.RequireFromString("-1.5") },
+			wantError: "price amount[-1.5] is not positive",
+		},
+		{
+			name:      "zero price: error",
+			modify:    func(i *domain.CartItem) { i.Price.Amount = decimal.Zero },
+			wantError: "price amount[0] is not positive",
+		},
+		{
+			name:      "XXX currency: error",
+			modify:    func(i *domain.CartItem) { i.Price.Currency = currency.XXX },
+			wantError: "currency is empty",
+		},
+		{
+			name: "several problems: all joined",
+			modify: func(i *domain.CartItem) {
+				i.ProductID = uuid.Nil
+				i.Price = domain.Money{}
+			},
+			wantError: "productID is empty\nprice amount[0] is not positive\ncurrency is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := cartItem(currency.USD, "9.99")
+			if tt.modify != nil {
+				tt.modify(&item)
+			}
+
+			err := item.Validate()
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestCartItemJSON(t *testing.T) {
+	item := domain.CartItem{
+		ProductID: uuid.MustParse("4f7b8a2e-9c1d-4e5f-8a6b-1c2d3e4f5a6b"),
+		Price: domain.Money{
+			Amount:   decimal.RequireFromString("-1234.567890123456789"),
+			Currency: currency.EUR,
+		},
+		Quantity:  2,
+		CreatedAt: time.Date(2025, 3, 4, 5, 6, 7, 123456000, time.UTC),
+	}
+
+	data, err := json.Marshal(item)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"product_id": "4f7b8a2e-9c1d-4e5f-8a6b-1c2d3e4f5a6b",
+		"price": {"amount": "-1234.567890123456789", "currency": "EUR"},
+		"quantity": 2,
+		"created_at": "2025-03-04T05:06:07.123456Z"
+	}`, string(data))
+
+	var got domain.CartItem
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, item.ProductID, got.ProductID)
+	assert.True(t, item.Price.Equal(got.Price), "want %v, got %v", item.Price, got.Price)
+	assert.Equal(t, item.Quantity, got.Quantity)
+	assert.True(t, item.CreatedAt.Equal(got.CreatedAt))
+
+	t.Run("invalid currency: error", func(t *testing.T) {
+		data := `{"product_id":"4f7b8a2e-9c1d-4e5f-8a6b-1c2d3e4f5a6b","price":{"amount":"1","currency":"usd1"}}`
+
+		var got domain.CartItem
+		require.Error(t, json.Unmarshal([]byte(data), &got))
+	})
+
+	t.Run("created at not RFC 3339: error", func(t *testing.T) {
+		data := `{"price":{"amount":"1","currency":"USD"},"created_at":"04.03.2025"}`
+
+		var got domain.CartItem
+		require.Error(t, json.Unmarshal([]byte(data), &got))
+	})
+}
+
+func cartItem(unit currency.Unit, amount string) domain.CartItem {
+	return domain.CartItem{
+		ProductID: uuid.New(),
+		Price: domain.Money{
+			Amount:   decimal.RequireFromString(amount),
+			Currency: unit,
+		},
+		Quantity: 1,
+	}
+}
+
+func withCreatedAt(item domain.CartItem) domain.CartItem {
+	item.CreatedAt = item.CreatedAt.AddDate(0, 0, 1)
+	return item
+}
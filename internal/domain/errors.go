@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrItemNotFound     = errors.New("item not found")
+	ErrCartNotFound     = errors.New("cart not found")
+	ErrEmptyCart        = errors.New("cart is empty")
+	ErrMixedCurrency    = errors.New("cart has mixed currencies")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrVersionConflict  = errors.New("version conflict")
+)
+
+// NegativeQuantityError is returned when a quantity delta would take the item of the product below zero.
+type NegativeQuantityError struct {
+	ProductID uuid.UUID
+	Quantity  int32
+	Delta     int32
+}
+
+func (e *NegativeQuantityError) Error() string {
+	return fmt.Sprintf("product[%s] quantity[%d] would be negative after delta[%d]", e.ProductID, e.Quantity, e.Delta)
+}
@@ -1,11 +1,159 @@
 package domain
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+
 	"github.com/shopspring/decimal"
 	"golang.org/x/text/currency"
 )
 
+var (
+	_ driver.Valuer    = Money{}
+	_ sql.Scanner      = (*Money)(nil)
+	_ json.Marshaler   = Money{}
+	_ json.Unmarshaler = (*Money)(nil)
+)
+
 type Money struct {
 	Amount   decimal.Decimal
 	Currency currency.Unit
 }
+
+// NewMoney parses the ISO 4217 currency code of a stored amount.
+func NewMoney(amount decimal.Decimal, code string) (Money, error) {
+	cur, err := ParseCurrency(code)
+	if err != nil {
+		return Money{}, err
+	}
+
+	return Money{
+		Amount:   amount,
+		Currency: cur,
+	}, nil
+}
+
+// ParseCurrency parses an ISO 4217 currency code, the error names the rejected code.
+func ParseCurrency(code string) (currency.Unit, error) {
+	cur, err := currency.ParseISO(code)
+	if err != nil {
+		return currency.Unit{}, fmt.Errorf("currency[%s] is not valid: %w", code, err)
+	}
+
+	return cur, nil
+}
+
+// Equal reports whether both values have the same currency and numerically equal amounts.
+func (m Money) Equal(other Money) bool {
+	return m.Currency == other.Currency && m.Amount.Equal(other.Amount)
+}
+
+// Add returns the sum of both values, which must have the same currency.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s vs %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+
+	return Money{
+		Amount:   m.Amount.Add(other.Amount),
+		Currency: m.Currency,
+	}, nil
+}
+
+// Multiply returns the amount multiplied by the factor in the same currency, keeping the full precision
+// of the product, Round it if needed.
+func (m Money) Multiply(factor decimal.Decimal) Money {
+	return Money{
+		Amount:   m.Amount.Mul(factor),
+		Currency: m.Currency,
+	}
+}
+
+// Round rounds the amount half away from zero to the decimal places of the currency, e.g. 2 for USD,
+// 0 for JPY and 3 for BHD.
+func (m Money) Round() Money {
+	scale, _ := currency.Standard.Rounding(m.Currency)
+
+	return Money{
+		Amount:   m.Amount.Round(int32(scale)),
+		Currency: m.Currency,
+	}
+}
+
+// moneyJSON keeps the amount a string, so it does not lose precision as a JSON number.
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{
+		Amount:   m.Amount.String(),
+		Currency: m.Currency.String(),
+	})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var v moneyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	money, err := parseMoney(v.Amount, v.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = money
+	return nil
+}
+
+// Value encodes the money in the text format of a Postgres composite of an amount and a currency code,
+// e.g. (12.34,USD).
+func (m Money) Value() (driver.Value, error) {
+	return fmt.Sprintf("(%s,%s)", m.Amount.String(), m.Currency.String()), nil
+}
+
+// Scan decodes the format written by Value.
+func (m *Money) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		return fmt.Errorf("money is NULL")
+	default:
+		return fmt.Errorf("money type[%T] is not supported", src)
+	}
+
+	fields, ok := strings.CutPrefix(s, "(")
+	if ok {
+		fields, ok = strings.CutSuffix(fields, ")")
+	}
+	amount, code, found := strings.Cut(fields, ",")
+	if !ok || !found {
+		return fmt.Errorf("money[%s] is not a (amount,currency) pair", s)
+	}
+
+	money, err := parseMoney(amount, code)
+	if err != nil {
+		return err
+	}
+
+	*m = money
+	return nil
+}
+
+func parseMoney(amount, code string) (Money, error) {
+	parsedAmount, err := decimal.NewFromString(amount)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount[%s] is not valid: %w", amount, err)
+	}
+
+	return NewMoney(parsedAmount, code)
+}
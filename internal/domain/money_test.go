@@ -0,0 +1,329 @@
+package domain_test
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		a         domain.Money
+		b         domain.Money
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name: "same currency: summed",
+			a:    money(currency.USD, "10.50"),
+			b:    money(currency.USD, "0.75"),
+			want: money(currency.USD, "11.25"),
+		},
+		{
+			name: "negative amount: subtracted",
+			a:    money(currency.EUR, "3"),
+			b:    money(currency.EUR, "-5"),
+			want: money(currency.EUR, "-2"),
+		},
+		{
+			name:      "different currencies: error",
+			a:         money(currency.USD, "1"),
+			b:         money(currency.EUR, "1"),
+			wantError: "currency mismatch: USD vs EUR",
+		},
+		{
+			name:      "zero value currency and USD: error",
+			a:         domain.Money{Amount: decimal.RequireFromString("1")},
+			b:         money(currency.USD, "1"),
+			wantError: "currency mismatch: XXX vs USD",
+		},
+		{
+			name: "both zero values: zero",
+			want: domain.Money{Amount: decimal.Zero},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a, tt.b
+
+			sum, err := tt.a.Add(tt.b)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, domain.ErrCurrencyMismatch)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.Equal(sum), "want %v, got %v", tt.want, sum)
+			// the operands are not modified
+			assert.Equal(t, a, tt.a)
+			assert.Equal(t, b, tt.b)
+		})
+	}
+}
+
+func TestMoneyMultiply(t *testing.T) {
+	tests := []struct {
+		name   string
+		money  domain.Money
+		factor string
+		want   domain.Money
+	}{
+		{
+			name:   "integer factor: ok",
+			money:  money(currency.USD, "10.25"),
+			factor: "3",
+			want:   money(currency.USD, "30.75"),
+		},
+		{
+			name:   "fractional factor: 50% discount",
+			money:  money(currency.EUR, "19.99"),
+			factor: "0.5",
+			want:   money(currency.EUR, "9.995"),
+		},
+		{
+			name:   "zero factor: zero",
+			money:  money(currency.USD, "10.25"),
+			factor: "0",
+			want:   money(currency.USD, "0"),
+		},
+		{
+			name:   "fractional amount and factor: full precision",
+			money:  money(currency.JPY, "1.5"),
+			factor: "0.333",
+			want:   money(currency.JPY, "0.4995"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.money
+
+			product := tt.money.Multiply(decimal.RequireFromString(tt.factor))
+			assert.True(t, tt.want.Equal(product), "want %v, got %v", tt.want, product)
+			// the receiver is not modified
+			assert.Equal(t, m, tt.money)
+		})
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	bhd := currency.MustParseISO("BHD")
+
+	tests := []struct {
+		name  string
+		money domain.Money
+		want  domain.Money
+	}{
+		{
+			name:  "USD: 2 places",
+			money: money(currency.USD, "10.125"),
+			want:  money(currency.USD, "10.13"),
+		},
+		{
+			name:  "negative USD: half away from zero",
+			money: money(currency.USD, "-10.125"),
+			want:  money(currency.USD, "-10.13"),
+		},
+		{
+			name:  "USD with fewer places: unchanged",
+			money: money(currency.USD, "3.5"),
+			want:  money(currency.USD, "3.5"),
+		},
+		{
+			name:  "JPY: 0 places",
+			money: money(currency.JPY, "1234.5"),
+			want:  money(currency.JPY, "1235"),
+		},
+		{
+			name:  "BHD: 3 places",
+			money: money(bhd, "1.23456"),
+			want:  money(bhd, "1.235"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rounded := tt.money.Round()
+			assert.True(t, tt.want.Equal(rounded), "want %v, got %v", tt.want, rounded)
+		})
+	}
+}
+
+func TestMoneyValueScan(t *testing.T) {
+	units := []currency.Unit{currency.USD, currency.EUR, currency.GBP, currency.JPY, currency.CHF}
+
+	values := []domain.Money{
+		money(currency.USD, "0"),
+		money(currency.EUR, "-12.34"),
+		money(currency.GBP, "123456789.123456789"),
+	}
+	for range 20 {
+		amount := decimal.New(rand.Int64N(2_000_000)-1_000_000, -rand.Int32N(5))
+		values = append(values, domain.Money{Amount: amount, Currency: units[rand.IntN(len(units))]})
+	}
+
+	for _, want := range values {
+		value, err := want.Value()
+		require.NoError(t, err)
+
+		var got domain.Money
+		require.NoError(t, got.Scan(value))
+		assert.True(t, want.Equal(got), "want %v, got %v", want, got)
+
+		// drivers may hand over text columns as bytes
+		got = domain.Money{}
+		require.NoError(t, got.Scan([]byte(value.(string))))
+		assert.True(t, want.Equal(got), "want %v, got %v", want, got)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       any
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name: "valid pair: ok",
+			src:  "(12.34,USD)",
+			want: money(currency.USD, "12.34"),
+		},
+		{
+			name:      "malformed currency: error",
+			src:       "(12.34,US)",
+			wantError: "currency[US] is not valid: currency: tag is not well-formed",
+		},
+		{
+			name:      "unknown currency: error",
+			src:       "(12.34,ABC)",
+			wantError: "currency[ABC] is not valid: currency: tag is not a recognized currency",
+		},
+		{
+			name:      "malformed amount: error",
+			src:       "(twelve,USD)",
+			wantError: "amount[twelve] is not valid: can't convert twelve to decimal: exponent is not numeric",
+		},
+		{
+			name:      "missing parentheses: error",
+			src:       "12.34,USD",
+			wantError: "money[12.34,USD] is not a (amount,currency) pair",
+		},
+		{
+			name:      "missing currency: error",
+			src:       "(12.34)",
+			wantError: "money[(12.34)] is not a (amount,currency) pair",
+		},
+		{
+			name:      "null: error",
+			src:       nil,
+			wantError: "money is NULL",
+		},
+		{
+			name:      "unsupported type: error",
+			src:       12.34,
+			wantError: "money type[float64] is not supported",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got domain.Money
+			err := got.Scan(tt.src)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "want %v, got %v", tt.want, got)
+		})
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		money domain.Money
+		want  string
+	}{
+		{
+			name:  "positive amount: ok",
+			money: money(currency.USD, "12.34"),
+			want:  `{"amount":"12.34","currency":"USD"}`,
+		},
+		{
+			name:  "negative amount: ok",
+			money: money(currency.EUR, "-0.5"),
+			want:  `{"amount":"-0.5","currency":"EUR"}`,
+		},
+		{
+			name:  "high precision amount: kept",
+			money: money(currency.GBP, "12345678901234567890.123456789012345678"),
+			want:  `{"amount":"12345678901234567890.123456789012345678","currency":"GBP"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.money)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(data))
+
+			var got domain.Money
+			require.NoError(t, json.Unmarshal(data, &got))
+			assert.True(t, tt.money.Equal(got), "want %v, got %v", tt.money, got)
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantError string
+	}{
+		{
+			name:      "unknown currency: error",
+			data:      `{"amount":"1","currency":"ABC"}`,
+			wantError: "currency[ABC] is not valid: currency: tag is not a recognized currency",
+		},
+		{
+			name:      "missing currency: error",
+			data:      `{"amount":"1"}`,
+			wantError: "currency[] is not valid: currency: tag is not well-formed",
+		},
+		{
+			name:      "amount as number: error",
+			data:      `{"amount":1.5,"currency":"USD"}`,
+			wantError: "json.Unmarshal: json: cannot unmarshal number into Go struct field moneyJSON.amount of type string",
+		},
+		{
+			name:      "malformed amount: error",
+			data:      `{"amount":"1,5","currency":"USD"}`,
+			wantError: "amount[1,5] is not valid: can't convert 1,5 to decimal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got domain.Money
+			require.EqualError(t, json.Unmarshal([]byte(tt.data), &got), tt.wantError)
+		})
+	}
+}
+
+func money(unit currency.Unit, amount string) domain.Money {
+	return domain.Money{
+		Amount:   decimal.RequireFromString(amount),
+		Currency: unit,
+	}
+}
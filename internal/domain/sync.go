@@ -0,0 +1,110 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// SyncPlan lists the operations turning a stored cart into a desired one.
+type SyncPlan struct {
+	Adds    []CartItem
+	Updates []CartItem
+	Deletes []uuid.UUID
+}
+
+// IsEmpty reports whether the stored cart already matches the desired one.
+func (p SyncPlan) IsEmpty() bool {
+	return len(p.Adds) == 0 && len(p.Updates) == 0 && len(p.Deletes) == 0
+}
+
+// PlanSync diffs the current items against the desired ones by product, an item is updated when its price
+// or quantity differs.
+func PlanSync(current, desired []CartItem) (SyncPlan, error) {
+	var plan SyncPlan
+
+	byProduct := make(map[uuid.UUID]CartItem, len(current))
+	for _, item := range current {
+		byProduct[item.ProductID] = item
+	}
+
+	seen := make(map[uuid.UUID]bool, len(desired))
+	for i, item := range desired {
+		if seen[item.ProductID] {
+			return SyncPlan{}, fmt.Errorf("desired[%d] product[%s] is duplicated", i, item.ProductID)
+		}
+		seen[item.ProductID] = true
+
+		stored, ok := byProduct[item.ProductID]
+		switch {
+		case !ok:
+			plan.Adds = append(plan.Adds, item)
+		case !stored.Price.Equal(item.Price) || stored.Quantity != item.Quantity:
+			plan.Updates = append(plan.Updates, item)
+		}
+	}
+
+	for _, item := range current {
+		if !seen[item.ProductID] {
+			plan.Deletes = append(plan.Deletes, item.ProductID)
+		}
+	}
+
+	return plan, nil
+}
+
+// CartChange lists the differences between two states of a cart by product, Added and PriceChanged in the order
+// of the new cart, Removed in the order of the old one.
+type CartChange struct {
+	Added        []CartItem
+	Removed      []CartItem
+	PriceChanged []PriceChange
+}
+
+// PriceChange is the price of a product in both states of a cart.
+type PriceChange struct {
+	ProductID uuid.UUID
+	OldPrice  Money
+	NewPrice  Money
+}
+
+// IsEmpty reports whether both states hold the same products at the same prices.
+func (c CartChange) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.PriceChanged) == 0
+}
+
+// Diff compares the items of both carts by product, a price changes when its currency or amount differs,
+// so 10.5 and 10.50 of the same currency are equal. Quantity changes are not reported.
+func Diff(oldCart, newCart Cart) CartChange {
+	var change CartChange
+
+	byProduct := make(map[uuid.UUID]CartItem, len(oldCart.Items))
+	for _, item := range oldCart.Items {
+		byProduct[item.ProductID] = item
+	}
+
+	seen := make(map[uuid.UUID]bool, len(newCart.Items))
+	for _, item := range newCart.Items {
+		seen[item.ProductID] = true
+
+		old, ok := byProduct[item.ProductID]
+		switch {
+		case !ok:
+			change.Added = append(change.Added, item)
+		case !old.Price.Equal(item.Price):
+			change.PriceChanged = append(change.PriceChanged, PriceChange{
+				ProductID: item.ProductID,
+				OldPrice:  old.Price,
+				NewPrice:  item.Price,
+			})
+		}
+	}
+
+	for _, item := range oldCart.Items {
+		if !seen[item.ProductID] {
+			change.Removed = append(change.Removed, item)
+		}
+	}
+
+	return change
+}
@@ -0,0 +1,177 @@
+package domain_test
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestPlanSync(t *testing.T) {
+	kept := cartItem(currency.USD, "10.50")
+	removed := cartItem(currency.USD, "3")
+	added := cartItem(currency.USD, "7")
+
+	repriced := cartItem(currency.USD, "5")
+	repricedDesired := repriced
+	repricedDesired.Price.Amount = decimal.RequireFromString("6")
+
+	requantified := cartItem(currency.USD, "8")
+	requantifiedDesired := requantified
+	requantifiedDesired.Quantity = 3
+
+	keptOtherScale := withCreatedAt(kept)
+	keptOtherScale.Price.Amount = decimal.RequireFromString("10.500")
+
+	tests := []struct {
+		name      string
+		current   []domain.CartItem
+		desired   []domain.CartItem
+		want      domain.SyncPlan
+		wantError string
+	}{
+		{
+			name: "both empty: empty plan",
+		},
+		{
+			name:    "same items: empty plan",
+			current: []domain.CartItem{kept, repriced},
+			desired: []domain.CartItem{keptOtherScale, repriced},
+		},
+		{
+			name:    "adds, updates and deletes: ok",
+			current: []domain.CartItem{kept, removed, repriced, requantified},
+			desired: []domain.CartItem{kept, added, repricedDesired, requantifiedDesired},
+			want: domain.SyncPlan{
+				Adds:    []domain.CartItem{added},
+				Updates: []domain.CartItem{repricedDesired, requantifiedDesired},
+				Deletes: []uuid.UUID{removed.ProductID},
+			},
+		},
+		{
+			name:    "empty desired: delete all",
+			current: []domain.CartItem{kept, removed},
+			want:    domain.SyncPlan{Deletes: []uuid.UUID{kept.ProductID, removed.ProductID}},
+		},
+		{
+			name:      "duplicated desired product: error",
+			desired:   []domain.CartItem{added, added},
+			wantError: "desired[1] product[" + added.ProductID.String() + "] is duplicated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := domain.PlanSync(tt.current, tt.desired)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, plan)
+			assert.Equal(t, tt.want.IsEmpty(), plan.IsEmpty())
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	kept := cartItem(currency.USD, "10.50")
+	removed := cartItem(currency.USD, "3")
+	added := cartItem(currency.USD, "7")
+
+	repriced := cartItem(currency.USD, "5")
+	repricedNew := repriced
+	repricedNew.Price.Amount = decimal.RequireFromString("6")
+
+	currencyChanged := cartItem(currency.USD, "5")
+	currencyChangedNew := currencyChanged
+	currencyChangedNew.Price.Currency = currency.EUR
+
+	keptOtherScale := withCreatedAt(kept)
+	keptOtherScale.Price.Amount = decimal.RequireFromString("10.500")
+	keptOtherScale.Quantity = 5
+
+	tests := []struct {
+		name   string
+		old    []domain.CartItem
+		new    []domain.CartItem
+		want   domain.CartChange
+		isSame bool
+	}{
+		{
+			name:   "both empty: no change",
+			isSame: true,
+		},
+		{
+			name:   "identical carts: no change",
+			old:    []domain.CartItem{kept, repriced},
+			new:    []domain.CartItem{repriced, kept},
+			isSame: true,
+		},
+		{
+			name:   "same price in other scale, quantity and CreatedAt: no change",
+			old:    []domain.CartItem{kept},
+			new:    []domain.CartItem{keptOtherScale},
+			isSame: true,
+		},
+		{
+			name: "item added: ok",
+			old:  []domain.CartItem{kept},
+			new:  []domain.CartItem{kept, added},
+			want: domain.CartChange{Added: []domain.CartItem{added}},
+		},
+		{
+			name: "item removed: ok",
+			old:  []domain.CartItem{kept, removed},
+			new:  []domain.CartItem{kept},
+			want: domain.CartChange{Removed: []domain.CartItem{removed}},
+		},
+		{
+			name: "amount changed: ok",
+			old:  []domain.CartItem{repriced},
+			new:  []domain.CartItem{repricedNew},
+			want: domain.CartChange{PriceChanged: []domain.PriceChange{
+				{ProductID: repriced.ProductID, OldPrice: repriced.Price, NewPrice: repricedNew.Price},
+			}},
+		},
+		{
+			name: "currency changed with same amount: ok",
+			old:  []domain.CartItem{currencyChanged},
+			new:  []domain.CartItem{currencyChangedNew},
+			want: domain.CartChange{PriceChanged: []domain.PriceChange{
+				{ProductID: currencyChanged.ProductID, OldPrice: currencyChanged.Price, NewPrice: currencyChangedNew.Price},
+			}},
+		},
+		{
+			name: "additions, removals and price changes: ok",
+			old:  []domain.CartItem{kept, removed, repriced},
+			new:  []domain.CartItem{added, repricedNew, kept},
+			want: domain.CartChange{
+				Added:   []domain.CartItem{added},
+				Removed: []domain.CartItem{removed},
+				PriceChanged: []domain.PriceChange{
+					{ProductID: repriced.ProductID, OldPrice: repriced.Price, NewPrice: repricedNew.Price},
+				},
+			},
+		},
+		{
+			name: "new cart empty: all removed",
+			old:  []domain.CartItem{kept, removed},
+			want: domain.CartChange{Removed: []domain.CartItem{kept, removed}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change := domain.Diff(domain.Cart{Items: tt.old}, domain.Cart{Items: tt.new})
+
+			assert.Equal(t, tt.want, change)
+			assert.Equal(t, tt.isSame, change.IsEmpty())
+		})
+	}
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS owner_settings
+(
+    owner_id         VARCHAR(255)                        NOT NULL,
+    default_currency VARCHAR(3)                          NOT NULL,
+    updated_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (owner_id)
+);
@@ -0,0 +1,6 @@
+ALTER TABLE cart_items
+    ADD COLUMN cart_name VARCHAR(255) DEFAULT 'default' NOT NULL;
+
+ALTER TABLE cart_items
+    DROP CONSTRAINT cart_items_pkey,
+    ADD PRIMARY KEY (owner_id, cart_name, product_id);
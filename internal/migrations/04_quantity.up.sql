@@ -0,0 +1,2 @@
+ALTER TABLE cart_items
+    ADD COLUMN quantity INTEGER DEFAULT 1 NOT NULL CHECK (quantity > 0);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS cart_item_idempotency
+(
+    owner_id        VARCHAR(255)                        NOT NULL,
+    idempotency_key VARCHAR(255)                        NOT NULL,
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (owner_id, idempotency_key)
+);
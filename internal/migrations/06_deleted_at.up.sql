@@ -0,0 +1,2 @@
+ALTER TABLE cart_items
+    ADD COLUMN deleted_at TIMESTAMPTZ;
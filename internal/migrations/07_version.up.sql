@@ -0,0 +1,2 @@
+ALTER TABLE cart_items
+    ADD COLUMN version INTEGER DEFAULT 1 NOT NULL;
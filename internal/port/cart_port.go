@@ -2,13 +2,145 @@ package port
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
 )
 
 type CartRepository interface {
+	// GetCart returns domain.ErrCartNotFound when the owner never added an item to the cart,
+	// an empty cart when all its items were deleted.
 	GetCart(ctx context.Context, ownerID string) (domain.Cart, error)
+	// GetCartWithLineTotals returns the cart items along with their line totals computed by the database, ordered by
+	// CreatedAt and ProductID. The currencies may differ between the lines, an empty cart gives an empty slice.
+	GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error)
+	// GetCartAfter returns up to limit items ordered by CreatedAt and ProductID, starting after the cursor.
+	// Zero cursor values start from the beginning, the last returned item gives the cursor of the next page.
+	GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error)
+	// GetCartInRange returns the items created in [from, to) ordered by CreatedAt and ProductID.
+	// A zero from or to leaves the window open on that side.
+	GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error)
+	// AddItem adds the item quantity to the one already in the cart, replacing the price and the note.
+	// It falls back to the owner's default currency, see SetOwnerCurrency, when the item currency is the zero value.
 	AddItem(ctx context.Context, ownerID string, item domain.CartItem) error
+	// ValidateAddItem returns the validation error AddItem would, without writing. An item without a currency
+	// is validated with the owner's default currency.
+	ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error
+	// SetItem sets the item price, quantity and note regardless of the ones already in the cart, keeping its CreatedAt.
+	// Unlike AddItem, the item currency is required.
+	SetItem(ctx context.Context, ownerID string, item domain.CartItem) error
+	// AddOrRemoveItem sets the item like SetItem, a zero quantity soft-deletes it like DeleteItem instead.
+	// Removing an item not in the cart is not an error, a negative quantity is an error like in SetItem.
+	AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error
+	// ImportItem sets the item like SetItem, storing its CreatedAt instead of the current time when it is not zero,
+	// e.g. to import carts kept elsewhere. The imported CreatedAt replaces the one of an item already in the cart.
+	ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error
+	// AddItemIdempotent adds the item like AddItem once per owner and idempotency key,
+	// calls repeating a key already used by the owner do nothing.
+	AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error
+	// AddItemReturning returns the item as stored after the upsert, with the summed quantity.
+	AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error)
+	// AddItemReturningPrevious returns the item as it was before the upsert, nil when it was not in the cart.
+	AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error)
+	// AddItemsBestEffort adds the items one by one, reporting a result per item instead of stopping at the first failure.
+	AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error)
+	// GetItem returns the item of the product along with its Version, domain.ErrItemNotFound when it is not in the cart.
+	GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error)
+	// UpdateItemPrice keeps the item CreatedAt, it reports a missing item like DeleteItem.
+	UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error)
+	// UpdateItemPriceIfVersion updates the price only while the item has the expected version, see GetItem,
+	// and returns the new version. It fails with domain.ErrVersionConflict when the item changed meanwhile.
+	UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error)
+	// DeleteItem keeps the item as soft-deleted, hidden from every read until RestoreItem or adding it again.
+	// It also returns domain.ErrItemNotFound when the item is not in the cart,
+	// the bool is kept for compatibility and will be removed.
 	DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error)
+	// DecrementItem subtracts by from the item quantity and returns the item, deleting it when nothing is left.
+	// A zero CartItem and a nil error are returned when the item was deleted, domain.ErrItemNotFound when it is not in the cart.
+	DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error)
+	// ApplyQuantityDeltas adds the signed deltas to the item quantities at once, deleting the items left with nothing.
+	// Every product must be in the cart, domain.ErrItemNotFound is returned otherwise, and a delta taking a quantity
+	// below zero fails with *domain.NegativeQuantityError naming the product. Nothing is changed on error.
+	ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error
+	// SwapQuantities exchanges the quantities of the items of two products at once, their prices stay as they are.
+	// Both products must be in the cart, domain.ErrItemNotFound is returned otherwise.
+	SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error
+	// DeleteItems soft-deletes the items of the products like DeleteItem, returning how many were deleted.
+	// Products not in the cart are skipped.
+	DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error)
+	// RestoreItem undoes DeleteItem, domain.ErrItemNotFound is returned when the item is not soft-deleted.
+	RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error
+	// GetItemsByCurrency returns the items priced in the currency, an empty slice when there are none.
+	GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error)
+	// StreamCart returns domain.ErrCartNotFound like GetCart when nothing was streamed.
+	StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error
+	ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error)
+	// ApplyPriceFactor multiplies the prices of the items in the currency by the factor at once, keeping the full
+	// precision of the product like domain.Money.Multiply, and returns how many were updated.
+	ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error)
+	CountItems(ctx context.Context, ownerID string) (int64, error)
+	// CartExists reports whether the cart has any items, without fetching or counting them.
+	CartExists(ctx context.Context, ownerID string) (bool, error)
+	// ClearCart deletes all items of the cart at once, returning how many were deleted.
+	ClearCart(ctx context.Context, ownerID string) (int64, error)
+	// MoveItems moves all items of one owner's cart into another owner's cart, returning how many were moved.
+	// The quantities of a product in both carts are summed, keeping the price of the target cart.
+	// The source items are soft-deleted like ClearCart does.
+	MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error)
+	// PurgeCartsOlderThan removes the carts whose newest active item was added before the cutoff for good, deleted
+	// items included, returning how many items were removed. Deleted items do not keep a cart, so a cart holding
+	// only deleted items is removed whatever their age.
+	PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error)
+	CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error)
+	// CartCurrency returns the currency of a single-currency cart, domain.ErrEmptyCart or domain.ErrMixedCurrency otherwise.
+	CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error)
+	// GetCartTotal sums the cart prices, the cart must be single-currency, an empty cart totals to zero.
+	GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error)
+	// GetCartTotalIn sums the cart prices converted to the target currency with the caller's rates,
+	// keyed by currency code. An error names every currency of the cart missing from the rates.
+	GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error)
+	// TotalExcluding sums the cart prices except for the excluded products, the remaining items must be
+	// single-currency. Excluding every item totals to zero like an empty cart.
+	TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error)
+	ListCartNames(ctx context.Context, ownerID string) ([]string, error)
+	// ListOwners pages through the owners having items in the cart, sorted by owner ID for stable pages.
+	ListOwners(ctx context.Context, limit, offset int32) ([]string, error)
+
+	// PlanSync diffs the stored cart against the desired items without changing it, ApplySync executes the plan.
+	PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error)
+	ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error
+	// ReplaceCart replaces all items of the cart at once, product IDs must not repeat.
+	ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error
+
+	// ValidateProducts returns the cart products which exists reports as missing or does not report at all.
+	ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error)
+	ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error)
+	// ItemRecencyRank is 1 for the most recently added item of the cart.
+	ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error)
+
+	// ProductWeightedAvgPrice averages the product price across all carts holding it in the given currency.
+	ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error)
+	// DemandByProduct counts the product units across all carts, products not in any cart get 0.
+	DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error)
+	// TopProducts lists the products held by the most carts, ties ordered by ProductID.
+	TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error)
+	// GetCartsByOwners reads the carts of several owners at once, owners without items get an empty cart.
+	GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error)
+	// GetRecentItems returns up to limit most recently added items of every owner and cart, newest first.
+	GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error)
+	// DistinctCurrencies lists the currencies used in any cart sorted by code, an invalid stored code is an error.
+	DistinctCurrencies(ctx context.Context) ([]currency.Unit, error)
+
+	// SetOwnerCurrency sets the owner's default currency, replacing the one set before.
+	SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error
+	// GetOwnerCurrency returns the owner's default currency, false when none is set.
+	GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error)
+
+	// HealthCheck fails when the database is unreachable, e.g. for a readiness probe.
+	HealthCheck(ctx context.Context) error
+	// Shutdown stops accepting new calls and waits for the in-flight ones before releasing the database.
+	Shutdown(ctx context.Context) error
 }
@@ -0,0 +1,535 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerSettings struct {
+	// MinRequests is the number of calls needed before FailureRatio is evaluated.
+	MinRequests uint32
+	// FailureRatio opens the breaker once failed/total calls reaches it, in (0, 1].
+	FailureRatio float64
+	// Interval resets the counters while closed, zero keeps counting until the breaker opens.
+	Interval time.Duration
+	// OpenTimeout is how long the breaker rejects calls before letting a single probe through.
+	OpenTimeout time.Duration
+	// Clock defaults to SystemClock.
+	Clock Clock
+}
+
+type cartBreaker struct {
+	inner   port.CartRepository
+	breaker *circuitBreaker
+}
+
+// NewCartWithBreaker wraps the given CartRepository with a circuit breaker which fails fast with ErrCircuitOpen
+// while the underlying repository keeps failing. Only database failures count as failed calls, errors of
+// the caller such as validation failures or version conflicts count as successful ones.
+func NewCartWithBreaker(inner port.CartRepository, settings BreakerSettings) (port.CartRepository, error) {
+	if inner == nil {
+		return nil, fmt.Errorf("inner is nil")
+	}
+	if settings.MinRequests == 0 {
+		return nil, fmt.Errorf("settings.MinRequests must be positive")
+	}
+	if settings.FailureRatio <= 0 || settings.FailureRatio > 1 {
+		return nil, fmt.Errorf("settings.FailureRatio[%v] must be in (0, 1]", settings.FailureRatio)
+	}
+	if settings.OpenTimeout <= 0 {
+		return nil, fmt.Errorf("settings.OpenTimeout must be positive")
+	}
+	if settings.Clock == nil {
+		settings.Clock = SystemClock
+	}
+
+	return &cartBreaker{
+		inner:   inner,
+		breaker: &circuitBreaker{settings: settings, windowStart: settings.Clock.Now()},
+	}, nil
+}
+
+func (r *cartBreaker) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	return withBreaker(r.breaker, func() (domain.Cart, error) {
+		return r.inner.GetCart(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error) {
+	return withBreaker(r.breaker, func() ([]domain.CartLine, error) {
+		return r.inner.GetCartWithLineTotals(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	return withBreaker(r.breaker, func() ([]domain.CartItem, error) {
+		return r.inner.GetCartAfter(ctx, ownerID, afterCreatedAt, afterProductID, limit)
+	})
+}
+
+func (r *cartBreaker) GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	return withBreaker(r.breaker, func() ([]domain.CartItem, error) {
+		return r.inner.GetCartInRange(ctx, ownerID, from, to)
+	})
+}
+
+func (r *cartBreaker) AddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartBreaker) ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.ValidateAddItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartBreaker) SetItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.SetItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartBreaker) AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddOrRemoveItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartBreaker) ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.ImportItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartBreaker) AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddItemIdempotent(ctx, ownerID, item, idempotencyKey)
+	})
+	return err
+}
+
+func (r *cartBreaker) AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	return withBreaker(r.breaker, func() (domain.CartItem, error) {
+		return r.inner.AddItemReturning(ctx, ownerID, item)
+	})
+}
+
+func (r *cartBreaker) AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	return withBreaker(r.breaker, func() (*domain.CartItem, error) {
+		return r.inner.AddItemReturningPrevious(ctx, ownerID, item)
+	})
+}
+
+func (r *cartBreaker) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	return withBreaker(r.breaker, func() ([]domain.ItemResult, error) {
+		return r.inner.AddItemsBestEffort(ctx, ownerID, items)
+	})
+}
+
+func (r *cartBreaker) GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	return withBreaker(r.breaker, func() (domain.CartItem, error) {
+		return r.inner.GetItem(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartBreaker) UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	return withBreaker(r.breaker, func() (bool, error) {
+		return r.inner.UpdateItemPrice(ctx, ownerID, productID, newPrice)
+	})
+}
+
+func (r *cartBreaker) UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	return withBreaker(r.breaker, func() (int32, error) {
+		return r.inner.UpdateItemPriceIfVersion(ctx, ownerID, productID, newPrice, expectedVersion)
+	})
+}
+
+func (r *cartBreaker) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	return withBreaker(r.breaker, func() (bool, error) {
+		return r.inner.DeleteItem(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartBreaker) DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	return withBreaker(r.breaker, func() (domain.CartItem, error) {
+		return r.inner.DecrementItem(ctx, ownerID, productID, by)
+	})
+}
+
+func (r *cartBreaker) ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.ApplyQuantityDeltas(ctx, ownerID, deltas)
+	})
+	return err
+}
+
+func (r *cartBreaker) SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.SwapQuantities(ctx, ownerID, productA, productB)
+	})
+	return err
+}
+
+func (r *cartBreaker) DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.DeleteItems(ctx, ownerID, productIDs)
+	})
+}
+
+func (r *cartBreaker) RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.RestoreItem(ctx, ownerID, productID)
+	})
+	return err
+}
+
+func (r *cartBreaker) GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	return withBreaker(r.breaker, func() ([]domain.CartItem, error) {
+		return r.inner.GetItemsByCurrency(ctx, ownerID, cur)
+	})
+}
+
+func (r *cartBreaker) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.StreamCart(ctx, ownerID, fn)
+	})
+	return err
+}
+
+func (r *cartBreaker) ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error) {
+	return withBreaker(r.breaker, func() (int, error) {
+		return r.inner.ClearCurrency(ctx, ownerID, cur)
+	})
+}
+
+func (r *cartBreaker) ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.ApplyPriceFactor(ctx, ownerID, cur, factor)
+	})
+}
+
+func (r *cartBreaker) CartExists(ctx context.Context, ownerID string) (bool, error) {
+	return withBreaker(r.breaker, func() (bool, error) {
+		return r.inner.CartExists(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) CountItems(ctx context.Context, ownerID string) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.CountItems(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) ClearCart(ctx context.Context, ownerID string) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.ClearCart(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.MoveItems(ctx, fromOwnerID, toOwnerID)
+	})
+}
+
+func (r *cartBreaker) PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	return withBreaker(r.breaker, func() (int64, error) {
+		return r.inner.PurgeCartsOlderThan(ctx, cutoff)
+	})
+}
+
+func (r *cartBreaker) CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error) {
+	return withBreaker(r.breaker, func() (domain.CurrencyReport, error) {
+		return r.inner.CartCurrencyReport(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error) {
+	return withBreaker(r.breaker, func() (currency.Unit, error) {
+		return r.inner.CartCurrency(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	return withBreaker(r.breaker, func() (domain.Money, error) {
+		return r.inner.GetCartTotal(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	return withBreaker(r.breaker, func() (domain.Money, error) {
+		return r.inner.GetCartTotalIn(ctx, ownerID, target, rates)
+	})
+}
+
+func (r *cartBreaker) TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	return withBreaker(r.breaker, func() (domain.Money, error) {
+		return r.inner.TotalExcluding(ctx, ownerID, excluded)
+	})
+}
+
+func (r *cartBreaker) ListCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	return withBreaker(r.breaker, func() ([]string, error) {
+		return r.inner.ListCartNames(ctx, ownerID)
+	})
+}
+
+func (r *cartBreaker) ListOwners(ctx context.Context, limit, offset int32) ([]string, error) {
+	return withBreaker(r.breaker, func() ([]string, error) {
+		return r.inner.ListOwners(ctx, limit, offset)
+	})
+}
+
+func (r *cartBreaker) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	return withBreaker(r.breaker, func() (domain.SyncPlan, error) {
+		return r.inner.PlanSync(ctx, ownerID, desired)
+	})
+}
+
+func (r *cartBreaker) ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.ApplySync(ctx, ownerID, plan)
+	})
+	return err
+}
+
+func (r *cartBreaker) ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.ReplaceCart(ctx, ownerID, items)
+	})
+	return err
+}
+
+func (r *cartBreaker) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	return withBreaker(r.breaker, func() ([]uuid.UUID, error) {
+		return r.inner.ValidateProducts(ctx, ownerID, exists)
+	})
+}
+
+func (r *cartBreaker) ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	return withBreaker(r.breaker, func() (time.Time, error) {
+		return r.inner.ItemAddedAt(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartBreaker) ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	return withBreaker(r.breaker, func() (int, error) {
+		return r.inner.ItemRecencyRank(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartBreaker) ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	return withBreaker(r.breaker, func() (domain.Money, error) {
+		return r.inner.ProductWeightedAvgPrice(ctx, productID, cur)
+	})
+}
+
+func (r *cartBreaker) GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	return withBreaker(r.breaker, func() (map[string]domain.Cart, error) {
+		return r.inner.GetCartsByOwners(ctx, ownerIDs)
+	})
+}
+
+func (r *cartBreaker) GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	return withBreaker(r.breaker, func() ([]domain.OwnedCartItem, error) {
+		return r.inner.GetRecentItems(ctx, limit)
+	})
+}
+
+func (r *cartBreaker) DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return withBreaker(r.breaker, func() (map[uuid.UUID]int64, error) {
+		return r.inner.DemandByProduct(ctx, productIDs)
+	})
+}
+
+func (r *cartBreaker) TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error) {
+	return withBreaker(r.breaker, func() ([]domain.ProductCount, error) {
+		return r.inner.TopProducts(ctx, limit)
+	})
+}
+
+func (r *cartBreaker) DistinctCurrencies(ctx context.Context) ([]currency.Unit, error) {
+	return withBreaker(r.breaker, func() ([]currency.Unit, error) {
+		return r.inner.DistinctCurrencies(ctx)
+	})
+}
+
+func (r *cartBreaker) SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error {
+	_, err := withBreaker(r.breaker, func() (struct{}, error) {
+		return struct{}{}, r.inner.SetOwnerCurrency(ctx, ownerID, cur)
+	})
+	return err
+}
+
+func (r *cartBreaker) GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error) {
+	var found bool
+	cur, err := withBreaker(r.breaker, func() (cur currency.Unit, err error) {
+		cur, found, err = r.inner.GetOwnerCurrency(ctx, ownerID)
+		return cur, err
+	})
+	return cur, found, err
+}
+
+// HealthCheck is not guarded by the breaker, so it keeps reporting the database itself while the breaker is open.
+func (r *cartBreaker) HealthCheck(ctx context.Context) error {
+	return r.inner.HealthCheck(ctx)
+}
+
+// Shutdown is not guarded by the breaker, the inner repository has to be released even while it is failing.
+func (r *cartBreaker) Shutdown(ctx context.Context) error {
+	return r.inner.Shutdown(ctx)
+}
+
+func withBreaker[T any](b *circuitBreaker, fn func() (T, error)) (T, error) {
+	generation, err := b.allow()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	result, err := fn()
+	b.done(generation, err)
+
+	return result, err
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	settings BreakerSettings
+
+	mu          sync.Mutex
+	state       breakerState
+	generation  uint64 // incremented on every state change to drop results of calls admitted before it
+	requests    uint32
+	failures    uint32
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool
+}
+
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.settings.Clock.Now()
+
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.settings.OpenTimeout {
+			return 0, ErrCircuitOpen
+		}
+		b.setState(breakerHalfOpen, now)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return 0, ErrCircuitOpen
+		}
+		b.probing = true
+	default:
+		if b.settings.Interval > 0 && now.Sub(b.windowStart) >= b.settings.Interval {
+			b.setState(breakerClosed, now)
+		}
+	}
+
+	return b.generation, nil
+}
+
+func (b *circuitBreaker) done(generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	now := b.settings.Clock.Now()
+	failed := isDatabaseFailure(err)
+
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.setState(breakerOpen, now)
+		} else {
+			b.setState(breakerClosed, now)
+		}
+	case breakerClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.settings.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.settings.FailureRatio {
+			b.setState(breakerOpen, now)
+		}
+	}
+}
+
+// isDatabaseFailure reports whether err is a sign of an unhealthy database: a lost or refused connection,
+// the pool running out of connections, a timeout, or a server error of the connection exception, insufficient
+// resources, operator intervention, system or internal error classes. Errors of the caller, e.g. validation
+// failures, missing items, version conflicts or constraint violations, and a caller giving up are not.
+func isDatabaseFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrPoolExhausted) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if len(pgErr.Code) < 2 {
+			return false
+		}
+		switch pgErr.Code[:2] {
+		case "08", "53", "57", "58", "XX":
+			return true
+		}
+		return false
+	}
+
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (b *circuitBreaker) setState(state breakerState, now time.Time) {
+	b.state = state
+	b.generation++
+	b.requests = 0
+	b.failures = 0
+	b.windowStart = now
+	b.probing = false
+
+	if state == breakerOpen {
+		b.openedAt = now
+	}
+}
@@ -0,0 +1,296 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDatabase error = &pgconn.PgError{Code: "08006", Message: "database is down"}
+
+func TestNewCartWithBreaker(t *testing.T) {
+	valid := repository.BreakerSettings{MinRequests: 1, FailureRatio: 0.5, OpenTimeout: time.Second}
+
+	tests := []struct {
+		name      string
+		inner     port.CartRepository
+		modify    func(*repository.BreakerSettings)
+		wantError string
+	}{
+		{
+			name:  "valid settings: ok",
+			inner: &fakeCartRepository{},
+		},
+		{
+			name:      "nil inner: error",
+			wantError: "inner is nil",
+		},
+		{
+			name:      "zero min requests: error",
+			inner:     &fakeCartRepository{},
+			modify:    func(s *repository.BreakerSettings) { s.MinRequests = 0 },
+			wantError: "settings.MinRequests must be positive",
+		},
+		{
+			name:      "failure ratio above one: error",
+			inner:     &fakeCartRepository{},
+			modify:    func(s *repository.BreakerSettings) { s.FailureRatio = 1.5 },
+			wantError: "settings.FailureRatio[1.5] must be in (0, 1]",
+		},
+		{
+			name:      "zero open timeout: error",
+			inner:     &fakeCartRepository{},
+			modify:    func(s *repository.BreakerSettings) { s.OpenTimeout = 0 },
+			wantError: "settings.OpenTimeout must be positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := valid
+			if tt.modify != nil {
+				tt.modify(&settings)
+			}
+
+			repo, err := repository.NewCartWithBreaker(tt.inner, settings)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, repo)
+		})
+	}
+}
+
+func TestCartBreaker(t *testing.T) {
+	newSettings := func() repository.BreakerSettings {
+		return repository.BreakerSettings{
+			MinRequests:  4,
+			FailureRatio: 0.5,
+			Interval:     time.Minute,
+			OpenTimeout:  time.Second,
+			Clock:        newFakeClock(),
+		}
+	}
+
+	t.Run("open after failure ratio: fail fast", func(t *testing.T) {
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, newSettings())
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, nil, 2)
+		callGetCart(t, repo, inner, errDatabase, 2)
+
+		_, err = repo.GetCart(t.Context(), "owner")
+		require.ErrorIs(t, err, repository.ErrCircuitOpen)
+		assert.Equal(t, 4, inner.callCount())
+	})
+
+	t.Run("open timeout not elapsed: fail fast", func(t *testing.T) {
+		settings := newSettings()
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, settings)
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, errDatabase, 4)
+		settings.Clock.(*fakeClock).Advance(settings.OpenTimeout - time.Millisecond)
+
+		_, err = repo.GetCart(t.Context(), "owner")
+		require.ErrorIs(t, err, repository.ErrCircuitOpen)
+		assert.Equal(t, 4, inner.callCount())
+	})
+
+	t.Run("failures spread over intervals: stay closed", func(t *testing.T) {
+		settings := newSettings()
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, settings)
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, errDatabase, 3)
+		settings.Clock.(*fakeClock).Advance(settings.Interval)
+		callGetCart(t, repo, inner, nil, 3)
+		callGetCart(t, repo, inner, errDatabase, 1)
+		callGetCart(t, repo, inner, nil, 1)
+		assert.Equal(t, 8, inner.callCount())
+	})
+
+	t.Run("canceled calls: stay closed", func(t *testing.T) {
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, newSettings())
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, context.Canceled, 4)
+		callGetCart(t, repo, inner, nil, 1)
+		assert.Equal(t, 5, inner.callCount())
+	})
+
+	t.Run("item not found: stay closed", func(t *testing.T) {
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, newSettings())
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, fmt.Errorf("q.GetItem: %w", domain.ErrItemNotFound), 4)
+		callGetCart(t, repo, inner, nil, 1)
+		assert.Equal(t, 5, inner.callCount())
+	})
+
+	t.Run("caller errors: stay closed", func(t *testing.T) {
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, newSettings())
+		require.NoError(t, err)
+
+		callerErrors := []error{
+			errors.New("ownerID is empty"),
+			fmt.Errorf("by[%d] is not positive", 0),
+			fmt.Errorf("q.UpdateItemPriceIfVersion: %w", domain.ErrVersionConflict),
+			fmt.Errorf("q.CartKnown: %w", domain.ErrCartNotFound),
+			&domain.NegativeQuantityError{ProductID: uuid.New(), Quantity: 1, Delta: -2},
+			domain.ErrEmptyCart,
+			domain.ErrMixedCurrency,
+			fmt.Errorf("%w: USD vs EUR", domain.ErrCurrencyMismatch),
+			&pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+			&pgconn.PgError{Code: "40001", Message: "could not serialize access"},
+		}
+		for _, callerErr := range callerErrors {
+			callGetCart(t, repo, inner, callerErr, 4)
+		}
+		callGetCart(t, repo, inner, nil, 1)
+		assert.Equal(t, 4*len(callerErrors)+1, inner.callCount())
+	})
+
+	t.Run("database failures: open", func(t *testing.T) {
+		failures := []error{
+			fmt.Errorf("q.GetCart: %w", repository.ErrPoolExhausted),
+			context.DeadlineExceeded,
+			&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			&pgconn.PgError{Code: "53300", Message: "too many connections"},
+			&pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"},
+		}
+		for _, failure := range failures {
+			inner := &fakeCartRepository{}
+			repo, err := repository.NewCartWithBreaker(inner, newSettings())
+			require.NoError(t, err)
+
+			callGetCart(t, repo, inner, failure, 4)
+
+			_, err = repo.GetCart(t.Context(), "owner")
+			require.ErrorIs(t, err, repository.ErrCircuitOpen, "failure %v", failure)
+		}
+	})
+
+	t.Run("successful probe after open timeout: close", func(t *testing.T) {
+		settings := newSettings()
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, settings)
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, errDatabase, 4)
+		settings.Clock.(*fakeClock).Advance(settings.OpenTimeout)
+
+		callGetCart(t, repo, inner, nil, 3)
+		assert.Equal(t, 7, inner.callCount())
+	})
+
+	t.Run("failed probe after open timeout: reopen", func(t *testing.T) {
+		settings := newSettings()
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, settings)
+		require.NoError(t, err)
+
+		callGetCart(t, repo, inner, errDatabase, 4)
+		settings.Clock.(*fakeClock).Advance(settings.OpenTimeout)
+
+		callGetCart(t, repo, inner, errDatabase, 1)
+
+		_, err = repo.GetCart(t.Context(), "owner")
+		require.ErrorIs(t, err, repository.ErrCircuitOpen)
+		assert.Equal(t, 5, inner.callCount())
+	})
+
+	t.Run("concurrent calls: ok", func(t *testing.T) {
+		inner := &fakeCartRepository{}
+		repo, err := repository.NewCartWithBreaker(inner, newSettings())
+		require.NoError(t, err)
+
+		inner.setError(errDatabase)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = repo.GetCart(context.Background(), "owner")
+			}()
+		}
+		wg.Wait()
+
+		_, err = repo.GetCart(t.Context(), "owner")
+		require.ErrorIs(t, err, repository.ErrCircuitOpen)
+	})
+}
+
+// callGetCart calls GetCart n times expecting the inner repository to be reached and return wantErr.
+func callGetCart(t *testing.T, repo port.CartRepository, inner *fakeCartRepository, wantErr error, n int) {
+	t.Helper()
+
+	inner.setError(wantErr)
+	for i := 0; i < n; i++ {
+		_, err := repo.GetCart(t.Context(), "owner")
+		require.ErrorIs(t, err, wantErr)
+	}
+}
+
+type fakeCartRepository struct {
+	port.CartRepository // methods not overridden below panic when called
+
+	mu    sync.Mutex
+	calls int // GetCart calls only
+	err   error
+	items []domain.CartItem // returned by GetCart
+}
+
+func (f *fakeCartRepository) GetCart(_ context.Context, ownerID string) (domain.Cart, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.err != nil {
+		return domain.Cart{}, f.err
+	}
+
+	return domain.Cart{OwnerID: ownerID, Items: f.items}, nil
+}
+
+func (f *fakeCartRepository) AddItem(_ context.Context, _ string, _ domain.CartItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.err
+}
+
+func (f *fakeCartRepository) setError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+}
+
+func (f *fakeCartRepository) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls
+}
@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+var _ port.CartRepository = (*CartCache)(nil)
+
+// CartCache is a CartRepository which remembers the carts it reads, so callers tolerating stale data
+// can use GetCartStale. Writes going through it drop the cached cart of the owner.
+// At most maxCarts carts are kept, the least recently used one is dropped to make room for another.
+type CartCache struct {
+	inner    port.CartRepository
+	clock    Clock
+	maxCarts int
+
+	mu sync.Mutex
+	// carts are grouped by foldOwnerID, so a write drops the carts cached under any spelling NewCart may
+	// normalize to the same owner
+	carts      map[string]map[string]*cachedCart
+	lru        *list.List // of cacheKey, the most recently used first
+	generation uint64     // incremented on every write to drop results of reads which started before it
+}
+
+type cachedCart struct {
+	cart     domain.Cart
+	loadedAt time.Time
+	elem     *list.Element
+}
+
+type cacheKey struct {
+	folded  string
+	ownerID string
+}
+
+// NewCartWithCache wraps the given CartRepository with a cache of up to maxCarts carts,
+// a nil clock defaults to SystemClock.
+func NewCartWithCache(inner port.CartRepository, clock Clock, maxCarts int) (*CartCache, error) {
+	if inner == nil {
+		return nil, fmt.Errorf("inner is nil")
+	}
+	if maxCarts <= 0 {
+		return nil, fmt.Errorf("maxCarts[%d] is not positive", maxCarts)
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return &CartCache{
+		inner:    inner,
+		clock:    clock,
+		maxCarts: maxCarts,
+		carts:    make(map[string]map[string]*cachedCart),
+		lru:      list.New(),
+	}, nil
+}
+
+// GetCart always reads through and refreshes the cached cart.
+func (c *CartCache) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	cart, err := c.inner.GetCart(ctx, ownerID)
+	if err != nil {
+		return cart, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation == c.generation {
+		c.store(ownerID, cloneCart(cart))
+	}
+
+	return cart, nil
+}
+
+// GetCartStale returns the cached cart when it is younger than maxAge, reporting a cache hit,
+// otherwise it reads through like GetCart.
+func (c *CartCache) GetCartStale(ctx context.Context, ownerID string, maxAge time.Duration) (domain.Cart, bool, error) {
+	c.mu.Lock()
+	cached, ok := c.carts[foldOwnerID(ownerID)][ownerID]
+	if ok && c.clock.Now().Sub(cached.loadedAt) < maxAge {
+		c.lru.MoveToFront(cached.elem)
+		cart := cached.cart
+		c.mu.Unlock()
+
+		return cloneCart(cart), true, nil
+	}
+	c.mu.Unlock()
+
+	cart, err := c.GetCart(ctx, ownerID)
+	return cart, false, err
+}
+
+func (c *CartCache) GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error) {
+	return c.inner.GetCartWithLineTotals(ctx, ownerID)
+}
+
+func (c *CartCache) GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	return c.inner.GetCartAfter(ctx, ownerID, afterCreatedAt, afterProductID, limit)
+}
+
+func (c *CartCache) GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	return c.inner.GetCartInRange(ctx, ownerID, from, to)
+}
+
+func (c *CartCache) AddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	defer c.invalidate(ownerID)
+	return c.inner.AddItem(ctx, ownerID, item)
+}
+
+func (c *CartCache) ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	return c.inner.ValidateAddItem(ctx, ownerID, item)
+}
+
+func (c *CartCache) SetItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	defer c.invalidate(ownerID)
+	return c.inner.SetItem(ctx, ownerID, item)
+}
+
+func (c *CartCache) AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	defer c.invalidate(ownerID)
+	return c.inner.AddOrRemoveItem(ctx, ownerID, item)
+}
+
+func (c *CartCache) ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	defer c.invalidate(ownerID)
+	return c.inner.ImportItem(ctx, ownerID, item)
+}
+
+func (c *CartCache) AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	defer c.invalidate(ownerID)
+	return c.inner.AddItemIdempotent(ctx, ownerID, item, idempotencyKey)
+}
+
+func (c *CartCache) AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.AddItemReturning(ctx, ownerID, item)
+}
+
+func (c *CartCache) AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.AddItemReturningPrevious(ctx, ownerID, item)
+}
+
+func (c *CartCache) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.AddItemsBestEffort(ctx, ownerID, items)
+}
+
+func (c *CartCache) GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	return c.inner.GetItem(ctx, ownerID, productID)
+}
+
+func (c *CartCache) UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.UpdateItemPrice(ctx, ownerID, productID, newPrice)
+}
+
+func (c *CartCache) UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.UpdateItemPriceIfVersion(ctx, ownerID, productID, newPrice, expectedVersion)
+}
+
+func (c *CartCache) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.DeleteItem(ctx, ownerID, productID)
+}
+
+func (c *CartCache) DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.DecrementItem(ctx, ownerID, productID, by)
+}
+
+func (c *CartCache) ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	defer c.invalidate(ownerID)
+	return c.inner.ApplyQuantityDeltas(ctx, ownerID, deltas)
+}
+
+func (c *CartCache) SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error {
+	defer c.invalidate(ownerID)
+	return c.inner.SwapQuantities(ctx, ownerID, productA, productB)
+}
+
+func (c *CartCache) DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.DeleteItems(ctx, ownerID, productIDs)
+}
+
+func (c *CartCache) RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error {
+	defer c.invalidate(ownerID)
+	return c.inner.RestoreItem(ctx, ownerID, productID)
+}
+
+func (c *CartCache) GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	return c.inner.GetItemsByCurrency(ctx, ownerID, cur)
+}
+
+func (c *CartCache) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	return c.inner.StreamCart(ctx, ownerID, fn)
+}
+
+func (c *CartCache) ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.ClearCurrency(ctx, ownerID, cur)
+}
+
+func (c *CartCache) ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.ApplyPriceFactor(ctx, ownerID, cur, factor)
+}
+
+func (c *CartCache) CartExists(ctx context.Context, ownerID string) (bool, error) {
+	return c.inner.CartExists(ctx, ownerID)
+}
+
+func (c *CartCache) CountItems(ctx context.Context, ownerID string) (int64, error) {
+	return c.inner.CountItems(ctx, ownerID)
+}
+
+func (c *CartCache) ClearCart(ctx context.Context, ownerID string) (int64, error) {
+	defer c.invalidate(ownerID)
+	return c.inner.ClearCart(ctx, ownerID)
+}
+
+func (c *CartCache) MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	defer c.invalidate(fromOwnerID)
+	defer c.invalidate(toOwnerID)
+	return c.inner.MoveItems(ctx, fromOwnerID, toOwnerID)
+}
+
+func (c *CartCache) PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	defer c.invalidateAll()
+	return c.inner.PurgeCartsOlderThan(ctx, cutoff)
+}
+
+func (c *CartCache) CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error) {
+	return c.inner.CartCurrencyReport(ctx, ownerID)
+}
+
+func (c *CartCache) CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error) {
+	return c.inner.CartCurrency(ctx, ownerID)
+}
+
+func (c *CartCache) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	return c.inner.GetCartTotal(ctx, ownerID)
+}
+
+func (c *CartCache) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	return c.inner.GetCartTotalIn(ctx, ownerID, target, rates)
+}
+
+func (c *CartCache) TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	return c.inner.TotalExcluding(ctx, ownerID, excluded)
+}
+
+func (c *CartCache) ListCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	return c.inner.ListCartNames(ctx, ownerID)
+}
+
+func (c *CartCache) ListOwners(ctx context.Context, limit, offset int32) ([]string, error) {
+	return c.inner.ListOwners(ctx, limit, offset)
+}
+
+func (c *CartCache) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	return c.inner.PlanSync(ctx, ownerID, desired)
+}
+
+func (c *CartCache) ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error {
+	defer c.invalidate(ownerID)
+	return c.inner.ApplySync(ctx, ownerID, plan)
+}
+
+func (c *CartCache) ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error {
+	defer c.invalidate(ownerID)
+	return c.inner.ReplaceCart(ctx, ownerID, items)
+}
+
+func (c *CartCache) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	return c.inner.ValidateProducts(ctx, ownerID, exists)
+}
+
+func (c *CartCache) ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	return c.inner.ItemAddedAt(ctx, ownerID, productID)
+}
+
+func (c *CartCache) ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	return c.inner.ItemRecencyRank(ctx, ownerID, productID)
+}
+
+func (c *CartCache) ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	return c.inner.ProductWeightedAvgPrice(ctx, productID, cur)
+}
+
+func (c *CartCache) GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	return c.inner.GetCartsByOwners(ctx, ownerIDs)
+}
+
+func (c *CartCache) GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	return c.inner.GetRecentItems(ctx, limit)
+}
+
+func (c *CartCache) DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return c.inner.DemandByProduct(ctx, productIDs)
+}
+
+func (c *CartCache) TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error) {
+	return c.inner.TopProducts(ctx, limit)
+}
+
+func (c *CartCache) DistinctCurrencies(ctx context.Context) ([]currency.Unit, error) {
+	return c.inner.DistinctCurrencies(ctx)
+}
+
+func (c *CartCache) SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error {
+	return c.inner.SetOwnerCurrency(ctx, ownerID, cur)
+}
+
+func (c *CartCache) GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error) {
+	return c.inner.GetOwnerCurrency(ctx, ownerID)
+}
+
+func (c *CartCache) HealthCheck(ctx context.Context) error {
+	return c.inner.HealthCheck(ctx)
+}
+
+func (c *CartCache) Shutdown(ctx context.Context) error {
+	return c.inner.Shutdown(ctx)
+}
+
+// invalidate is called after the write, even a failed one, as it might have been applied anyway.
+func (c *CartCache) invalidate(ownerID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	key := foldOwnerID(ownerID)
+	for _, cached := range c.carts[key] {
+		c.lru.Remove(cached.elem)
+	}
+	delete(c.carts, key)
+}
+
+// invalidateAll drops every cached cart, for writes spanning owners.
+func (c *CartCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.carts)
+	c.lru.Init()
+}
+
+// store caches the cart as the most recently used one, dropping the least recently used cart when full.
+// It must be called with c.mu held.
+func (c *CartCache) store(ownerID string, cart domain.Cart) {
+	key := cacheKey{folded: foldOwnerID(ownerID), ownerID: ownerID}
+
+	if cached, ok := c.carts[key.folded][ownerID]; ok {
+		cached.cart, cached.loadedAt = cart, c.clock.Now()
+		c.lru.MoveToFront(cached.elem)
+		return
+	}
+
+	if c.carts[key.folded] == nil {
+		c.carts[key.folded] = make(map[string]*cachedCart)
+	}
+	c.carts[key.folded][ownerID] = &cachedCart{cart: cart, loadedAt: c.clock.Now(), elem: c.lru.PushFront(key)}
+
+	if c.lru.Len() > c.maxCarts {
+		oldest := c.lru.Remove(c.lru.Back()).(cacheKey)
+		delete(c.carts[oldest.folded], oldest.ownerID)
+		if len(c.carts[oldest.folded]) == 0 {
+			delete(c.carts, oldest.folded)
+		}
+	}
+}
+
+// cloneCart copies the items and their notes so callers can not modify the cached cart.
+func cloneCart(cart domain.Cart) domain.Cart {
+	cart.Items = slices.Clone(cart.Items)
+	for i, item := range cart.Items {
+		if item.Note != nil {
+			note := *item.Note
+			cart.Items[i].Note = &note
+		}
+	}
+
+	return cart
+}
@@ -0,0 +1,165 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCartWithCache(t *testing.T) {
+	_, err := repository.NewCartWithCache(nil, nil, 1)
+	require.EqualError(t, err, "inner is nil")
+
+	_, err = repository.NewCartWithCache(&fakeCartRepository{}, nil, 0)
+	require.EqualError(t, err, "maxCarts[0] is not positive")
+
+	cache, err := repository.NewCartWithCache(&fakeCartRepository{}, nil, 1)
+	require.NoError(t, err)
+	assert.NotNil(t, cache)
+}
+
+func TestCartCacheGetCartStale(t *testing.T) {
+	const maxAge = time.Minute
+
+	newCache := func(t *testing.T) (*repository.CartCache, *fakeCartRepository, *fakeClock) {
+		t.Helper()
+
+		inner := &fakeCartRepository{}
+		clock := newFakeClock()
+		cache, err := repository.NewCartWithCache(inner, clock, 2)
+		require.NoError(t, err)
+
+		return cache, inner, clock
+	}
+
+	t.Run("not cached: read through", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		cart, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, "owner", cart.OwnerID)
+		assert.Equal(t, 1, inner.callCount())
+	})
+
+	t.Run("younger than max age: hit", func(t *testing.T) {
+		cache, inner, clock := newCache(t)
+
+		_, err := cache.GetCart(t.Context(), "owner")
+		require.NoError(t, err)
+		clock.Advance(maxAge - time.Millisecond)
+
+		cart, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+		assert.Equal(t, "owner", cart.OwnerID)
+		assert.Equal(t, 1, inner.callCount())
+	})
+
+	t.Run("max age reached: read through and refresh", func(t *testing.T) {
+		cache, inner, clock := newCache(t)
+
+		_, err := cache.GetCart(t.Context(), "owner")
+		require.NoError(t, err)
+		clock.Advance(maxAge)
+
+		_, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+
+		_, hit, err = cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+		assert.Equal(t, 2, inner.callCount())
+	})
+
+	t.Run("write: invalidate", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		_, err := cache.GetCart(t.Context(), "owner")
+		require.NoError(t, err)
+		require.NoError(t, cache.AddItem(t.Context(), "owner", domain.CartItem{}))
+
+		_, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, 2, inner.callCount())
+	})
+
+	t.Run("write with other spelling of owner: invalidate", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		_, err := cache.GetCart(t.Context(), " Owner ")
+		require.NoError(t, err)
+		require.NoError(t, cache.AddItem(t.Context(), "owner", domain.CartItem{}))
+
+		_, hit, err := cache.GetCartStale(t.Context(), " Owner ", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, 2, inner.callCount())
+	})
+
+	t.Run("more carts than maxCarts: least recently used dropped", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		for _, ownerID := range []string{"first", "second"} {
+			_, err := cache.GetCart(t.Context(), ownerID)
+			require.NoError(t, err)
+		}
+		// using the first cart makes the second one the least recently used
+		_, hit, err := cache.GetCartStale(t.Context(), "first", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+
+		_, err = cache.GetCart(t.Context(), "third")
+		require.NoError(t, err)
+
+		_, hit, err = cache.GetCartStale(t.Context(), "first", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+
+		_, hit, err = cache.GetCartStale(t.Context(), "second", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, 4, inner.callCount())
+	})
+
+	t.Run("note modified by caller: cached cart unchanged", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		note := "gift"
+		inner.items = []domain.CartItem{{Note: &note}}
+
+		cart, err := cache.GetCart(t.Context(), "owner")
+		require.NoError(t, err)
+		*cart.Items[0].Note = "changed"
+
+		cart, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+		*cart.Items[0].Note = "changed again"
+
+		cart, hit, err = cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.True(t, hit)
+		assert.Equal(t, "gift", *cart.Items[0].Note)
+	})
+
+	t.Run("failed read: not cached", func(t *testing.T) {
+		cache, inner, _ := newCache(t)
+
+		inner.setError(errDatabase)
+		_, _, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.ErrorIs(t, err, errDatabase)
+
+		inner.setError(nil)
+		_, hit, err := cache.GetCartStale(t.Context(), "owner", maxAge)
+		require.NoError(t, err)
+		assert.False(t, hit)
+		assert.Equal(t, 2, inner.callCount())
+	})
+}
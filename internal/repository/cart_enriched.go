@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+)
+
+type EnrichedItem[T any] struct {
+	Item domain.CartItem
+	Data T
+}
+
+// GetCartEnriched loads the cart and pairs each item with the data batch-loaded for its product,
+// products missing from the loader result get the zero T.
+func GetCartEnriched[T any](
+	ctx context.Context,
+	repo port.CartRepository,
+	ownerID string,
+	loader func(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]T, error),
+) ([]EnrichedItem[T], error) {
+	if repo == nil {
+		return nil, fmt.Errorf("repo is nil")
+	}
+	if loader == nil {
+		return nil, fmt.Errorf("loader is nil")
+	}
+
+	cart, err := repo.GetCart(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("repo.GetCart: %w", err)
+	}
+
+	enriched := make([]EnrichedItem[T], 0, len(cart.Items))
+	if len(cart.Items) == 0 {
+		return enriched, nil
+	}
+
+	productIDs := make([]uuid.UUID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	data, err := loader(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("loader: %w", err)
+	}
+
+	for _, item := range cart.Items {
+		enriched = append(enriched, EnrichedItem[T]{
+			Item: item,
+			Data: data[item.ProductID],
+		})
+	}
+
+	return enriched, nil
+}
@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/currency"
+)
+
+// Outcomes of a call passed to Metrics.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// Metrics records every call of the repository, an implementation would count the calls by method and outcome
+// and observe the duration in a histogram.
+type Metrics interface {
+	RecordCall(method, outcome string, duration time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RecordCall(string, string, time.Duration) {}
+
+// cartInstrumented logs and records the metrics of every call of the inner repository and traces some of them,
+// NewCart always applies it.
+type cartInstrumented struct {
+	inner       port.CartRepository
+	logger      *slog.Logger
+	tracer      trace.Tracer
+	metrics     Metrics
+	contextKeys []ContextKey
+}
+
+func (r *cartInstrumented) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	ctx, span := r.startSpan(ctx, "GetCart", ownerID)
+	cart, err := instrument(ctx, r, "GetCart", []slog.Attr{ownerAttr(ownerID)}, func() (domain.Cart, error) {
+		return r.inner.GetCart(ctx, ownerID)
+	})
+	endSpan(span, err, attribute.Int("rows_returned", len(cart.Items)))
+	return cart, err
+}
+
+func (r *cartInstrumented) GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error) {
+	return instrument(ctx, r, "GetCartWithLineTotals", []slog.Attr{ownerAttr(ownerID)}, func() ([]domain.CartLine, error) {
+		return r.inner.GetCartWithLineTotals(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	return instrument(ctx, r, "GetCartAfter", []slog.Attr{ownerAttr(ownerID)}, func() ([]domain.CartItem, error) {
+		return r.inner.GetCartAfter(ctx, ownerID, afterCreatedAt, afterProductID, limit)
+	})
+}
+
+func (r *cartInstrumented) GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	return instrument(ctx, r, "GetCartInRange", []slog.Attr{ownerAttr(ownerID)}, func() ([]domain.CartItem, error) {
+		return r.inner.GetCartInRange(ctx, ownerID, from, to)
+	})
+}
+
+func (r *cartInstrumented) AddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ctx, span := r.startSpan(ctx, "AddItem", ownerID)
+	_, err := instrument(ctx, r, "AddItem", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddItem(ctx, ownerID, item)
+	})
+	// the writes return no row count, so their spans have no rows_affected attribute
+	endSpan(span, err)
+	return err
+}
+
+func (r *cartInstrumented) ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := instrument(ctx, r, "ValidateAddItem", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.ValidateAddItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartInstrumented) SetItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := instrument(ctx, r, "SetItem", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.SetItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartInstrumented) AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := instrument(ctx, r, "AddOrRemoveItem", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddOrRemoveItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartInstrumented) ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	_, err := instrument(ctx, r, "ImportItem", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.ImportItem(ctx, ownerID, item)
+	})
+	return err
+}
+
+func (r *cartInstrumented) AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	_, err := instrument(ctx, r, "AddItemIdempotent", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.AddItemIdempotent(ctx, ownerID, item, idempotencyKey)
+	})
+	return err
+}
+
+func (r *cartInstrumented) AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	return instrument(ctx, r, "AddItemReturning", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (domain.CartItem, error) {
+		return r.inner.AddItemReturning(ctx, ownerID, item)
+	})
+}
+
+func (r *cartInstrumented) AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	return instrument(ctx, r, "AddItemReturningPrevious", []slog.Attr{ownerAttr(ownerID), productAttr(item.ProductID)}, func() (*domain.CartItem, error) {
+		return r.inner.AddItemReturningPrevious(ctx, ownerID, item)
+	})
+}
+
+func (r *cartInstrumented) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	return instrument(ctx, r, "AddItemsBestEffort", []slog.Attr{ownerAttr(ownerID)}, func() ([]domain.ItemResult, error) {
+		return r.inner.AddItemsBestEffort(ctx, ownerID, items)
+	})
+}
+
+func (r *cartInstrumented) GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	return instrument(ctx, r, "GetItem", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (domain.CartItem, error) {
+		return r.inner.GetItem(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartInstrumented) UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	return instrument(ctx, r, "UpdateItemPrice", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (bool, error) {
+		return r.inner.UpdateItemPrice(ctx, ownerID, productID, newPrice)
+	})
+}
+
+func (r *cartInstrumented) UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	return instrument(ctx, r, "UpdateItemPriceIfVersion", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (int32, error) {
+		return r.inner.UpdateItemPriceIfVersion(ctx, ownerID, productID, newPrice, expectedVersion)
+	})
+}
+
+func (r *cartInstrumented) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	ctx, span := r.startSpan(ctx, "DeleteItem", ownerID)
+	deleted, err := instrument(ctx, r, "DeleteItem", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (bool, error) {
+		return r.inner.DeleteItem(ctx, ownerID, productID)
+	})
+	endSpan(span, err)
+	return deleted, err
+}
+
+func (r *cartInstrumented) DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	return instrument(ctx, r, "DecrementItem", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (domain.CartItem, error) {
+		return r.inner.DecrementItem(ctx, ownerID, productID, by)
+	})
+}
+
+func (r *cartInstrumented) ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	_, err := instrument(ctx, r, "ApplyQuantityDeltas", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.ApplyQuantityDeltas(ctx, ownerID, deltas)
+	})
+	return err
+}
+
+func (r *cartInstrumented) SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error {
+	_, err := instrument(ctx, r, "SwapQuantities", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.SwapQuantities(ctx, ownerID, productA, productB)
+	})
+	return err
+}
+
+func (r *cartInstrumented) DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	return instrument(ctx, r, "DeleteItems", []slog.Attr{ownerAttr(ownerID)}, func() (int64, error) {
+		return r.inner.DeleteItems(ctx, ownerID, productIDs)
+	})
+}
+
+func (r *cartInstrumented) RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error {
+	_, err := instrument(ctx, r, "RestoreItem", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.RestoreItem(ctx, ownerID, productID)
+	})
+	return err
+}
+
+func (r *cartInstrumented) GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	return instrument(ctx, r, "GetItemsByCurrency", []slog.Attr{ownerAttr(ownerID)}, func() ([]domain.CartItem, error) {
+		return r.inner.GetItemsByCurrency(ctx, ownerID, cur)
+	})
+}
+
+func (r *cartInstrumented) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	_, err := instrument(ctx, r, "StreamCart", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.StreamCart(ctx, ownerID, fn)
+	})
+	return err
+}
+
+func (r *cartInstrumented) ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error) {
+	return instrument(ctx, r, "ClearCurrency", []slog.Attr{ownerAttr(ownerID)}, func() (int, error) {
+		return r.inner.ClearCurrency(ctx, ownerID, cur)
+	})
+}
+
+func (r *cartInstrumented) ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	return instrument(ctx, r, "ApplyPriceFactor", []slog.Attr{ownerAttr(ownerID)}, func() (int64, error) {
+		return r.inner.ApplyPriceFactor(ctx, ownerID, cur, factor)
+	})
+}
+
+func (r *cartInstrumented) CartExists(ctx context.Context, ownerID string) (bool, error) {
+	return instrument(ctx, r, "CartExists", []slog.Attr{ownerAttr(ownerID)}, func() (bool, error) {
+		return r.inner.CartExists(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) CountItems(ctx context.Context, ownerID string) (int64, error) {
+	return instrument(ctx, r, "CountItems", []slog.Attr{ownerAttr(ownerID)}, func() (int64, error) {
+		return r.inner.CountItems(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) ClearCart(ctx context.Context, ownerID string) (int64, error) {
+	return instrument(ctx, r, "ClearCart", []slog.Attr{ownerAttr(ownerID)}, func() (int64, error) {
+		return r.inner.ClearCart(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	attrs := []slog.Attr{slog.String("from_owner_id", fromOwnerID), slog.String("to_owner_id", toOwnerID)}
+	return instrument(ctx, r, "MoveItems", attrs, func() (int64, error) {
+		return r.inner.MoveItems(ctx, fromOwnerID, toOwnerID)
+	})
+}
+
+func (r *cartInstrumented) PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	return instrument(ctx, r, "PurgeCartsOlderThan", nil, func() (int64, error) {
+		return r.inner.PurgeCartsOlderThan(ctx, cutoff)
+	})
+}
+
+func (r *cartInstrumented) CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error) {
+	return instrument(ctx, r, "CartCurrencyReport", []slog.Attr{ownerAttr(ownerID)}, func() (domain.CurrencyReport, error) {
+		return r.inner.CartCurrencyReport(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error) {
+	return instrument(ctx, r, "CartCurrency", []slog.Attr{ownerAttr(ownerID)}, func() (currency.Unit, error) {
+		return r.inner.CartCurrency(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	return instrument(ctx, r, "GetCartTotal", []slog.Attr{ownerAttr(ownerID)}, func() (domain.Money, error) {
+		return r.inner.GetCartTotal(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	return instrument(ctx, r, "GetCartTotalIn", []slog.Attr{ownerAttr(ownerID)}, func() (domain.Money, error) {
+		return r.inner.GetCartTotalIn(ctx, ownerID, target, rates)
+	})
+}
+
+func (r *cartInstrumented) TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	return instrument(ctx, r, "TotalExcluding", []slog.Attr{ownerAttr(ownerID)}, func() (domain.Money, error) {
+		return r.inner.TotalExcluding(ctx, ownerID, excluded)
+	})
+}
+
+func (r *cartInstrumented) ListCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	return instrument(ctx, r, "ListCartNames", []slog.Attr{ownerAttr(ownerID)}, func() ([]string, error) {
+		return r.inner.ListCartNames(ctx, ownerID)
+	})
+}
+
+func (r *cartInstrumented) ListOwners(ctx context.Context, limit, offset int32) ([]string, error) {
+	return instrument(ctx, r, "ListOwners", nil, func() ([]string, error) {
+		return r.inner.ListOwners(ctx, limit, offset)
+	})
+}
+
+func (r *cartInstrumented) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	return instrument(ctx, r, "PlanSync", []slog.Attr{ownerAttr(ownerID)}, func() (domain.SyncPlan, error) {
+		return r.inner.PlanSync(ctx, ownerID, desired)
+	})
+}
+
+func (r *cartInstrumented) ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error {
+	_, err := instrument(ctx, r, "ApplySync", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.ApplySync(ctx, ownerID, plan)
+	})
+	return err
+}
+
+func (r *cartInstrumented) ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error {
+	_, err := instrument(ctx, r, "ReplaceCart", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.ReplaceCart(ctx, ownerID, items)
+	})
+	return err
+}
+
+func (r *cartInstrumented) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	return instrument(ctx, r, "ValidateProducts", []slog.Attr{ownerAttr(ownerID)}, func() ([]uuid.UUID, error) {
+		return r.inner.ValidateProducts(ctx, ownerID, exists)
+	})
+}
+
+func (r *cartInstrumented) ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	return instrument(ctx, r, "ItemAddedAt", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (time.Time, error) {
+		return r.inner.ItemAddedAt(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartInstrumented) ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	return instrument(ctx, r, "ItemRecencyRank", []slog.Attr{ownerAttr(ownerID), productAttr(productID)}, func() (int, error) {
+		return r.inner.ItemRecencyRank(ctx, ownerID, productID)
+	})
+}
+
+func (r *cartInstrumented) ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	return instrument(ctx, r, "ProductWeightedAvgPrice", []slog.Attr{productAttr(productID)}, func() (domain.Money, error) {
+		return r.inner.ProductWeightedAvgPrice(ctx, productID, cur)
+	})
+}
+
+func (r *cartInstrumented) GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	return instrument(ctx, r, "GetCartsByOwners", nil, func() (map[string]domain.Cart, error) {
+		return r.inner.GetCartsByOwners(ctx, ownerIDs)
+	})
+}
+
+func (r *cartInstrumented) GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	return instrument(ctx, r, "GetRecentItems", nil, func() ([]domain.OwnedCartItem, error) {
+		return r.inner.GetRecentItems(ctx, limit)
+	})
+}
+
+func (r *cartInstrumented) DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return instrument(ctx, r, "DemandByProduct", nil, func() (map[uuid.UUID]int64, error) {
+		return r.inner.DemandByProduct(ctx, productIDs)
+	})
+}
+
+func (r *cartInstrumented) TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error) {
+	return instrument(ctx, r, "TopProducts", nil, func() ([]domain.ProductCount, error) {
+		return r.inner.TopProducts(ctx, limit)
+	})
+}
+
+func (r *cartInstrumented) DistinctCurrencies(ctx context.Context) ([]currency.Unit, error) {
+	return instrument(ctx, r, "DistinctCurrencies", nil, func() ([]currency.Unit, error) {
+		return r.inner.DistinctCurrencies(ctx)
+	})
+}
+
+func (r *cartInstrumented) SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error {
+	_, err := instrument(ctx, r, "SetOwnerCurrency", []slog.Attr{ownerAttr(ownerID)}, func() (struct{}, error) {
+		return struct{}{}, r.inner.SetOwnerCurrency(ctx, ownerID, cur)
+	})
+	return err
+}
+
+func (r *cartInstrumented) GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error) {
+	var found bool
+	cur, err := instrument(ctx, r, "GetOwnerCurrency", []slog.Attr{ownerAttr(ownerID)}, func() (cur currency.Unit, err error) {
+		cur, found, err = r.inner.GetOwnerCurrency(ctx, ownerID)
+		return cur, err
+	})
+	return cur, found, err
+}
+
+func (r *cartInstrumented) HealthCheck(ctx context.Context) error {
+	_, err := instrument(ctx, r, "HealthCheck", nil, func() (struct{}, error) {
+		return struct{}{}, r.inner.HealthCheck(ctx)
+	})
+	return err
+}
+
+func (r *cartInstrumented) Shutdown(ctx context.Context) error {
+	return r.inner.Shutdown(ctx)
+}
+
+// instrument logs a call at debug level, a failed one at error level with the error, and records its metrics.
+func instrument[T any](ctx context.Context, r *cartInstrumented, method string, attrs []slog.Attr, fn func() (T, error)) (T, error) {
+	attrs = append([]slog.Attr{slog.String("method", method)}, attrs...)
+	r.logger.LogAttrs(ctx, slog.LevelDebug, "cart repository call started", attrs...)
+
+	start := time.Now()
+	result, err := fn()
+	duration := time.Since(start)
+	attrs = append(attrs, slog.Duration("duration", duration))
+
+	if err != nil {
+		r.metrics.RecordCall(method, OutcomeError, duration)
+		r.logger.LogAttrs(ctx, slog.LevelError, "cart repository call failed", append(attrs, slog.Any("error", err))...)
+		return result, err
+	}
+
+	r.metrics.RecordCall(method, OutcomeOK, duration)
+	r.logger.LogAttrs(ctx, slog.LevelDebug, "cart repository call finished", attrs...)
+	return result, nil
+}
+
+func (r *cartInstrumented) startSpan(ctx context.Context, method, ownerID string) (context.Context, trace.Span) {
+	attrs := append([]attribute.KeyValue{attribute.String("owner_id", ownerID)}, contextSpanAttrs(ctx, r.contextKeys)...)
+	return r.tracer.Start(ctx, "cartRepository."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan sets the attributes of a successful call, a failed one gets the error status instead.
+func endSpan(span trace.Span, err error, attrs ...attribute.KeyValue) {
+	defer span.End()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	span.SetAttributes(attrs...)
+}
+
+func ownerAttr(ownerID string) slog.Attr {
+	return slog.String("owner_id", ownerID)
+}
+
+func productAttr(productID uuid.UUID) slog.Attr {
+	return slog.String("product_id", productID.String())
+}
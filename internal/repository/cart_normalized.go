@@ -0,0 +1,516 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+// cartNormalized normalizes the owner IDs before passing them to the inner repository, so " abc " and "abc"
+// are the same cart. An owner ID left empty is rejected before reaching the inner repository.
+type cartNormalized struct {
+	inner     port.CartRepository
+	lowerCase bool
+}
+
+// NewCartWithNormalizedOwnerIDs wraps the given CartRepository, trimming the owner IDs of every method and
+// lower-casing them too with lowerCase. NewCart always applies it, other implementations can use it to behave
+// the same.
+func NewCartWithNormalizedOwnerIDs(inner port.CartRepository, lowerCase bool) (port.CartRepository, error) {
+	if inner == nil {
+		return nil, fmt.Errorf("inner is nil")
+	}
+
+	return &cartNormalized{inner: inner, lowerCase: lowerCase}, nil
+}
+
+func (r *cartNormalized) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	return r.inner.GetCart(ctx, ownerID)
+}
+
+func (r *cartNormalized) GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.GetCartWithLineTotals(ctx, ownerID)
+}
+
+func (r *cartNormalized) GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.GetCartAfter(ctx, ownerID, afterCreatedAt, afterProductID, limit)
+}
+
+func (r *cartNormalized) GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.GetCartInRange(ctx, ownerID, from, to)
+}
+
+func (r *cartNormalized) AddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.AddItem(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.ValidateAddItem(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) SetItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.SetItem(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.AddOrRemoveItem(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.ImportItem(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.AddItemIdempotent(ctx, ownerID, item, idempotencyKey)
+}
+
+func (r *cartNormalized) AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.CartItem{}, err
+	}
+
+	return r.inner.AddItemReturning(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.AddItemReturningPrevious(ctx, ownerID, item)
+}
+
+func (r *cartNormalized) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.AddItemsBestEffort(ctx, ownerID, items)
+}
+
+func (r *cartNormalized) GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.CartItem{}, err
+	}
+
+	return r.inner.GetItem(ctx, ownerID, productID)
+}
+
+func (r *cartNormalized) UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return false, err
+	}
+
+	return r.inner.UpdateItemPrice(ctx, ownerID, productID, newPrice)
+}
+
+func (r *cartNormalized) UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.UpdateItemPriceIfVersion(ctx, ownerID, productID, newPrice, expectedVersion)
+}
+
+func (r *cartNormalized) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return false, err
+	}
+
+	return r.inner.DeleteItem(ctx, ownerID, productID)
+}
+
+func (r *cartNormalized) DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.CartItem{}, err
+	}
+
+	return r.inner.DecrementItem(ctx, ownerID, productID, by)
+}
+
+func (r *cartNormalized) ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.ApplyQuantityDeltas(ctx, ownerID, deltas)
+}
+
+func (r *cartNormalized) SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.SwapQuantities(ctx, ownerID, productA, productB)
+}
+
+func (r *cartNormalized) DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.DeleteItems(ctx, ownerID, productIDs)
+}
+
+func (r *cartNormalized) RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.RestoreItem(ctx, ownerID, productID)
+}
+
+func (r *cartNormalized) GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.GetItemsByCurrency(ctx, ownerID, cur)
+}
+
+func (r *cartNormalized) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.StreamCart(ctx, ownerID, fn)
+}
+
+func (r *cartNormalized) ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.ClearCurrency(ctx, ownerID, cur)
+}
+
+func (r *cartNormalized) ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.ApplyPriceFactor(ctx, ownerID, cur, factor)
+}
+
+func (r *cartNormalized) CartExists(ctx context.Context, ownerID string) (bool, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return false, err
+	}
+
+	return r.inner.CartExists(ctx, ownerID)
+}
+
+func (r *cartNormalized) CountItems(ctx context.Context, ownerID string) (int64, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.CountItems(ctx, ownerID)
+}
+
+func (r *cartNormalized) ClearCart(ctx context.Context, ownerID string) (int64, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.ClearCart(ctx, ownerID)
+}
+
+func (r *cartNormalized) MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	fromOwnerID, err := r.normalize("fromOwnerID", fromOwnerID)
+	if err != nil {
+		return 0, err
+	}
+	toOwnerID, err = r.normalize("toOwnerID", toOwnerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.MoveItems(ctx, fromOwnerID, toOwnerID)
+}
+
+func (r *cartNormalized) PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	return r.inner.PurgeCartsOlderThan(ctx, cutoff)
+}
+
+func (r *cartNormalized) CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.CurrencyReport{}, err
+	}
+
+	return r.inner.CartCurrencyReport(ctx, ownerID)
+}
+
+func (r *cartNormalized) CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return currency.Unit{}, err
+	}
+
+	return r.inner.CartCurrency(ctx, ownerID)
+}
+
+func (r *cartNormalized) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.Money{}, err
+	}
+
+	return r.inner.GetCartTotal(ctx, ownerID)
+}
+
+func (r *cartNormalized) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.Money{}, err
+	}
+
+	return r.inner.GetCartTotalIn(ctx, ownerID, target, rates)
+}
+
+func (r *cartNormalized) TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.Money{}, err
+	}
+
+	return r.inner.TotalExcluding(ctx, ownerID, excluded)
+}
+
+func (r *cartNormalized) ListCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.ListCartNames(ctx, ownerID)
+}
+
+func (r *cartNormalized) ListOwners(ctx context.Context, limit, offset int32) ([]string, error) {
+	return r.inner.ListOwners(ctx, limit, offset)
+}
+
+func (r *cartNormalized) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return domain.SyncPlan{}, err
+	}
+
+	return r.inner.PlanSync(ctx, ownerID, desired)
+}
+
+func (r *cartNormalized) ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.ApplySync(ctx, ownerID, plan)
+}
+
+func (r *cartNormalized) ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.ReplaceCart(ctx, ownerID, items)
+}
+
+func (r *cartNormalized) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.ValidateProducts(ctx, ownerID, exists)
+}
+
+func (r *cartNormalized) ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return r.inner.ItemAddedAt(ctx, ownerID, productID)
+}
+
+func (r *cartNormalized) ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.inner.ItemRecencyRank(ctx, ownerID, productID)
+}
+
+func (r *cartNormalized) ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	return r.inner.ProductWeightedAvgPrice(ctx, productID, cur)
+}
+
+func (r *cartNormalized) GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	ownerIDs, err := r.ownerIDs(ownerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.inner.GetCartsByOwners(ctx, ownerIDs)
+}
+
+func (r *cartNormalized) GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	return r.inner.GetRecentItems(ctx, limit)
+}
+
+func (r *cartNormalized) DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return r.inner.DemandByProduct(ctx, productIDs)
+}
+
+func (r *cartNormalized) TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error) {
+	return r.inner.TopProducts(ctx, limit)
+}
+
+func (r *cartNormalized) DistinctCurrencies(ctx context.Context) ([]currency.Unit, error) {
+	return r.inner.DistinctCurrencies(ctx)
+}
+
+func (r *cartNormalized) SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return err
+	}
+
+	return r.inner.SetOwnerCurrency(ctx, ownerID, cur)
+}
+
+func (r *cartNormalized) GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error) {
+	ownerID, err := r.ownerID(ownerID)
+	if err != nil {
+		return currency.Unit{}, false, err
+	}
+
+	return r.inner.GetOwnerCurrency(ctx, ownerID)
+}
+
+func (r *cartNormalized) HealthCheck(ctx context.Context) error {
+	return r.inner.HealthCheck(ctx)
+}
+
+func (r *cartNormalized) Shutdown(ctx context.Context) error {
+	return r.inner.Shutdown(ctx)
+}
+
+func (r *cartNormalized) ownerID(ownerID string) (string, error) {
+	return r.normalize("ownerID", ownerID)
+}
+
+func (r *cartNormalized) ownerIDs(ownerIDs []string) ([]string, error) {
+	if ownerIDs == nil {
+		return nil, nil
+	}
+
+	normalized := make([]string, len(ownerIDs))
+	for i, ownerID := range ownerIDs {
+		var err error
+		if normalized[i], err = r.normalize(fmt.Sprintf("ownerIDs[%d]", i), ownerID); err != nil {
+			return nil, err
+		}
+	}
+
+	return normalized, nil
+}
+
+// normalize trims the surrounding whitespace, also lower-casing with WithLowerCaseOwnerIDs,
+// and rejects the owner ID named name when nothing is left.
+func (r *cartNormalized) normalize(name, ownerID string) (string, error) {
+	ownerID = strings.TrimSpace(ownerID)
+	if ownerID == "" {
+		return "", fmt.Errorf("%s is empty", name)
+	}
+	if r.lowerCase {
+		ownerID = strings.ToLower(ownerID)
+	}
+
+	return ownerID, nil
+}
+
+// foldOwnerID maps the owner IDs any normalization of NewCart may treat as one to the same key.
+func foldOwnerID(ownerID string) string {
+	return strings.ToLower(strings.TrimSpace(ownerID))
+}
@@ -0,0 +1,132 @@
+package repository_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *cartRepositorySuite) TestOwnerIDNormalization() {
+	defer suite.deleteAll()
+
+	suite.Run("surrounding whitespace: same cart", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, " "+ownerID+" ", item))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		cart, err := suite.repo.GetCart(ctx, "\t"+ownerID+"\n")
+		require.NoError(t, err)
+		assert.Equal(t, ownerID, cart.OwnerID)
+		require.Len(t, cart.Items, 1)
+		assert.Equal(t, 2*item.Quantity, cart.Items[0].Quantity)
+
+		carts, err := suite.repo.GetCartsByOwners(ctx, []string{" " + ownerID})
+		require.NoError(t, err)
+		require.Contains(t, carts, ownerID)
+		assert.Len(t, carts[ownerID].Items, 1)
+	})
+
+	suite.Run("other case: other cart", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, suite.repo.AddItem(ctx, strings.ToUpper(ownerID), randomCartItem()))
+
+		exists, err := suite.repo.CartExists(ctx, ownerID)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	suite.Run("other case with WithLowerCaseOwnerIDs: same cart", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		repo, err := repository.NewCart(suite.pool, repository.WithLowerCaseOwnerIDs())
+		require.NoError(t, err)
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, repo.AddItem(ctx, " "+strings.ToUpper(ownerID), item))
+
+		got, err := repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+
+		// stored lower-cased, so also found without the option
+		exists, err := suite.repo.CartExists(ctx, ownerID)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	suite.Run("whitespace only: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.CountItems(t.Context(), " \t ")
+		require.EqualError(t, err, "ownerID is empty")
+
+		_, err = suite.repo.GetCart(t.Context(), " ")
+		require.EqualError(t, err, "ownerID is empty")
+
+		err = suite.repo.AddItem(t.Context(), "\n", randomCartItem())
+		require.EqualError(t, err, "ownerID is empty")
+
+		_, err = suite.repo.MoveItems(t.Context(), gofakeit.UUID(), "  ")
+		require.EqualError(t, err, "toOwnerID is empty")
+	})
+}
+
+func TestNewCartWithNormalizedOwnerIDs(t *testing.T) {
+	_, err := repository.NewCartWithNormalizedOwnerIDs(nil, false)
+	require.EqualError(t, err, "inner is nil")
+
+	repo, err := repository.NewCartWithNormalizedOwnerIDs(&fakeCartRepository{}, true)
+	require.NoError(t, err)
+
+	cart, err := repo.GetCart(t.Context(), " Owner ")
+	require.NoError(t, err)
+	assert.Equal(t, "owner", cart.OwnerID)
+}
+
+func TestNewCartWithNormalizedOwnerIDsWhitespaceOnly(t *testing.T) {
+	// the fake panics when a call reaches it
+	repo, err := repository.NewCartWithNormalizedOwnerIDs(&fakeCartRepository{}, false)
+	require.NoError(t, err)
+
+	ctx := t.Context()
+	const ownerID = " \t\n"
+
+	_, err = repo.GetCart(ctx, ownerID)
+	require.EqualError(t, err, "ownerID is empty")
+
+	err = repo.AddItem(ctx, ownerID, domain.CartItem{})
+	require.EqualError(t, err, "ownerID is empty")
+
+	_, err = repo.DeleteItem(ctx, ownerID, uuid.New())
+	require.EqualError(t, err, "ownerID is empty")
+
+	_, err = repo.CartCurrency(ctx, ownerID)
+	require.EqualError(t, err, "ownerID is empty")
+
+	_, err = repo.GetCartTotal(ctx, ownerID)
+	require.EqualError(t, err, "ownerID is empty")
+
+	_, _, err = repo.GetOwnerCurrency(ctx, ownerID)
+	require.EqualError(t, err, "ownerID is empty")
+
+	_, err = repo.MoveItems(ctx, ownerID, "owner")
+	require.EqualError(t, err, "fromOwnerID is empty")
+
+	_, err = repo.GetCartsByOwners(ctx, []string{"owner", ownerID})
+	require.EqualError(t, err, "ownerIDs[1] is empty")
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/currency"
+)
+
+// DefaultCartName is the cart used unless WithCartName is given.
+const DefaultCartName = "default"
+
+// CartOption configures the CartRepository created by NewCart.
+type CartOption func(*cartOptions)
+
+type cartOptions struct {
+	cartName            string
+	requireV4ProductIDs bool
+	fallbackCurrency    currency.Unit
+	logger              *slog.Logger
+	tracer              trace.Tracer
+	metrics             Metrics
+	poolExhaustedError  bool
+	queryTimeout        time.Duration
+	txRetry             txRetry
+	txOptions           pgx.TxOptions
+	observer            Observer
+	queryExecMode       pgx.QueryExecMode
+	contextKeys         []ContextKey
+	schema              string
+	lowerCaseOwnerIDs   bool
+	clock               Clock
+}
+
+// WithCartName makes the owner scoped methods work with the owner's cart of the given name.
+func WithCartName(name string) CartOption {
+	return func(o *cartOptions) {
+		o.cartName = name
+	}
+}
+
+// WithV4ProductIDs makes the methods adding, reading, updating or deleting an item reject product IDs
+// which are not version 4 UUIDs.
+func WithV4ProductIDs() CartOption {
+	return func(o *cartOptions) {
+		o.requireV4ProductIDs = true
+	}
+}
+
+// WithFallbackCurrency makes GetCart and StreamCart use the given currency for items with an empty or
+// invalid stored currency code, logging a warning instead of failing the whole read.
+func WithFallbackCurrency(cur currency.Unit) CartOption {
+	return func(o *cartOptions) {
+		o.fallbackCurrency = cur
+	}
+}
+
+// WithLogger sets the logger of every call, nothing is logged otherwise. The calls are logged at debug level,
+// the failed ones at error level.
+func WithLogger(logger *slog.Logger) CartOption {
+	return func(o *cartOptions) {
+		o.logger = logger
+	}
+}
+
+// WithTracer makes GetCart, AddItem and DeleteItem start a span named like cartRepository.GetCart,
+// a no-op tracer is used otherwise.
+func WithTracer(tracer trace.Tracer) CartOption {
+	return func(o *cartOptions) {
+		o.tracer = tracer
+	}
+}
+
+// WithContextKeys adds the values of the keys found in the call context to the log records and the spans,
+// keys missing from the context are skipped. The span attributes hold the values formatted by fmt.Sprint.
+func WithContextKeys(keys ...ContextKey) CartOption {
+	return func(o *cartOptions) {
+		o.contextKeys = keys
+	}
+}
+
+// WithMetrics records the method, the outcome and the duration of every call, nothing is recorded otherwise.
+func WithMetrics(metrics Metrics) CartOption {
+	return func(o *cartOptions) {
+		o.metrics = metrics
+	}
+}
+
+// WithPoolExhaustedError makes calls which time out waiting for a pool connection fail with ErrPoolExhausted,
+// telling a busy database apart from a failed query. The context error stays in the error chain.
+func WithPoolExhaustedError() CartOption {
+	return func(o *cartOptions) {
+		o.poolExhaustedError = true
+	}
+}
+
+// WithQueryTimeout bounds every query by the timeout unless the caller's context has an earlier deadline,
+// zero disables it.
+func WithQueryTimeout(timeout time.Duration) CartOption {
+	return func(o *cartOptions) {
+		o.queryTimeout = timeout
+	}
+}
+
+// WithTxRetry makes the methods running a transaction retry it up to maxAttempts times in total when it fails
+// with a serialization failure or a deadlock, waiting backoff before the first retry and twice as long before
+// every next one. Transactions are not retried when the repository is bound to a pgx.Tx.
+func WithTxRetry(maxAttempts int, backoff time.Duration) CartOption {
+	return func(o *cartOptions) {
+		o.txRetry = txRetry{maxAttempts: maxAttempts, backoff: backoff}
+	}
+}
+
+// WithTxOptions starts the transactions of the methods needing one and of RunInTx with the given options,
+// e.g. pgx.RepeatableRead isolation or pgx.ReadOnly access. The options are ignored when the repository
+// is bound to a pgx.Tx, whose savepoints keep the options of the transaction.
+func WithTxOptions(txOptions pgx.TxOptions) CartOption {
+	return func(o *cartOptions) {
+		o.txOptions = txOptions
+	}
+}
+
+// WithQueryExecMode runs every query with the given pgx mode instead of the default mode of the connection.
+//
+// pgx.QueryExecModeCacheStatement prepares a query once per connection and then only sends its arguments,
+// saving the server from parsing and planning it on every call. pgx.QueryExecModeDescribeExec and
+// pgx.QueryExecModeExec do not keep prepared statements, which costs a round trip or parsing per call.
+// Behind pgbouncer in transaction mode a prepared statement may be missing on the next server connection,
+// use pgx.QueryExecModeExec or pgx.QueryExecModeSimpleProtocol there unless pgbouncer tracks prepared
+// statements, as 1.21 and later do with max_prepared_statements.
+func WithQueryExecMode(mode pgx.QueryExecMode) CartOption {
+	return func(o *cartOptions) {
+		o.queryExecMode = mode
+	}
+}
+
+// WithSchema makes the queries use the tables of the given schema instead of the ones on the search_path
+// of the connection, e.g. to host the carts of several tenants in one database. The schema must already exist
+// with all tables of the migrations. An empty schema keeps the search_path of the connection.
+//
+// The search_path is set by every call in a transaction of its own, local to it, so a pool can be shared
+// with repositories of other schemas. This costs extra round trips per call, a pool dedicated to the schema
+// with search_path in its RuntimeParams is cheaper where that matters.
+func WithSchema(schema string) CartOption {
+	return func(o *cartOptions) {
+		o.schema = schema
+	}
+}
+
+// WithLowerCaseOwnerIDs also lower-cases the owner IDs, which are always trimmed, so "ABC" and "abc" are the same
+// cart. The stored owner IDs are not migrated: carts stored before with upper-case letters, like the ones stored
+// with surrounding whitespace, are not found anymore until their owner IDs are normalized in the database
+// the same way, e.g. LOWER(TRIM(owner_id)), merging the items of owners which become the same.
+func WithLowerCaseOwnerIDs() CartOption {
+	return func(o *cartOptions) {
+		o.lowerCaseOwnerIDs = true
+	}
+}
+
+// WithClock sets the clock of the created_at, deleted_at and updated_at times written by the repository,
+// SystemClock is used otherwise. The cutoff of PurgeCartsOlderThan is given by the caller.
+func WithClock(clock Clock) CartOption {
+	return func(o *cartOptions) {
+		o.clock = clock
+	}
+}
+
+// WithObserver notifies the observer of the items added by AddItem, AddItemIdempotent, AddItemReturning,
+// AddItemReturningPrevious and AddItemsBestEffort and of the items deleted by DeleteItem or DecrementItem.
+// The other methods changing items notify it of the changed carts, e.g. ClearCart, MoveItems or ReplaceCart.
+// RunInTx notifies it once its transaction commits, a repository bound to a pgx.Tx by the caller
+// notifies it when the call succeeds, before the caller commits.
+func WithObserver(observer Observer) CartOption {
+	return func(o *cartOptions) {
+		o.observer = observer
+	}
+}
@@ -0,0 +1,140 @@
+package repository_test
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestNewCart(t *testing.T) {
+	// the pool connects lazily, so no database is needed to construct the repository
+	pool, err := pgxpool.New(t.Context(), "postgres://localhost:1/cart")
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	tests := []struct {
+		name      string
+		dbtx      db.DBTX
+		opts      []repository.CartOption
+		wantError string
+	}{
+		{
+			name: "no options: ok",
+			dbtx: pool,
+		},
+		{
+			name: "all options: ok",
+			dbtx: pool,
+			opts: []repository.CartOption{
+				repository.WithCartName("wishlist"),
+				repository.WithV4ProductIDs(),
+				repository.WithLogger(slog.New(slog.DiscardHandler)),
+				repository.WithTracer(noop.NewTracerProvider().Tracer("")),
+				repository.WithMetrics(&fakeMetrics{}),
+				repository.WithPoolExhaustedError(),
+				repository.WithQueryTimeout(time.Second),
+				repository.WithTxRetry(3, time.Millisecond),
+				repository.WithTxOptions(pgx.TxOptions{IsoLevel: pgx.RepeatableRead}),
+				repository.WithObserver(&recordingObserver{}),
+				repository.WithQueryExecMode(pgx.QueryExecModeExec),
+				repository.WithContextKeys(repository.ContextKey{Name: "request_id", Key: "request_id"}),
+			},
+		},
+		{
+			name:      "nil dbtx: error",
+			wantError: "dbtx is nil",
+		},
+		{
+			name:      "empty cart name: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithCartName("")},
+			wantError: "cartName is empty",
+		},
+		{
+			name:      "nil logger: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithLogger(nil)},
+			wantError: "logger is nil",
+		},
+		{
+			name:      "nil tracer: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithTracer(nil)},
+			wantError: "tracer is nil",
+		},
+		{
+			name:      "nil metrics: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithMetrics(nil)},
+			wantError: "metrics is nil",
+		},
+		{
+			name:      "nil observer: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithObserver(nil)},
+			wantError: "observer is nil",
+		},
+		{
+			name:      "nil clock: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithClock(nil)},
+			wantError: "clock is nil",
+		},
+		{
+			name:      "negative query timeout: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithQueryTimeout(-time.Second)},
+			wantError: "queryTimeout[-1s] is negative",
+		},
+		{
+			name:      "no tx attempts: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithTxRetry(0, time.Millisecond)},
+			wantError: "maxAttempts[0] is not positive",
+		},
+		{
+			name:      "negative tx backoff: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithTxRetry(3, -time.Second)},
+			wantError: "backoff[-1s] is negative",
+		},
+		{
+			name:      "invalid query exec mode: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithQueryExecMode(42)},
+			wantError: "queryExecMode[42] is not valid",
+		},
+		{
+			name:      "unnamed context key: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithContextKeys(repository.ContextKey{Key: "request_id"})},
+			wantError: "contextKey name is empty",
+		},
+		{
+			name:      "nil context key: error",
+			dbtx:      pool,
+			opts:      []repository.CartOption{repository.WithContextKeys(repository.ContextKey{Name: "request_id"})},
+			wantError: "contextKey[request_id] key is nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := repository.NewCart(tt.dbtx, tt.opts...)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, repo)
+		})
+	}
+}
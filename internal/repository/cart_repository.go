@@ -1,37 +1,240 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/nikolayk812/sqlcpp-demo/internal/db"
 	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
 	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/trace/noop"
 	"golang.org/x/text/currency"
 )
 
+var ErrShuttingDown = errors.New("repository is shutting down")
+
 type cartRepository struct {
 	q    *db.Queries
 	dbtx db.DBTX
+	opts cartOptions
+
+	mu       sync.RWMutex
+	closing  bool
+	inFlight sync.WaitGroup
 }
 
 // NewCart creates a new CartRepository with the given dbtx (pgx.Tx or pgxpool.Pool).
-func NewCart(dbtx db.DBTX) (port.CartRepository, error) {
+// Every method trims the surrounding whitespace of the owner IDs it is given, so " abc " and "abc" are the same
+// cart, see WithLowerCaseOwnerIDs for the case. An owner ID empty once trimmed fails where an empty one does.
+// The stored owner IDs are not changed, carts stored with surrounding whitespace before trimming was added
+// are not found anymore until their owner IDs are trimmed in the database, merging the items of owners
+// which become the same.
+func NewCart(dbtx db.DBTX, opts ...CartOption) (port.CartRepository, error) {
 	if dbtx == nil {
 		return nil, fmt.Errorf("dbtx is nil")
 	}
 
-	return &cartRepository{
+	options := cartOptions{
+		cartName: DefaultCartName,
+		logger:   slog.New(slog.DiscardHandler),
+		tracer:   noop.NewTracerProvider().Tracer(""),
+		metrics:  noopMetrics{},
+		txRetry:  txRetry{maxAttempts: 1},
+		observer: noopObserver{},
+		clock:    SystemClock,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if options.cartName == "" {
+		return nil, fmt.Errorf("cartName is empty")
+	}
+	if options.logger == nil {
+		return nil, fmt.Errorf("logger is nil")
+	}
+	if options.tracer == nil {
+		return nil, fmt.Errorf("tracer is nil")
+	}
+	if options.metrics == nil {
+		return nil, fmt.Errorf("metrics is nil")
+	}
+	if options.observer == nil {
+		return nil, fmt.Errorf("observer is nil")
+	}
+	if options.clock == nil {
+		return nil, fmt.Errorf("clock is nil")
+	}
+	if options.queryTimeout < 0 {
+		return nil, fmt.Errorf("queryTimeout[%s] is negative", options.queryTimeout)
+	}
+	if options.txRetry.maxAttempts <= 0 {
+		return nil, fmt.Errorf("maxAttempts[%d] is not positive", options.txRetry.maxAttempts)
+	}
+	if options.txRetry.backoff < 0 {
+		return nil, fmt.Errorf("backoff[%s] is negative", options.txRetry.backoff)
+	}
+	// the zero mode is not set, keeping the default mode of the connection
+	if options.queryExecMode != 0 && options.queryExecMode.String() == "invalid" {
+		return nil, fmt.Errorf("queryExecMode[%d] is not valid", options.queryExecMode)
+	}
+	for _, key := range options.contextKeys {
+		if key.Name == "" {
+			return nil, fmt.Errorf("contextKey name is empty")
+		}
+		if key.Key == nil {
+			return nil, fmt.Errorf("contextKey[%s] key is nil", key.Name)
+		}
+	}
+
+	if len(options.contextKeys) > 0 {
+		options.logger = slog.New(contextHandler{Handler: options.logger.Handler(), keys: options.contextKeys})
+	}
+
+	// a serialization failure aborts the caller's transaction, a savepoint of it cannot be retried
+	// nor started with other options
+	if _, ok := dbtx.(pgx.Tx); ok {
+		options.txRetry.maxAttempts = 1
+		options.txOptions = pgx.TxOptions{}
+	}
+
+	if options.schema != "" {
+		dbtx = schemaDBTX{dbtx: dbtx, schema: options.schema}
+	}
+	if options.queryExecMode != 0 {
+		dbtx = execModeDBTX{dbtx: dbtx, mode: options.queryExecMode}
+	}
+	if options.poolExhaustedError {
+		dbtx = poolErrorDBTX{dbtx: dbtx}
+	}
+	// wrapped last, so waiting for a pool connection longer than the query timeout counts as pool exhaustion
+	if options.queryTimeout > 0 {
+		dbtx = timeoutDBTX{dbtx: dbtx, timeout: options.queryTimeout}
+	}
+
+	repo := &cartRepository{
 		q:    db.New(dbtx),
 		dbtx: dbtx,
-	}, nil
+		opts: options,
+	}
+
+	instrumented := &cartInstrumented{
+		inner:       repo,
+		logger:      options.logger,
+		tracer:      options.tracer,
+		metrics:     options.metrics,
+		contextKeys: options.contextKeys,
+	}
+
+	// applied last, so the calls are logged and observed with the normalized owner IDs
+	return NewCartWithNormalizedOwnerIDs(instrumented, options.lowerCaseOwnerIDs)
+}
+
+// Shutdown makes new calls fail with ErrShuttingDown and waits for the in-flight ones to finish,
+// then closes dbtx when it is a pool. The pool is left open when ctx is done first, a pgx.Tx is never
+// committed nor rolled back as it belongs to the caller. Observers and metrics are called synchronously,
+// so nothing is left to flush.
+func (r *cartRepository) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.closing = true
+	r.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return fmt.Errorf("ctx.Done: %w", ctx.Err())
+	}
+
+	if pool, ok := r.dbtx.(interface{ Close() }); ok {
+		pool.Close()
+	}
+
+	return nil
+}
+
+// HealthCheck runs a trivial query, failing when the database is unreachable or ctx is done first.
+func (r *cartRepository) HealthCheck(ctx context.Context) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if _, err := r.q.Ping(ctx); err != nil {
+		return fmt.Errorf("q.Ping: %w", err)
+	}
+
+	return nil
+}
+
+// begin registers an in-flight call which must be finished with end.
+func (r *cartRepository) begin() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// closing is checked under the same lock Shutdown sets it with, so no call is added once Shutdown waits
+	if r.closing {
+		return ErrShuttingDown
+	}
+
+	r.inFlight.Add(1)
+	return nil
+}
+
+func (r *cartRepository) end() {
+	r.inFlight.Done()
+}
+
+// now is the time written to the created_at and deleted_at columns, in UTC as created_at has no time zone.
+func (r *cartRepository) now() time.Time {
+	return r.opts.clock.Now().UTC()
 }
 
 func (r *cartRepository) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	if err := r.begin(); err != nil {
+		return domain.Cart{}, err
+	}
+	defer r.end()
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	if len(cart.Items) == 0 {
+		if err := r.checkCartKnown(ctx, ownerID); err != nil {
+			return domain.Cart{}, err
+		}
+	}
+
+	return cart, nil
+}
+
+// getCart reads the cart like GetCart, returning an empty cart instead of domain.ErrCartNotFound.
+func (r *cartRepository) getCart(ctx context.Context, ownerID string) (domain.Cart, error) {
 	var cart domain.Cart
 
-	dbRows, err := r.q.GetCart(ctx, ownerID)
+	params := db.GetCartParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	dbRows, err := r.q.GetCart(ctx, params)
 	if err != nil {
 		return cart, fmt.Errorf("q.GetCart: %w", err)
 	}
@@ -40,9 +243,9 @@ func (r *cartRepository) GetCart(ctx context.Context, ownerID string) (domain.Ca
 	cart.Items = make([]domain.CartItem, 0, len(dbRows))
 
 	for _, row := range dbRows {
-		item, err := mapGetCartRowToDomainCartItem(row)
+		item, err := r.mapGetCartRow(ctx, row)
 		if err != nil {
-			return cart, fmt.Errorf("mapGetCartRowToDomainCartItem: %w", err)
+			return cart, fmt.Errorf("r.mapGetCartRow: %w", err)
 		}
 		cart.Items = append(cart.Items, item)
 	}
@@ -50,48 +253,1928 @@ func (r *cartRepository) GetCart(ctx context.Context, ownerID string) (domain.Ca
 	return cart, nil
 }
 
+// GetCartWithLineTotals maps the items like GetCart, the line total takes the currency of the mapped price.
+func (r *cartRepository) GetCartWithLineTotals(ctx context.Context, ownerID string) ([]domain.CartLine, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	params := db.GetCartWithLineTotalsParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	dbRows, err := r.q.GetCartWithLineTotals(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetCartWithLineTotals: %w", err)
+	}
+
+	lines := make([]domain.CartLine, 0, len(dbRows))
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow{
+			ProductID:     row.ProductID,
+			PriceAmount:   row.PriceAmount,
+			PriceCurrency: row.PriceCurrency,
+			Quantity:      row.Quantity,
+			CreatedAt:     row.CreatedAt,
+			Note:          row.Note,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+
+		lines = append(lines, domain.CartLine{
+			Item:      item,
+			LineTotal: domain.Money{Amount: row.LineTotal, Currency: item.Price.Currency},
+		})
+	}
+
+	return lines, nil
+}
+
+func (r *cartRepository) GetCartAfter(ctx context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	// zero cursor values sort before every stored item,
+	// created_at is stored in UTC and pgx discards the location of a TIMESTAMP param
+	params := db.GetCartAfterParams{
+		OwnerID:        ownerID,
+		CartName:       r.opts.cartName,
+		AfterCreatedAt: afterCreatedAt.UTC(),
+		AfterProductID: afterProductID,
+		PageLimit:      limit,
+	}
+
+	dbRows, err := r.q.GetCartAfter(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetCartAfter: %w", err)
+	}
+
+	items := make([]domain.CartItem, 0, len(dbRows))
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow(row))
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (r *cartRepository) GetCartInRange(ctx context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return nil, fmt.Errorf("from[%s] is after to[%s]", from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano))
+	}
+
+	params := db.GetCartInRangeParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	// zero bounds stay NULL so the window is open on that side,
+	// created_at is stored in UTC and pgx discards the location of a TIMESTAMP param
+	if !from.IsZero() {
+		from = from.UTC()
+		params.CreatedFrom = &from
+	}
+	if !to.IsZero() {
+		to = to.UTC()
+		params.CreatedTo = &to
+	}
+
+	dbRows, err := r.q.GetCartInRange(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetCartInRange: %w", err)
+	}
+
+	items := make([]domain.CartItem, 0, len(dbRows))
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow(row))
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (r *cartRepository) GetRecentItems(ctx context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	dbRows, err := r.q.GetRecentItems(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetRecentItems: %w", err)
+	}
+
+	items := make([]domain.OwnedCartItem, 0, len(dbRows))
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow{
+			ProductID:     row.ProductID,
+			PriceAmount:   row.PriceAmount,
+			PriceCurrency: row.PriceCurrency,
+			Quantity:      row.Quantity,
+			CreatedAt:     row.CreatedAt,
+			Note:          row.Note,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+		items = append(items, domain.OwnedCartItem{OwnerID: row.OwnerID, Item: item})
+	}
+
+	return items, nil
+}
+
+func (r *cartRepository) GetCartsByOwners(ctx context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	carts := make(map[string]domain.Cart, len(ownerIDs))
+	if len(ownerIDs) == 0 {
+		return carts, nil
+	}
+
+	for _, ownerID := range ownerIDs {
+		carts[ownerID] = domain.Cart{OwnerID: ownerID, Items: []domain.CartItem{}}
+	}
+
+	params := db.GetCartsByOwnersParams{
+		OwnerIds: slices.Collect(maps.Keys(carts)),
+		CartName: r.opts.cartName,
+	}
+
+	dbRows, err := r.q.GetCartsByOwners(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetCartsByOwners: %w", err)
+	}
+
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow{
+			ProductID:     row.ProductID,
+			PriceAmount:   row.PriceAmount,
+			PriceCurrency: row.PriceCurrency,
+			Quantity:      row.Quantity,
+			CreatedAt:     row.CreatedAt,
+			Note:          row.Note,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+
+		cart := carts[row.OwnerID]
+		cart.Items = append(cart.Items, item)
+		carts[row.OwnerID] = cart
+	}
+
+	return carts, nil
+}
+
 func (r *cartRepository) AddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
-	params := db.AddItemParams{
-		OwnerID:       ownerID,
-		ProductID:     item.ProductID,
-		PriceAmount:   item.Price.Amount,
-		PriceCurrency: item.Price.Currency.String(),
+	if err := r.begin(); err != nil {
+		return err
 	}
+	defer r.end()
 
-	err := r.q.AddItem(ctx, params)
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+
+	if item.Price.Currency != (currency.Unit{}) {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+
+		err := r.q.AddItem(ctx, mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now()))
+		if err != nil {
+			return fmt.Errorf("q.AddItem: %w", err)
+		}
+
+		r.opts.observer.OnItemAdded(ownerID, item)
+		return nil
+	}
+
+	// the item currency is not provided, fall back to the owner's default currency
+	_, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (struct{}, error) {
+		var err error
+		item.Price.Currency, err = getOwnerDefaultCurrency(ctx, q, ownerID)
+		if err != nil {
+			return struct{}{}, err
+		}
+		if err := validateItem(item); err != nil {
+			return struct{}{}, err
+		}
+
+		if err := q.AddItem(ctx, mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now())); err != nil {
+			return struct{}{}, fmt.Errorf("q.AddItem: %w", err)
+		}
+
+		return struct{}{}, nil
+	})
 	if err != nil {
-		return fmt.Errorf("q.AddItem: %w", err)
+		return err
 	}
 
+	r.opts.observer.OnItemAdded(ownerID, item)
 	return nil
 }
 
-func (r *cartRepository) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+func (r *cartRepository) ValidateAddItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+
+	// AddItem reads the owner's default currency the same way, only in the transaction of the write
+	if item.Price.Currency == (currency.Unit{}) {
+		var err error
+		item.Price.Currency, err = getOwnerDefaultCurrency(ctx, r.q, ownerID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return validateItem(item)
+}
+
+func (r *cartRepository) SetItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	return r.setItem(ctx, ownerID, item)
+}
+
+func (r *cartRepository) AddOrRemoveItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if item.Quantity != 0 {
+		return r.setItem(ctx, ownerID, item)
+	}
+
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+
 	params := db.DeleteItemParams{
 		OwnerID:   ownerID,
-		ProductID: productID,
+		CartName:  r.opts.cartName,
+		ProductID: item.ProductID,
+		DeletedAt: r.now(),
 	}
 
 	rowsAffected, err := r.q.DeleteItem(ctx, params)
 	if err != nil {
-		return false, fmt.Errorf("q.DeleteItem: %w", err)
+		return fmt.Errorf("q.DeleteItem: %w", err)
+	}
+
+	// an item not in the cart is already removed as desired
+	if rowsAffected > 0 {
+		r.opts.observer.OnItemDeleted(ownerID, item.ProductID)
+	}
+	return nil
+}
+
+// setItem is SetItem without the shutdown tracking of r.begin.
+func (r *cartRepository) setItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	if err := validateItem(item); err != nil {
+		return err
+	}
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+
+	params := db.SetItemParams(mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now()))
+	if err := r.q.SetItem(ctx, params); err != nil {
+		return fmt.Errorf("q.SetItem: %w", err)
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+func (r *cartRepository) ImportItem(ctx context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if err := validateItem(item); err != nil {
+		return err
+	}
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+
+	addParams := mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now())
+	params := db.ImportItemParams{
+		OwnerID:       addParams.OwnerID,
+		CartName:      addParams.CartName,
+		ProductID:     addParams.ProductID,
+		PriceAmount:   addParams.PriceAmount,
+		PriceCurrency: addParams.PriceCurrency,
+		Quantity:      addParams.Quantity,
+		Note:          addParams.Note,
+		Now:           addParams.CreatedAt,
+	}
+	// the column has no time zone, a zero CreatedAt falls back to now
+	if !item.CreatedAt.IsZero() {
+		createdAt := item.CreatedAt.UTC()
+		params.CreatedAt = &createdAt
+	}
+
+	if err := r.q.ImportItem(ctx, params); err != nil {
+		return fmt.Errorf("q.ImportItem: %w", err)
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+func (r *cartRepository) AddItemIdempotent(ctx context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if idempotencyKey == "" {
+		return fmt.Errorf("idempotencyKey is empty")
+	}
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return err
+	}
+	// an item without currency is validated once it is resolved
+	if item.Price.Currency != (currency.Unit{}) {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+	}
+
+	added, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (bool, error) {
+		params := db.AddIdempotencyKeyParams{
+			OwnerID:        ownerID,
+			IdempotencyKey: idempotencyKey,
+			CreatedAt:      r.now(),
+		}
+
+		// a concurrent call with the same key waits here until the first one commits or rolls back
+		added, err := q.AddIdempotencyKey(ctx, params)
+		if err != nil {
+			return false, fmt.Errorf("q.AddIdempotencyKey: %w", err)
+		}
+		if added == 0 {
+			return false, nil
+		}
+
+		if item.Price.Currency == (currency.Unit{}) {
+			item.Price.Currency, err = getOwnerDefaultCurrency(ctx, q, ownerID)
+			if err != nil {
+				return false, err
+			}
+			if err := validateItem(item); err != nil {
+				return false, err
+			}
+		}
+
+		if err := q.AddItem(ctx, mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now())); err != nil {
+			return false, fmt.Errorf("q.AddItem: %w", err)
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if added {
+		r.opts.observer.OnItemAdded(ownerID, item)
+	}
+	return nil
+}
+
+// AddItemReturningPrevious upserts the item like AddItem and returns the item as it was before,
+// nil when the item was not in the cart.
+func (r *cartRepository) AddItemReturningPrevious(ctx context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return nil, err
 	}
 
-	return rowsAffected > 0, nil
+	var (
+		previous *domain.CartItem
+		err      error
+	)
+	if item.Price.Currency != (currency.Unit{}) {
+		if err := validateItem(item); err != nil {
+			return nil, err
+		}
+
+		previous, err = r.addItemReturningPrevious(ctx, r.q, ownerID, item)
+	} else {
+		previous, err = withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (*domain.CartItem, error) {
+			var err error
+			item.Price.Currency, err = getOwnerDefaultCurrency(ctx, q, ownerID)
+			if err != nil {
+				return nil, err
+			}
+			if err := validateItem(item); err != nil {
+				return nil, err
+			}
+
+			return r.addItemReturningPrevious(ctx, q, ownerID, item)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.opts.observer.OnItemAdded(ownerID, item)
+	return previous, nil
+}
+
+func (r *cartRepository) addItemReturningPrevious(ctx context.Context, q *db.Queries, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	params := db.AddItemReturningPreviousParams(mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now()))
+
+	row, err := q.AddItemReturningPrevious(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("q.AddItemReturningPrevious: %w", err)
+	}
+
+	previous, err := r.mapGetCartRow(ctx, db.GetCartRow(row))
+	if err != nil {
+		return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+	}
+
+	return &previous, nil
+}
+
+// AddItemReturning upserts the item like AddItem and returns it as stored, with the summed quantity
+// and the CreatedAt of the first addition.
+func (r *cartRepository) AddItemReturning(ctx context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(item.ProductID); err != nil {
+		return domain.CartItem{}, err
+	}
+
+	var (
+		stored domain.CartItem
+		err    error
+	)
+	if item.Price.Currency != (currency.Unit{}) {
+		if err := validateItem(item); err != nil {
+			return domain.CartItem{}, err
+		}
+
+		stored, err = r.addItemReturning(ctx, r.q, ownerID, item)
+	} else {
+		stored, err = withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (domain.CartItem, error) {
+			var err error
+			item.Price.Currency, err = getOwnerDefaultCurrency(ctx, q, ownerID)
+			if err != nil {
+				return domain.CartItem{}, err
+			}
+			if err := validateItem(item); err != nil {
+				return domain.CartItem{}, err
+			}
+
+			return r.addItemReturning(ctx, q, ownerID, item)
+		})
+	}
+	if err != nil {
+		return domain.CartItem{}, err
+	}
+
+	r.opts.observer.OnItemAdded(ownerID, item)
+	return stored, nil
+}
+
+func (r *cartRepository) addItemReturning(ctx context.Context, q *db.Queries, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	params := db.AddItemReturningParams(mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now()))
+
+	row, err := q.AddItemReturning(ctx, params)
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("q.AddItemReturning: %w", err)
+	}
+
+	stored, err := r.mapGetCartRow(ctx, db.GetCartRow(row))
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("r.mapGetCartRow: %w", err)
+	}
+
+	return stored, nil
+}
+
+// AddItemsBestEffort only returns an error when the context is done, along with the results of the items
+// attempted before it.
+func (r *cartRepository) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	results := make([]domain.ItemResult, 0, len(items))
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return results, fmt.Errorf("ctx.Err: %w", err)
+		}
+
+		results = append(results, domain.ItemResult{
+			ProductID: item.ProductID,
+			Err:       r.AddItem(ctx, ownerID, item),
+		})
+	}
+
+	return results, nil
+}
+
+func getOwnerDefaultCurrency(ctx context.Context, q *db.Queries, ownerID string) (currency.Unit, error) {
+	code, err := q.GetOwnerCurrency(ctx, ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return currency.Unit{}, fmt.Errorf("item currency is empty and owner has no default currency")
+	}
+	if err != nil {
+		return currency.Unit{}, fmt.Errorf("q.GetOwnerCurrency: %w", err)
+	}
+
+	cur, err := domain.ParseCurrency(code)
+	if err != nil {
+		return currency.Unit{}, err
+	}
+
+	return cur, nil
+}
+
+func (r *cartRepository) DeleteItem(ctx context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	if err := r.begin(); err != nil {
+		return false, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productID); err != nil {
+		return false, err
+	}
+
+	params := db.DeleteItemParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+		DeletedAt: r.now(),
+	}
+
+	rowsAffected, err := r.q.DeleteItem(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("q.DeleteItem: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, fmt.Errorf("q.DeleteItem: %w", domain.ErrItemNotFound)
+	}
+
+	r.opts.observer.OnItemDeleted(ownerID, productID)
+	return true, nil
+}
+
+func (r *cartRepository) DecrementItem(ctx context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productID); err != nil {
+		return domain.CartItem{}, err
+	}
+	if by <= 0 {
+		return domain.CartItem{}, fmt.Errorf("by[%d] is not positive", by)
+	}
+
+	params := db.DecrementItemParams{
+		By:        by,
+		DeletedAt: r.now(),
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	// a single statement, the quantity must stay positive so the row is deleted instead
+	row, err := r.q.DecrementItem(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.CartItem{}, fmt.Errorf("q.DecrementItem: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("q.DecrementItem: %w", err)
+	}
+
+	if row.DeletedAt != nil {
+		r.opts.observer.OnItemDeleted(ownerID, productID)
+		return domain.CartItem{}, nil
+	}
+
+	item, err := r.mapGetCartRow(ctx, db.GetCartRow{
+		ProductID:     row.ProductID,
+		PriceAmount:   row.PriceAmount,
+		PriceCurrency: row.PriceCurrency,
+		Quantity:      row.Quantity,
+		CreatedAt:     row.CreatedAt,
+		Note:          row.Note,
+	})
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("r.mapGetCartRow: %w", err)
+	}
+
+	return item, nil
+}
+
+// ApplyQuantityDeltas adds the signed deltas to the item quantities in a single transaction,
+// the products are updated in a stable order so concurrent calls do not deadlock.
+func (r *cartRepository) ApplyQuantityDeltas(ctx context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	productIDs := slices.SortedFunc(maps.Keys(deltas), compareProductIDs)
+	for _, productID := range productIDs {
+		if err := r.validateProductID(productID); err != nil {
+			return err
+		}
+	}
+
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	_, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (struct{}, error) {
+		for _, productID := range productIDs {
+			params := db.ApplyQuantityDeltaParams{
+				Delta:     deltas[productID],
+				DeletedAt: r.now(),
+				OwnerID:   ownerID,
+				CartName:  r.opts.cartName,
+				ProductID: productID,
+			}
+
+			// the quantity must stay positive so the row is deleted instead, like DecrementItem
+			_, err := q.ApplyQuantityDelta(ctx, params)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return struct{}{}, r.quantityDeltaError(ctx, q, ownerID, productID, params.Delta)
+			}
+			if err != nil {
+				return struct{}{}, fmt.Errorf("q.ApplyQuantityDelta: %w", err)
+			}
+		}
+
+		return struct{}{}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+// quantityDeltaError tells apart the item not in the cart from the one the delta would take below zero.
+func (r *cartRepository) quantityDeltaError(ctx context.Context, q *db.Queries, ownerID string, productID uuid.UUID, delta int32) error {
+	params := db.GetItemParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	row, err := q.GetItem(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("q.GetItem: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("q.GetItem: %w", err)
+	}
+
+	return &domain.NegativeQuantityError{ProductID: productID, Quantity: row.Quantity, Delta: delta}
+}
+
+func (r *cartRepository) SwapQuantities(ctx context.Context, ownerID string, productA, productB uuid.UUID) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productA); err != nil {
+		return err
+	}
+	if err := r.validateProductID(productB); err != nil {
+		return err
+	}
+
+	params := db.SwapQuantitiesParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+		ProductA: productA,
+		ProductB: productB,
+	}
+
+	// a single statement reading both quantities before either is updated, so nothing is updated
+	// unless both items are in the cart
+	rowsAffected, err := r.q.SwapQuantities(ctx, params)
+	if err != nil {
+		return fmt.Errorf("q.SwapQuantities: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("q.SwapQuantities: %w", domain.ErrItemNotFound)
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+func (r *cartRepository) DeleteItems(ctx context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	unique := make(map[uuid.UUID]bool, len(productIDs))
+	for i, productID := range productIDs {
+		if productID == uuid.Nil {
+			return 0, fmt.Errorf("productIDs[%d] is empty", i)
+		}
+		if err := r.validateProductID(productID); err != nil {
+			return 0, err
+		}
+		unique[productID] = true
+	}
+
+	if len(unique) == 0 {
+		return 0, nil
+	}
+
+	params := db.DeleteItemsParams{
+		DeletedAt:  r.now(),
+		OwnerID:    ownerID,
+		CartName:   r.opts.cartName,
+		ProductIds: slices.Collect(maps.Keys(unique)),
+	}
+
+	rowsAffected, err := r.q.DeleteItems(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.DeleteItems: %w", err)
+	}
+
+	if rowsAffected > 0 {
+		r.opts.observer.OnCartChanged(ownerID)
+	}
+	return rowsAffected, nil
+}
+
+func (r *cartRepository) RestoreItem(ctx context.Context, ownerID string, productID uuid.UUID) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productID); err != nil {
+		return err
+	}
+
+	params := db.RestoreItemParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	rowsAffected, err := r.q.RestoreItem(ctx, params)
+	if err != nil {
+		return fmt.Errorf("q.RestoreItem: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("q.RestoreItem: %w", domain.ErrItemNotFound)
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+func (r *cartRepository) UpdateItemPrice(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	if err := r.begin(); err != nil {
+		return false, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productID); err != nil {
+		return false, err
+	}
+	if err := validatePrice(newPrice); err != nil {
+		return false, err
+	}
+
+	params := db.UpdateItemPriceParams{
+		OwnerID:       ownerID,
+		CartName:      r.opts.cartName,
+		ProductID:     productID,
+		PriceAmount:   newPrice.Amount,
+		PriceCurrency: newPrice.Currency.String(),
+	}
+
+	rowsAffected, err := r.q.UpdateItemPrice(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("q.UpdateItemPrice: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, fmt.Errorf("q.UpdateItemPrice: %w", domain.ErrItemNotFound)
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return true, nil
+}
+
+// UpdateItemPriceIfVersion updates the price like UpdateItemPrice only while the stored item has the expected
+// version, returning the new version. It fails with domain.ErrVersionConflict when the item changed meanwhile.
+func (r *cartRepository) UpdateItemPriceIfVersion(ctx context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if err := r.validateProductID(productID); err != nil {
+		return 0, err
+	}
+	if err := validatePrice(newPrice); err != nil {
+		return 0, err
+	}
+
+	params := db.UpdateItemPriceIfVersionParams{
+		OwnerID:       ownerID,
+		CartName:      r.opts.cartName,
+		ProductID:     productID,
+		PriceAmount:   newPrice.Amount,
+		PriceCurrency: newPrice.Currency.String(),
+		Version:       expectedVersion,
+	}
+
+	version, err := r.q.UpdateItemPriceIfVersion(ctx, params)
+	if err == nil {
+		r.opts.observer.OnCartChanged(ownerID)
+		return version, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("q.UpdateItemPriceIfVersion: %w", err)
+	}
+
+	// nothing was updated, either the item is missing or its version differs
+	_, err = r.q.GetItemCreatedAt(ctx, db.GetItemCreatedAtParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("q.UpdateItemPriceIfVersion: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("q.GetItemCreatedAt: %w", err)
+	}
+
+	return 0, fmt.Errorf("q.UpdateItemPriceIfVersion: %w", domain.ErrVersionConflict)
+}
+
+func (r *cartRepository) GetItem(ctx context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.end()
+
+	if ownerID == "" {
+		return domain.CartItem{}, fmt.Errorf("ownerID is empty")
+	}
+	if err := r.validateProductID(productID); err != nil {
+		return domain.CartItem{}, err
+	}
+
+	params := db.GetItemParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	row, err := r.q.GetItem(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.CartItem{}, fmt.Errorf("q.GetItem: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("q.GetItem: %w", err)
+	}
+
+	item, err := r.mapGetCartRow(ctx, db.GetCartRow{
+		ProductID:     row.ProductID,
+		PriceAmount:   row.PriceAmount,
+		PriceCurrency: row.PriceCurrency,
+		Quantity:      row.Quantity,
+		CreatedAt:     row.CreatedAt,
+		Note:          row.Note,
+	})
+	if err != nil {
+		return domain.CartItem{}, fmt.Errorf("r.mapGetCartRow: %w", err)
+	}
+	item.Version = row.Version
+
+	return item, nil
+}
+
+func (r *cartRepository) GetItemsByCurrency(ctx context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if cur == (currency.Unit{}) {
+		return nil, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return nil, err
+	}
+
+	params := db.GetItemsByCurrencyParams{
+		OwnerID:       ownerID,
+		CartName:      r.opts.cartName,
+		PriceCurrency: cur.String(),
+	}
+
+	dbRows, err := r.q.GetItemsByCurrency(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetItemsByCurrency: %w", err)
+	}
+
+	items := make([]domain.CartItem, 0, len(dbRows))
+	for _, row := range dbRows {
+		item, err := r.mapGetCartRow(ctx, db.GetCartRow(row))
+		if err != nil {
+			return nil, fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// StreamCart scans the cart rows one at a time and passes each item to fn,
+// stopping at the first error returned by fn.
+func (r *cartRepository) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	rows, err := r.dbtx.Query(ctx, db.GetCart, ownerID, r.opts.cartName)
+	if err != nil {
+		return fmt.Errorf("dbtx.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var streamed bool
+	for rows.Next() {
+		streamed = true
+
+		// the generated row follows the GetCart columns, so the scan cannot drift from the query
+		row, err := pgx.RowToStructByPos[db.GetCartRow](rows)
+		if err != nil {
+			return fmt.Errorf("pgx.RowToStructByPos: %w", err)
+		}
+
+		item, err := r.mapGetCartRow(ctx, row)
+		if err != nil {
+			return fmt.Errorf("r.mapGetCartRow: %w", err)
+		}
+
+		if err := fn(item); err != nil {
+			return fmt.Errorf("fn: %w", err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", err)
+	}
+
+	if !streamed {
+		return r.checkCartKnown(ctx, ownerID)
+	}
+
+	return nil
+}
+
+func (r *cartRepository) ClearCurrency(ctx context.Context, ownerID string, cur currency.Unit) (int, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if cur == (currency.Unit{}) {
+		return 0, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return 0, err
+	}
+
+	params := db.DeleteItemsByCurrencyParams{
+		OwnerID:       ownerID,
+		CartName:      r.opts.cartName,
+		PriceCurrency: cur.String(),
+		DeletedAt:     r.now(),
+	}
+
+	rowsAffected, err := r.q.DeleteItemsByCurrency(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.DeleteItemsByCurrency: %w", err)
+	}
+
+	if rowsAffected > 0 {
+		r.opts.observer.OnCartChanged(ownerID)
+	}
+	return int(rowsAffected), nil
+}
+
+func (r *cartRepository) ApplyPriceFactor(ctx context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+	if cur == (currency.Unit{}) {
+		return 0, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return 0, err
+	}
+	if !factor.IsPositive() {
+		return 0, fmt.Errorf("factor[%s] is not positive", factor)
+	}
+
+	params := db.ApplyPriceFactorParams{
+		Factor:        factor,
+		OwnerID:       ownerID,
+		CartName:      r.opts.cartName,
+		PriceCurrency: cur.String(),
+	}
+
+	rowsAffected, err := r.q.ApplyPriceFactor(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.ApplyPriceFactor: %w", err)
+	}
+
+	if rowsAffected > 0 {
+		r.opts.observer.OnCartChanged(ownerID)
+	}
+	return rowsAffected, nil
+}
+
+func (r *cartRepository) CartExists(ctx context.Context, ownerID string) (bool, error) {
+	if err := r.begin(); err != nil {
+		return false, err
+	}
+	defer r.end()
+
+	if ownerID == "" {
+		return false, fmt.Errorf("ownerID is empty")
+	}
+
+	params := db.CartExistsParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	exists, err := r.q.CartExists(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("q.CartExists: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r *cartRepository) CountItems(ctx context.Context, ownerID string) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+
+	params := db.CountItemsParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	count, err := r.q.CountItems(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.CountItems: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *cartRepository) ClearCart(ctx context.Context, ownerID string) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+
+	params := db.ClearCartParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		DeletedAt: r.now(),
+	}
+
+	rowsAffected, err := r.q.ClearCart(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.ClearCart: %w", err)
+	}
+
+	if rowsAffected > 0 {
+		r.opts.observer.OnCartChanged(ownerID)
+	}
+	return rowsAffected, nil
+}
+
+func (r *cartRepository) MoveItems(ctx context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	if fromOwnerID == "" {
+		return 0, fmt.Errorf("fromOwnerID is empty")
+	}
+	if toOwnerID == "" {
+		return 0, fmt.Errorf("toOwnerID is empty")
+	}
+	if fromOwnerID == toOwnerID {
+		return 0, fmt.Errorf("fromOwnerID and toOwnerID are the same")
+	}
+
+	params := db.MoveItemsParams{
+		DeletedAt:   r.now(),
+		FromOwnerID: fromOwnerID,
+		CartName:    r.opts.cartName,
+		ToOwnerID:   toOwnerID,
+	}
+
+	// soft-deleting and inserting in a single statement moves either all items or none
+	moved, err := r.q.MoveItems(ctx, params)
+	if err != nil {
+		return 0, fmt.Errorf("q.MoveItems: %w", err)
+	}
+
+	if moved > 0 {
+		r.opts.observer.OnCartChanged(fromOwnerID)
+		r.opts.observer.OnCartChanged(toOwnerID)
+	}
+	return moved, nil
+}
+
+func (r *cartRepository) PurgeCartsOlderThan(ctx context.Context, cutoff time.Time) (int64, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	// a zero cutoff is rather a missing argument than a request to keep nothing
+	if cutoff.IsZero() {
+		return 0, fmt.Errorf("cutoff is zero")
+	}
+
+	params := db.PurgeCartsOlderThanParams{
+		CartName: r.opts.cartName,
+		// created_at is stored in UTC and pgx discards the location of a TIMESTAMP param
+		Cutoff: cutoff.UTC(),
+	}
+
+	// the transaction makes the purge retried on serialization failures and a savepoint in RunInTx
+	ownerIDs, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) ([]string, error) {
+		ownerIDs, err := q.PurgeCartsOlderThan(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("q.PurgeCartsOlderThan: %w", err)
+		}
+
+		return ownerIDs, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// a row is returned per purged item
+	purged := make(map[string]bool)
+	for _, ownerID := range ownerIDs {
+		if !purged[ownerID] {
+			purged[ownerID] = true
+			r.opts.observer.OnCartChanged(ownerID)
+		}
+	}
+
+	return int64(len(ownerIDs)), nil
+}
+
+func (r *cartRepository) CartCurrencyReport(ctx context.Context, ownerID string) (domain.CurrencyReport, error) {
+	if err := r.begin(); err != nil {
+		return domain.CurrencyReport{}, err
+	}
+	defer r.end()
+
+	params := db.GetCartCurrenciesParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	codes, err := r.q.GetCartCurrencies(ctx, params)
+	if err != nil {
+		return domain.CurrencyReport{}, fmt.Errorf("q.GetCartCurrencies: %w", err)
+	}
+
+	report := domain.CurrencyReport{
+		Currencies:     make([]currency.Unit, 0, len(codes)),
+		SingleCurrency: len(codes) <= 1,
+	}
+
+	for _, code := range codes {
+		cur, err := domain.ParseCurrency(code)
+		if err != nil {
+			return domain.CurrencyReport{}, err
+		}
+		report.Currencies = append(report.Currencies, cur)
+	}
+
+	return report, nil
+}
+
+func (r *cartRepository) CartCurrency(ctx context.Context, ownerID string) (currency.Unit, error) {
+	if err := r.begin(); err != nil {
+		return currency.Unit{}, err
+	}
+	defer r.end()
+
+	params := db.GetCartCurrencyParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	// at most two currencies are read, it is enough to tell a single-currency cart from a mixed one
+	codes, err := r.q.GetCartCurrency(ctx, params)
+	if err != nil {
+		return currency.Unit{}, fmt.Errorf("q.GetCartCurrency: %w", err)
+	}
+
+	switch {
+	case len(codes) == 0:
+		return currency.Unit{}, domain.ErrEmptyCart
+	case len(codes) > 1:
+		return currency.Unit{}, domain.ErrMixedCurrency
+	}
+
+	cur, err := domain.ParseCurrency(codes[0])
+	if err != nil {
+		return currency.Unit{}, err
+	}
+
+	return cur, nil
+}
+
+func (r *cartRepository) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if exists == nil {
+		return nil, fmt.Errorf("exists is nil")
+	}
+
+	params := db.GetProductIDsParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	productIDs, err := r.q.GetProductIDs(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetProductIDs: %w", err)
+	}
+
+	missing := make([]uuid.UUID, 0)
+	if len(productIDs) == 0 {
+		return missing, nil
+	}
+
+	existing, err := exists(productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("exists: %w", err)
+	}
+
+	for _, productID := range productIDs {
+		if !existing[productID] {
+			missing = append(missing, productID)
+		}
+	}
+
+	return missing, nil
+}
+
+func (r *cartRepository) ItemAddedAt(ctx context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	if err := r.begin(); err != nil {
+		return time.Time{}, err
+	}
+	defer r.end()
+
+	params := db.GetItemCreatedAtParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	createdAt, err := r.q.GetItemCreatedAt(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, fmt.Errorf("q.GetItemCreatedAt: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("q.GetItemCreatedAt: %w", err)
+	}
+
+	return createdAt, nil
+}
+
+func (r *cartRepository) ItemRecencyRank(ctx context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	if err := r.begin(); err != nil {
+		return 0, err
+	}
+	defer r.end()
+
+	params := db.GetItemRecencyRankParams{
+		OwnerID:   ownerID,
+		CartName:  r.opts.cartName,
+		ProductID: productID,
+	}
+
+	rank, err := r.q.GetItemRecencyRank(ctx, params)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("q.GetItemRecencyRank: %w", domain.ErrItemNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("q.GetItemRecencyRank: %w", err)
+	}
+
+	return int(rank), nil
+}
+
+func (r *cartRepository) ProductWeightedAvgPrice(ctx context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	if err := r.begin(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.end()
+
+	if productID == uuid.Nil {
+		return domain.Money{}, fmt.Errorf("productID is empty")
+	}
+	if cur == (currency.Unit{}) {
+		return domain.Money{}, fmt.Errorf("currency is empty")
+	}
+
+	params := db.GetProductWeightedAvgPriceParams{
+		ProductID:     productID,
+		PriceCurrency: cur.String(),
+	}
+
+	row, err := r.q.GetProductWeightedAvgPrice(ctx, params)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("q.GetProductWeightedAvgPrice: %w", err)
+	}
+
+	if row.LineCount == 0 {
+		return domain.Money{}, fmt.Errorf("q.GetProductWeightedAvgPrice: %w", domain.ErrItemNotFound)
+	}
+
+	return domain.Money{
+		Amount:   row.AvgPrice,
+		Currency: cur,
+	}, nil
+}
+
+func (r *cartRepository) DemandByProduct(ctx context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	demand := make(map[uuid.UUID]int64, len(productIDs))
+	if len(productIDs) == 0 {
+		return demand, nil
+	}
+
+	for _, productID := range productIDs {
+		demand[productID] = 0
+	}
+
+	rows, err := r.q.GetDemandByProduct(ctx, productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetDemandByProduct: %w", err)
+	}
+
+	for _, row := range rows {
+		demand[row.ProductID] = row.Demand
+	}
+
+	return demand, nil
+}
+
+func (r *cartRepository) TopProducts(ctx context.Context, limit int32) ([]domain.ProductCount, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	rows, err := r.q.GetTopProducts(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetTopProducts: %w", err)
+	}
+
+	counts := make([]domain.ProductCount, 0, len(rows))
+	for _, row := range rows {
+		counts = append(counts, domain.ProductCount{ProductID: row.ProductID, CartCount: row.CartCount})
+	}
+
+	return counts, nil
+}
+
+func (r *cartRepository) DistinctCurrencies(ctx context.Context) ([]currency.Unit, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	codes, err := r.q.GetDistinctCurrencies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetDistinctCurrencies: %w", err)
+	}
+
+	currencies := make([]currency.Unit, 0, len(codes))
+	for _, code := range codes {
+		cur, err := domain.ParseCurrency(code)
+		if err != nil {
+			return nil, err
+		}
+		currencies = append(currencies, cur)
+	}
+
+	return currencies, nil
+}
+
+func (r *cartRepository) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	if err := r.begin(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.end()
+
+	// excluding nothing sums the whole cart, grouped by currency in the query
+	return r.TotalExcluding(ctx, ownerID, nil)
+}
+
+func (r *cartRepository) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	if err := r.begin(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.end()
+
+	if target == (currency.Unit{}) {
+		return domain.Money{}, fmt.Errorf("currency is empty")
+	}
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("r.getCart: %w", err)
+	}
+
+	total, err := cart.TotalIn(target, rates)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("cart.TotalIn: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *cartRepository) TotalExcluding(ctx context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	if err := r.begin(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.end()
+
+	// a nil slice is sent as NULL, which would make product_id != ALL(...) exclude every product
+	if excluded == nil {
+		excluded = []uuid.UUID{}
+	}
+
+	params := db.GetTotalExcludingParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+		Excluded: excluded,
+	}
+
+	rows, err := r.q.GetTotalExcluding(ctx, params)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("q.GetTotalExcluding: %w", err)
+	}
+
+	// the excluded items are filtered out before grouping, so only the remaining ones have to share a currency
+	switch {
+	case len(rows) == 0:
+		return domain.Money{Amount: decimal.Zero}, nil
+	case len(rows) > 1:
+		return domain.Money{}, domain.ErrMixedCurrency
+	}
+
+	return domain.NewMoney(rows[0].Total, rows[0].PriceCurrency)
+}
+
+func (r *cartRepository) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	if err := r.begin(); err != nil {
+		return domain.SyncPlan{}, err
+	}
+	defer r.end()
+
+	for i, item := range desired {
+		if item.Price.Currency == (currency.Unit{}) {
+			return domain.SyncPlan{}, fmt.Errorf("desired[%d] currency is empty", i)
+		}
+	}
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.SyncPlan{}, fmt.Errorf("r.getCart: %w", err)
+	}
+
+	plan, err := domain.PlanSync(cart.Items, desired)
+	if err != nil {
+		return domain.SyncPlan{}, fmt.Errorf("domain.PlanSync: %w", err)
+	}
+
+	return plan, nil
+}
+
+// ApplySync upserts the added and updated items and deletes the others in a single transaction.
+func (r *cartRepository) ApplySync(ctx context.Context, ownerID string, plan domain.SyncPlan) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	upserts := slices.Concat(plan.Adds, plan.Updates)
+	for _, item := range upserts {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+		if err := r.validateProductID(item.ProductID); err != nil {
+			return err
+		}
+	}
+	for _, productID := range plan.Deletes {
+		if err := r.validateProductID(productID); err != nil {
+			return err
+		}
+	}
+
+	if plan.IsEmpty() {
+		return nil
+	}
+
+	_, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (struct{}, error) {
+		// the desired quantities replace the stored ones instead of being added to them
+		for _, item := range upserts {
+			params := db.SetItemParams(mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now()))
+			if err := q.SetItem(ctx, params); err != nil {
+				return struct{}{}, fmt.Errorf("q.SetItem: %w", err)
+			}
+		}
+
+		for _, productID := range plan.Deletes {
+			params := db.DeleteItemParams{
+				OwnerID:   ownerID,
+				CartName:  r.opts.cartName,
+				ProductID: productID,
+				DeletedAt: r.now(),
+			}
+
+			if _, err := q.DeleteItem(ctx, params); err != nil {
+				return struct{}{}, fmt.Errorf("q.DeleteItem: %w", err)
+			}
+		}
+
+		return struct{}{}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+func (r *cartRepository) ReplaceCart(ctx context.Context, ownerID string, items []domain.CartItem) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	seen := make(map[uuid.UUID]bool, len(items))
+	for _, item := range items {
+		if seen[item.ProductID] {
+			return fmt.Errorf("product[%s] is duplicated", item.ProductID)
+		}
+		seen[item.ProductID] = true
+
+		if err := validateItem(item); err != nil {
+			return err
+		}
+		if err := r.validateProductID(item.ProductID); err != nil {
+			return err
+		}
+	}
+
+	_, err := withTxRetry(ctx, r.dbtx, r.opts.txOptions, r.opts.txRetry, func(q *db.Queries) (struct{}, error) {
+		params := db.ClearCartParams{
+			OwnerID:   ownerID,
+			CartName:  r.opts.cartName,
+			DeletedAt: r.now(),
+		}
+
+		if _, err := q.ClearCart(ctx, params); err != nil {
+			return struct{}{}, fmt.Errorf("q.ClearCart: %w", err)
+		}
+
+		for _, item := range items {
+			if err := q.AddItem(ctx, mapDomainCartItemToAddItemParams(ownerID, r.opts.cartName, item, r.now())); err != nil {
+				return struct{}{}, fmt.Errorf("q.AddItem: %w", err)
+			}
+		}
+
+		return struct{}{}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.opts.observer.OnCartChanged(ownerID)
+	return nil
+}
+
+// ListCartNames lists the names of the owner's carts holding at least one item, sorted.
+func (r *cartRepository) ListCartNames(ctx context.Context, ownerID string) ([]string, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	names, err := r.q.GetCartNames(ctx, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("q.GetCartNames: %w", err)
+	}
+
+	if names == nil {
+		names = make([]string, 0)
+	}
+
+	return names, nil
+}
+
+func (r *cartRepository) ListOwners(ctx context.Context, limit, offset int32) ([]string, error) {
+	if err := r.begin(); err != nil {
+		return nil, err
+	}
+	defer r.end()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("offset[%d] is negative", offset)
+	}
+
+	params := db.ListOwnersParams{
+		CartName: r.opts.cartName,
+		Limit:    limit,
+		Offset:   offset,
+	}
+
+	owners, err := r.q.ListOwners(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("q.ListOwners: %w", err)
+	}
+
+	if owners == nil {
+		owners = make([]string, 0)
+	}
+
+	return owners, nil
+}
+
+func (r *cartRepository) SetOwnerCurrency(ctx context.Context, ownerID string, cur currency.Unit) error {
+	if err := r.begin(); err != nil {
+		return err
+	}
+	defer r.end()
+
+	if cur == (currency.Unit{}) {
+		return fmt.Errorf("currency is empty")
+	}
+
+	params := db.SetOwnerCurrencyParams{
+		OwnerID:         ownerID,
+		DefaultCurrency: cur.String(),
+		UpdatedAt:       r.now(),
+	}
+
+	err := r.q.SetOwnerCurrency(ctx, params)
+	if err != nil {
+		return fmt.Errorf("q.SetOwnerCurrency: %w", err)
+	}
+
+	return nil
+}
+
+func (r *cartRepository) GetOwnerCurrency(ctx context.Context, ownerID string) (currency.Unit, bool, error) {
+	if err := r.begin(); err != nil {
+		return currency.Unit{}, false, err
+	}
+	defer r.end()
+
+	code, err := r.q.GetOwnerCurrency(ctx, ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return currency.Unit{}, false, nil
+	}
+	if err != nil {
+		return currency.Unit{}, false, fmt.Errorf("q.GetOwnerCurrency: %w", err)
+	}
+
+	cur, err := domain.ParseCurrency(code)
+	if err != nil {
+		return currency.Unit{}, false, err
+	}
+
+	return cur, true, nil
+}
+
+// checkCartKnown tells a cart whose items were all deleted, which is empty, from a cart the owner never
+// added an item to, which is reported with domain.ErrCartNotFound.
+func (r *cartRepository) checkCartKnown(ctx context.Context, ownerID string) error {
+	params := db.CartKnownParams{
+		OwnerID:  ownerID,
+		CartName: r.opts.cartName,
+	}
+
+	known, err := r.q.CartKnown(ctx, params)
+	if err != nil {
+		return fmt.Errorf("q.CartKnown: %w", err)
+	}
+	if !known {
+		return fmt.Errorf("q.CartKnown: %w", domain.ErrCartNotFound)
+	}
+
+	return nil
+}
+
+func (r *cartRepository) validateProductID(productID uuid.UUID) error {
+	if r.opts.requireV4ProductIDs && productID.Version() != 4 {
+		return fmt.Errorf("productID[%s] is not a v4 UUID", productID)
+	}
+
+	return nil
+}
+
+func compareProductIDs(a, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func validateItem(item domain.CartItem) error {
+	if err := item.Validate(); err != nil {
+		return fmt.Errorf("product[%s] is not valid: %w", item.ProductID, err)
+	}
+
+	return nil
+}
+
+func validatePrice(price domain.Money) error {
+	if price.Currency == (currency.Unit{}) {
+		return fmt.Errorf("currency is empty")
+	}
+	if price.Amount.LessThanOrEqual(decimal.Zero) {
+		return fmt.Errorf("price amount[%s] is not positive", price.Amount)
+	}
+	if _, err := domain.ParseCurrency(price.Currency.String()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// mapGetCartRow falls back to the configured currency when the stored one is not valid.
+func (r *cartRepository) mapGetCartRow(ctx context.Context, row db.GetCartRow) (domain.CartItem, error) {
+	item, err := mapGetCartRowToDomainCartItem(row)
+	if err == nil || r.opts.fallbackCurrency == (currency.Unit{}) {
+		return item, err
+	}
+
+	r.opts.logger.WarnContext(ctx, "cart item currency is not valid, using fallback currency",
+		"productID", row.ProductID,
+		"currency", row.PriceCurrency,
+		"fallbackCurrency", r.opts.fallbackCurrency.String(),
+	)
+
+	row.PriceCurrency = r.opts.fallbackCurrency.String()
+	return mapGetCartRowToDomainCartItem(row)
+}
+
+// mapDomainCartItemToAddItemParams ignores item.CreatedAt, a new item is created at createdAt.
+func mapDomainCartItemToAddItemParams(ownerID, cartName string, item domain.CartItem, createdAt time.Time) db.AddItemParams {
+	return db.AddItemParams{
+		OwnerID:       ownerID,
+		CartName:      cartName,
+		ProductID:     item.ProductID,
+		PriceAmount:   item.Price.Amount,
+		PriceCurrency: item.Price.Currency.String(),
+		Quantity:      item.Quantity,
+		Note:          mapDomainNote(item.Note),
+		CreatedAt:     createdAt,
+	}
+}
+
+// mapDomainNote stores an empty note as NULL, so it reads back as nil.
+func mapDomainNote(note *string) *string {
+	if note == nil || *note == "" {
+		return nil
+	}
+	return note
 }
 
 func mapGetCartRowToDomainCartItem(row db.GetCartRow) (domain.CartItem, error) {
-	parsedCurrency, err := currency.ParseISO(row.PriceCurrency)
+	price, err := domain.NewMoney(row.PriceAmount, row.PriceCurrency)
 	if err != nil {
-		return domain.CartItem{}, fmt.Errorf("currency[%s] is not valid: %w", row.PriceCurrency, err)
+		return domain.CartItem{}, err
 	}
 
 	return domain.CartItem{
 		ProductID: row.ProductID,
-		Price: domain.Money{
-			Amount:   row.PriceAmount,
-			Currency: parsedCurrency,
-		},
+		Price:     price,
+		Quantity:  row.Quantity,
+		Note:      row.Note,
 		CreatedAt: row.CreatedAt,
 	}, nil
 }
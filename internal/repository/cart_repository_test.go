@@ -1,20 +1,36 @@
 package repository_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v7"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
 	"github.com/nikolayk812/sqlcpp-demo/internal/port"
 	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository/memory"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"golang.org/x/text/currency"
 )
 
@@ -54,6 +70,22 @@ func (suite *cartRepositorySuite) TearDownSuite() {
 func (suite *cartRepositorySuite) TestAddItem() {
 	defer suite.deleteAll()
 
+	zeroQuantity := randomCartItem()
+	zeroQuantity.Quantity = 0
+
+	negativePrice := randomCartItem()
+	negativePrice.Price.Amount = decimal.NewFromFloat(-1.5)
+
+	zeroPrice := randomCartItem()
+	zeroPrice.Price.Amount = decimal.Zero
+
+	nilProductID := randomCartItem()
+	nilProductID.ProductID = uuid.Nil
+
+	// the zero value of currency.Unit is ISO XXX, it falls back to the owner's default currency
+	noCurrency := randomCartItem()
+	noCurrency.Price.Currency = currency.XXX
+
 	tests := []struct {
 		name      string
 		ownerID   string
@@ -75,6 +107,36 @@ func (suite *cartRepositorySuite) TestAddItem() {
 			ownerID: gofakeit.UUID(),
 			item:    randomCartItem(),
 		},
+		{
+			name:      "add item with zero quantity: error",
+			ownerID:   gofakeit.UUID(),
+			item:      zeroQuantity,
+			wantError: "product[" + zeroQuantity.ProductID.String() + "] is not valid: quantity[0] is not positive",
+		},
+		{
+			name:      "add item with nil product ID: error",
+			ownerID:   gofakeit.UUID(),
+			item:      nilProductID,
+			wantError: "product[" + uuid.Nil.String() + "] is not valid: productID is empty",
+		},
+		{
+			name:      "add item with negative price: error",
+			ownerID:   gofakeit.UUID(),
+			item:      negativePrice,
+			wantError: "product[" + negativePrice.ProductID.String() + "] is not valid: price amount[-1.5] is not positive",
+		},
+		{
+			name:      "add item with zero price: error",
+			ownerID:   gofakeit.UUID(),
+			item:      zeroPrice,
+			wantError: "product[" + zeroPrice.ProductID.String() + "] is not valid: price amount[0] is not positive",
+		},
+		{
+			name:      "add item with XXX currency and no owner default: error",
+			ownerID:   gofakeit.UUID(),
+			item:      noCurrency,
+			wantError: "item currency is empty and owner has no default currency",
+		},
 	}
 
 	for _, tt := range tests {
@@ -111,167 +173,4618 @@ func (suite *cartRepositorySuite) TestAddItem() {
 		err := suite.repo.AddItem(ctx, ownerID, item1)
 		require.NoError(t, err)
 
-		// Add same item with different price (should update)
+		// Add same item with different price and quantity (should update the price and sum the quantities)
 		item2 := item1
 		item2.Price = domain.Money{
 			Amount:   decimal.NewFromFloat(99.99),
 			Currency: item1.Price.Currency,
 		}
+		item2.Quantity = 2
 
 		err = suite.repo.AddItem(ctx, ownerID, item2)
 		require.NoError(t, err)
 
-		// Verify only one item exists with updated price
+		// Verify only one item exists with updated price and accumulated quantity
 		cart, err := suite.repo.GetCart(ctx, ownerID)
 		require.NoError(t, err)
 
 		require.Equal(t, 1, len(cart.Items))
 		assert.Equal(t, item2.Price.Amount, cart.Items[0].Price.Amount)
+		assert.Equal(t, int32(3), cart.Items[0].Quantity)
 	})
 }
 
-func (suite *cartRepositorySuite) TestGetCart() {
+func (suite *cartRepositorySuite) TestAddItemReturning() {
 	defer suite.deleteAll()
 
-	tests := []struct {
-		name      string
-		ownerID   string
-		setup     func(string) error
-		wantError string
-		wantItems int
-	}{
-		{
-			name:      "get empty cart: ok",
-			ownerID:   gofakeit.UUID(),
-			wantItems: 0,
-		},
-		{
-			name:    "get cart with one item: ok",
-			ownerID: gofakeit.UUID(),
-			setup: func(ownerID string) error {
-				return suite.repo.AddItem(suite.T().Context(), ownerID, randomCartItem())
-			},
-			wantItems: 1,
-		},
-		{
-			name:    "get cart with multiple items: ok",
-			ownerID: gofakeit.UUID(),
-			setup: func(ownerID string) error {
-				ctx := suite.T().Context()
-				for i := 0; i < 3; i++ {
-					err := suite.repo.AddItem(ctx, ownerID, randomCartItem())
-					if err != nil {
-						return err
-					}
-				}
-				return nil
-			},
-			wantItems: 3,
-		},
-	}
+	suite.Run("fresh item: stored item returned", func() {
+		t := suite.T()
+		ctx := t.Context()
 
-	for _, tt := range tests {
-		suite.Run(tt.name, func() {
-			t := suite.T()
-			ctx := t.Context()
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
 
-			if tt.setup != nil {
-				err := tt.setup(tt.ownerID)
-				require.NoError(t, err)
-			}
+		stored, err := suite.repo.AddItemReturning(ctx, ownerID, item)
+		require.NoError(t, err)
+		assertCartItem(t, item, stored)
+		assert.False(t, stored.CreatedAt.IsZero())
 
-			cart, err := suite.repo.GetCart(ctx, tt.ownerID)
-			if tt.wantError != "" {
-				require.EqualError(t, err, tt.wantError)
-				return
-			}
-			require.NoError(t, err)
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, got.CreatedAt, stored.CreatedAt)
+	})
 
-			require.Equal(t, tt.ownerID, cart.OwnerID)
-			require.Equal(t, tt.wantItems, len(cart.Items))
+	suite.Run("existing item: summed quantity and first CreatedAt returned", func() {
+		t := suite.T()
+		ctx := t.Context()
 
-			// Verify items have valid fields
-			for _, item := range cart.Items {
-				assert.NotEqual(t, uuid.Nil, item.ProductID)
-				assert.True(t, item.Price.Amount.GreaterThan(decimal.Zero))
-				assert.NotEmpty(t, item.Price.Currency.String())
-				assert.False(t, item.CreatedAt.IsZero())
-			}
-		})
-	}
+		ownerID := gofakeit.UUID()
+		item1 := randomCartItem()
+		first, err := suite.repo.AddItemReturning(ctx, ownerID, item1)
+		require.NoError(t, err)
+
+		item2 := item1
+		item2.Price = domain.Money{
+			Amount:   decimal.NewFromFloat(99.99),
+			Currency: currency.EUR,
+		}
+
+		stored, err := suite.repo.AddItemReturning(ctx, ownerID, item2)
+		require.NoError(t, err)
+
+		want := item2
+		want.Quantity = item1.Quantity + item2.Quantity
+		assertCartItem(t, want, stored)
+		assert.Equal(t, first.CreatedAt, stored.CreatedAt)
+	})
+
+	suite.Run("empty currency: owner default returned", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, suite.repo.SetOwnerCurrency(ctx, ownerID, currency.JPY))
+
+		item := randomCartItem()
+		item.Price.Currency = currency.Unit{}
+
+		stored, err := suite.repo.AddItemReturning(ctx, ownerID, item)
+		require.NoError(t, err)
+
+		item.Price.Currency = currency.JPY
+		assertCartItem(t, item, stored)
+	})
+
+	suite.Run("invalid item: error", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Quantity = 0
+
+		_, err := suite.repo.AddItemReturning(t.Context(), gofakeit.UUID(), item)
+		require.EqualError(t, err, "product["+item.ProductID.String()+"] is not valid: quantity[0] is not positive")
+	})
 }
 
-func (suite *cartRepositorySuite) TestDeleteItem() {
+func (suite *cartRepositorySuite) TestAddItemReturningPrevious() {
 	defer suite.deleteAll()
 
-	tests := []struct {
-		name      string
-		ownerID   string
-		productID uuid.UUID
-		setup     func(string, uuid.UUID) error
-		want      bool
-		wantError string
-	}{
-		{
-			name:      "delete existing item: ok",
-			ownerID:   gofakeit.UUID(),
-			productID: uuid.MustParse(gofakeit.UUID()),
-			setup: func(ownerID string, productID uuid.UUID) error {
-				item := randomCartItem()
-				item.ProductID = productID
-				return suite.repo.AddItem(suite.T().Context(), ownerID, item)
-			},
-			want: true,
-		},
-		{
-			name:      "delete non-existing item: not found",
-			ownerID:   gofakeit.UUID(),
-			productID: uuid.MustParse(gofakeit.UUID()),
-			want:      false,
-		},
-		{
-			name:      "delete with empty owner ID: not found",
-			ownerID:   "",
-			productID: uuid.MustParse(gofakeit.UUID()),
-			want:      false,
-		},
-	}
+	suite.Run("fresh item: nil previous", func() {
+		t := suite.T()
+		ctx := t.Context()
 
-	for _, tt := range tests {
-		suite.Run(tt.name, func() {
-			t := suite.T()
-			ctx := t.Context()
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
 
-			if tt.setup != nil {
-				err := tt.setup(tt.ownerID, tt.productID)
-				require.NoError(t, err)
-			}
+		previous, err := suite.repo.AddItemReturningPrevious(ctx, ownerID, item)
+		require.NoError(t, err)
+		assert.Nil(t, previous)
 
-			deleted, err := suite.repo.DeleteItem(ctx, tt.ownerID, tt.productID)
-			if tt.wantError != "" {
-				require.EqualError(t, err, tt.wantError)
-				return
-			}
-			require.NoError(t, err)
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, item, cart.Items[0])
+	})
 
-			require.Equal(t, tt.want, deleted)
+	suite.Run("existing item: previous returned", func() {
+		t := suite.T()
+		ctx := t.Context()
 
-			// If item was deleted, verify it's no longer in cart
-			if deleted {
-				cart, err := suite.repo.GetCart(ctx, tt.ownerID)
-				require.NoError(t, err)
+		ownerID := gofakeit.UUID()
+		item1 := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item1))
 
-				for _, item := range cart.Items {
-					assert.NotEqual(t, tt.productID, item.ProductID, "deleted item should not be in cart")
-				}
-			}
-		})
-	}
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		stored := cart.Items[0]
+
+		item2 := item1
+		item2.Price = domain.Money{
+			Amount:   decimal.NewFromFloat(99.99),
+			Currency: currency.EUR,
+		}
+
+		previous, err := suite.repo.AddItemReturningPrevious(ctx, ownerID, item2)
+		require.NoError(t, err)
+		require.NotNil(t, previous)
+		assertCartItem(t, item1, *previous)
+		assert.Equal(t, stored.CreatedAt, previous.CreatedAt)
+
+		want := item2
+		want.Quantity = item1.Quantity + item2.Quantity
+
+		cart, err = suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, want, cart.Items[0])
+	})
+
+	suite.Run("existing item with note: previous note returned and replaced", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item1 := randomCartItem()
+		note1 := "gift wrap"
+		item1.Note = &note1
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item1))
+
+		item2 := item1
+		note2 := "deliver on Monday"
+		item2.Note = &note2
+
+		previous, err := suite.repo.AddItemReturningPrevious(ctx, ownerID, item2)
+		require.NoError(t, err)
+		require.NotNil(t, previous)
+		require.NotNil(t, previous.Note)
+		assert.Equal(t, note1, *previous.Note)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item1.ProductID)
+		require.NoError(t, err)
+		require.NotNil(t, got.Note)
+		assert.Equal(t, note2, *got.Note)
+		assert.Equal(t, item1.Quantity+item2.Quantity, got.Quantity)
+	})
+
+	suite.Run("empty currency without owner default: error", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		item := randomCartItem()
+		item.Price.Currency = currency.Unit{}
+
+		_, err := suite.repo.AddItemReturningPrevious(ctx, gofakeit.UUID(), item)
+		require.EqualError(t, err, "item currency is empty and owner has no default currency")
+	})
 }
 
-func (suite *cartRepositorySuite) deleteAll() {
-	_, err := suite.pool.Exec(suite.T().Context(), "TRUNCATE TABLE cart_items CASCADE")
-	suite.NoError(err)
+func (suite *cartRepositorySuite) TestAddItemIdempotent() {
+	defer suite.deleteAll()
+
+	suite.Run("repeated key: added once", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID, otherOwnerID := gofakeit.UUID(), gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+
+		require.NoError(t, suite.repo.AddItemIdempotent(ctx, ownerID, item, "retried"))
+		require.NoError(t, suite.repo.AddItemIdempotent(ctx, ownerID, item, "retried"))
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), stored.Quantity)
+
+		// a new key adds the item again
+		require.NoError(t, suite.repo.AddItemIdempotent(ctx, ownerID, item, "another"))
+
+		stored, err = suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), stored.Quantity)
+
+		// keys are scoped per owner
+		require.NoError(t, suite.repo.AddItemIdempotent(ctx, otherOwnerID, item, "retried"))
+
+		stored, err = suite.repo.GetItem(ctx, otherOwnerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), stored.Quantity)
+	})
+
+	suite.Run("empty key: error", func() {
+		err := suite.repo.AddItemIdempotent(suite.T().Context(), gofakeit.UUID(), randomCartItem(), "")
+		suite.Require().EqualError(err, "idempotencyKey is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestSetItem() {
+	defer suite.deleteAll()
+
+	suite.Run("add twice: quantities summed", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), got.Quantity)
+	})
+
+	suite.Run("set twice: last state kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+		require.NoError(t, suite.repo.SetItem(ctx, ownerID, item))
+
+		first, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		updated := item
+		updated.Price.Amount = item.Price.Amount.Add(decimal.NewFromInt(1))
+		updated.Quantity = 3
+		require.NoError(t, suite.repo.SetItem(ctx, ownerID, updated))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, updated, got)
+		assert.Equal(t, first.CreatedAt, got.CreatedAt)
+	})
+
+	suite.Run("invalid item: error", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Quantity = 0
+
+		err := suite.repo.SetItem(t.Context(), gofakeit.UUID(), item)
+		require.EqualError(t, err, fmt.Sprintf("product[%s] is not valid: quantity[0] is not positive", item.ProductID))
+	})
+}
+
+func (suite *cartRepositorySuite) TestImportItem() {
+	defer suite.deleteAll()
+
+	createdAt := time.Date(2021, 6, 7, 8, 9, 10, 123456000, time.UTC)
+
+	suite.Run("explicit CreatedAt: kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.CreatedAt = createdAt
+		require.NoError(t, suite.repo.ImportItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+		assert.Equal(t, createdAt, got.CreatedAt)
+	})
+
+	suite.Run("explicit CreatedAt in other zone: kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.CreatedAt = createdAt.In(time.FixedZone("UTC+3", 3*60*60))
+		require.NoError(t, suite.repo.ImportItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, createdAt, got.CreatedAt)
+	})
+
+	suite.Run("item in cart: replaced with CreatedAt", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		imported := item
+		imported.Quantity = item.Quantity + 1
+		imported.CreatedAt = createdAt
+		require.NoError(t, suite.repo.ImportItem(ctx, ownerID, imported))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, imported, got)
+		assert.Equal(t, createdAt, got.CreatedAt)
+	})
+
+	suite.Run("zero CreatedAt: creation time kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		first, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		require.NoError(t, suite.repo.ImportItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, first.CreatedAt, got.CreatedAt)
+	})
+
+	suite.Run("invalid item: error", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Quantity = 0
+		item.CreatedAt = createdAt
+
+		err := suite.repo.ImportItem(t.Context(), gofakeit.UUID(), item)
+		require.EqualError(t, err, fmt.Sprintf("product[%s] is not valid: quantity[0] is not positive", item.ProductID))
+	})
+}
+
+func (suite *cartRepositorySuite) TestAddItemsBestEffort() {
+	defer suite.deleteAll()
+
+	suite.Run("some items fail: others added", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+
+		noCurrency := randomCartItem()
+		noCurrency.Price.Currency = currency.Unit{}
+		zeroPrice := randomCartItem()
+		zeroPrice.Price.Amount = decimal.Zero
+		items := []domain.CartItem{randomCartItem(), noCurrency, randomCartItem(), zeroPrice}
+
+		results, err := suite.repo.AddItemsBestEffort(ctx, ownerID, items)
+		require.NoError(t, err)
+		require.Len(t, results, len(items))
+
+		for i, result := range results {
+			assert.Equal(t, items[i].ProductID, result.ProductID)
+		}
+		assert.NoError(t, results[0].Err)
+		assert.EqualError(t, results[1].Err, "item currency is empty and owner has no default currency")
+		assert.NoError(t, results[2].Err)
+		assert.EqualError(t, results[3].Err, "product["+zeroPrice.ProductID.String()+"] is not valid: price amount[0] is not positive")
+
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 2)
+	})
+
+	suite.Run("canceled context: error", func() {
+		t := suite.T()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		results, err := suite.repo.AddItemsBestEffort(ctx, gofakeit.UUID(), []domain.CartItem{randomCartItem()})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, results)
+	})
+}
+
+func (suite *cartRepositorySuite) TestV4ProductIDs() {
+	defer suite.deleteAll()
+
+	v4Repo, err := repository.NewCart(suite.pool, repository.WithV4ProductIDs())
+	suite.Require().NoError(err)
+
+	v7ID := uuid.Must(uuid.NewV7())
+
+	tests := []struct {
+		name      string
+		repo      port.CartRepository
+		productID uuid.UUID
+		wantError string
+	}{
+		{
+			name:      "v4 product id: ok",
+			repo:      v4Repo,
+			productID: uuid.New(),
+		},
+		{
+			name:      "v7 product id: error",
+			repo:      v4Repo,
+			productID: v7ID,
+			wantError: "productID[" + v7ID.String() + "] is not a v4 UUID",
+		},
+		{
+			name:      "v7 product id without option: ok",
+			repo:      suite.repo,
+			productID: uuid.Must(uuid.NewV7()),
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			item := randomCartItem()
+			item.ProductID = tt.productID
+
+			err := tt.repo.AddItem(ctx, ownerID, item)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+
+				_, err = tt.repo.DeleteItem(ctx, ownerID, tt.productID)
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			deleted, err := tt.repo.DeleteItem(ctx, ownerID, tt.productID)
+			require.NoError(t, err)
+			assert.True(t, deleted)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetCart() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		ownerID    string
+		setup      func(string) error
+		wantTarget error
+		wantError  string
+		wantItems  int
+	}{
+		{
+			name:       "get never used cart: not found",
+			ownerID:    gofakeit.UUID(),
+			wantTarget: domain.ErrCartNotFound,
+		},
+		{
+			name:    "get cart with all items deleted: empty",
+			ownerID: gofakeit.UUID(),
+			setup: func(ownerID string) error {
+				ctx := suite.T().Context()
+				item := randomCartItem()
+				if err := suite.repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				_, err := suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+				return err
+			},
+			wantItems: 0,
+		},
+		{
+			name:    "get cart with one item: ok",
+			ownerID: gofakeit.UUID(),
+			setup: func(ownerID string) error {
+				return suite.repo.AddItem(suite.T().Context(), ownerID, randomCartItem())
+			},
+			wantItems: 1,
+		},
+		{
+			name:    "get cart with multiple items: ok",
+			ownerID: gofakeit.UUID(),
+			setup: func(ownerID string) error {
+				ctx := suite.T().Context()
+				for i := 0; i < 3; i++ {
+					err := suite.repo.AddItem(ctx, ownerID, randomCartItem())
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			wantItems: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			if tt.setup != nil {
+				err := tt.setup(tt.ownerID)
+				require.NoError(t, err)
+			}
+
+			cart, err := suite.repo.GetCart(ctx, tt.ownerID)
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, tt.ownerID, cart.OwnerID)
+			require.Equal(t, tt.wantItems, len(cart.Items))
+
+			// Verify items have valid fields
+			for _, item := range cart.Items {
+				assert.NotEqual(t, uuid.Nil, item.ProductID)
+				assert.True(t, item.Price.Amount.GreaterThan(decimal.Zero))
+				assert.NotEmpty(t, item.Price.Currency.String())
+				assert.False(t, item.CreatedAt.IsZero())
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetCartWithLineTotals() {
+	defer suite.deleteAll()
+
+	suite.Run("mixed currencies: line totals per currency in order", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+
+		usd := randomCartItem()
+		usd.Price = domain.Money{Amount: decimal.RequireFromString("10.25"), Currency: currency.USD}
+		usd.Quantity = 3
+		eur := randomCartItem()
+		eur.Price = domain.Money{Amount: decimal.RequireFromString("7.5"), Currency: currency.EUR}
+		eur.Quantity = 2
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, usd))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, eur))
+
+		lines, err := suite.repo.GetCartWithLineTotals(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, lines, 2)
+
+		assertCartItem(t, usd, lines[0].Item)
+		want := domain.Money{Amount: decimal.RequireFromString("30.75"), Currency: currency.USD}
+		assert.True(t, want.Equal(lines[0].LineTotal), "want %v, got %v", want, lines[0].LineTotal)
+
+		assertCartItem(t, eur, lines[1].Item)
+		want = domain.Money{Amount: decimal.RequireFromString("15"), Currency: currency.EUR}
+		assert.True(t, want.Equal(lines[1].LineTotal), "want %v, got %v", want, lines[1].LineTotal)
+	})
+
+	suite.Run("deleted item: skipped", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		_, err := suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		lines, err := suite.repo.GetCartWithLineTotals(ctx, ownerID)
+		require.NoError(t, err)
+		assert.NotNil(t, lines)
+		assert.Empty(t, lines)
+	})
+}
+
+func (suite *cartRepositorySuite) TestGetCartAfter() {
+	defer suite.deleteAll()
+
+	suite.Run("paginate whole cart: no gaps or repeats", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		added := make(map[uuid.UUID]domain.CartItem, 50)
+		for range 50 {
+			item := randomCartItem()
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			added[item.ProductID] = item
+		}
+
+		var (
+			afterCreatedAt time.Time
+			afterProductID uuid.UUID
+			pages          int
+		)
+		seen := make(map[uuid.UUID]bool, len(added))
+		for {
+			page, err := suite.repo.GetCartAfter(ctx, ownerID, afterCreatedAt, afterProductID, 7)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			pages++
+
+			for _, item := range page {
+				assert.False(t, seen[item.ProductID], "product %s repeated", item.ProductID)
+				seen[item.ProductID] = true
+				assertCartItem(t, added[item.ProductID], item)
+
+				// items are ordered by the cursor columns
+				assert.True(t, item.CreatedAt.After(afterCreatedAt) ||
+					item.CreatedAt.Equal(afterCreatedAt) && item.ProductID.String() > afterProductID.String())
+				afterCreatedAt, afterProductID = item.CreatedAt, item.ProductID
+			}
+		}
+
+		assert.Len(t, seen, len(added))
+		assert.Equal(t, 8, pages)
+	})
+
+	suite.Run("non-UTC cursor: next item", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		for range 2 {
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, randomCartItem()))
+			// the creation times have to differ between the items
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		added, err := suite.repo.GetCartAfter(ctx, ownerID, time.Time{}, uuid.Nil, 10)
+		require.NoError(t, err)
+		require.Len(t, added, 2)
+
+		cursor := added[0].CreatedAt.In(time.FixedZone("UTC+3", 3*60*60))
+		page, err := suite.repo.GetCartAfter(ctx, ownerID, cursor, added[0].ProductID, 10)
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assertCartItem(t, added[1], page[0])
+	})
+
+	suite.Run("non-positive limit: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.GetCartAfter(t.Context(), gofakeit.UUID(), time.Time{}, uuid.Nil, 0)
+		require.EqualError(t, err, "limit[0] is not positive")
+	})
+}
+
+func (suite *cartRepositorySuite) TestGetCartInRange() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	ownerID := gofakeit.UUID()
+	for range 3 {
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, randomCartItem()))
+		// the creation times have to differ between the items
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// GetCart is not ordered, GetCartAfter orders by CreatedAt
+	added, err := suite.repo.GetCartAfter(ctx, ownerID, time.Time{}, uuid.Nil, 10)
+	require.NoError(t, err)
+	require.Len(t, added, 3)
+	first, second, third := added[0], added[1], added[2]
+
+	tests := []struct {
+		name      string
+		from, to  time.Time
+		wantItems []domain.CartItem
+		wantError string
+	}{
+		{
+			name:      "full window: ok",
+			from:      first.CreatedAt,
+			to:        third.CreatedAt.Add(time.Microsecond),
+			wantItems: []domain.CartItem{first, second, third},
+		},
+		{
+			name:      "to is excluded: ok",
+			from:      first.CreatedAt,
+			to:        third.CreatedAt,
+			wantItems: []domain.CartItem{first, second},
+		},
+		{
+			name:      "zero from: unbounded below",
+			to:        second.CreatedAt,
+			wantItems: []domain.CartItem{first},
+		},
+		{
+			name:      "zero to: unbounded above",
+			from:      second.CreatedAt,
+			wantItems: []domain.CartItem{second, third},
+		},
+		{
+			name:      "zero from and to: whole cart",
+			wantItems: []domain.CartItem{first, second, third},
+		},
+		{
+			name:      "non-UTC bounds: ok",
+			from:      second.CreatedAt.In(time.FixedZone("UTC+3", 3*60*60)),
+			to:        third.CreatedAt.In(time.FixedZone("UTC-5", -5*60*60)),
+			wantItems: []domain.CartItem{second},
+		},
+		{
+			name:      "inverted range: error",
+			from:      third.CreatedAt,
+			to:        first.CreatedAt,
+			wantError: fmt.Sprintf("from[%s] is after to[%s]", third.CreatedAt.Format(time.RFC3339Nano), first.CreatedAt.Format(time.RFC3339Nano)),
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			items, err := suite.repo.GetCartInRange(t.Context(), ownerID, tt.from, tt.to)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, items, len(tt.wantItems))
+			for i, item := range items {
+				assertCartItem(t, tt.wantItems[i], item)
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestDecrementItem() {
+	defer suite.deleteAll()
+
+	suite.Run("partial decrement: quantity reduced", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 3
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		got, err := suite.repo.DecrementItem(ctx, ownerID, item.ProductID, 1)
+		require.NoError(t, err)
+		item.Quantity = 2
+		assertCartItem(t, item, got)
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), stored.Quantity)
+	})
+
+	suite.Run("decrement to zero or below: item deleted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		got, err := suite.repo.DecrementItem(ctx, ownerID, item.ProductID, 5)
+		require.NoError(t, err)
+		assert.Equal(t, domain.CartItem{}, got)
+
+		_, err = suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		// the deleted item is gone for the next decrement too
+		_, err = suite.repo.DecrementItem(ctx, ownerID, item.ProductID, 1)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+
+	suite.Run("missing item: not found", func() {
+		t := suite.T()
+
+		_, err := suite.repo.DecrementItem(t.Context(), gofakeit.UUID(), uuid.New(), 1)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+
+	suite.Run("non-positive by: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.DecrementItem(t.Context(), gofakeit.UUID(), uuid.New(), 0)
+		require.EqualError(t, err, "by[0] is not positive")
+	})
+}
+
+func (suite *cartRepositorySuite) TestAddOrRemoveItem() {
+	defer suite.deleteAll()
+
+	suite.Run("positive quantity: quantity set", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 3
+		require.NoError(t, suite.repo.AddOrRemoveItem(ctx, ownerID, item))
+
+		// the desired quantity replaces the stored one instead of being added to it
+		item.Quantity = 2
+		require.NoError(t, suite.repo.AddOrRemoveItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+	})
+
+	suite.Run("zero quantity: item deleted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		item.Quantity = 0
+		require.NoError(t, suite.repo.AddOrRemoveItem(ctx, ownerID, item))
+
+		_, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		// soft-deleted like DeleteItem
+		require.NoError(t, suite.repo.RestoreItem(ctx, ownerID, item.ProductID))
+	})
+
+	suite.Run("zero quantity of missing item: ok", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Quantity = 0
+		require.NoError(t, suite.repo.AddOrRemoveItem(t.Context(), gofakeit.UUID(), item))
+	})
+
+	suite.Run("negative quantity: error", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Quantity = -1
+		err := suite.repo.AddOrRemoveItem(t.Context(), gofakeit.UUID(), item)
+		require.EqualError(t, err, "product["+item.ProductID.String()+"] is not valid: quantity[-1] is not positive")
+	})
+}
+
+func (suite *cartRepositorySuite) TestApplyQuantityDeltas() {
+	defer suite.deleteAll()
+
+	// the products are updated in this order, so the failing one comes after a successful one
+	first := randomCartItem()
+	first.ProductID = uuid.MustParse("10000000-0000-4000-8000-000000000000")
+	first.Quantity = 2
+	second := randomCartItem()
+	second.ProductID = uuid.MustParse("20000000-0000-4000-8000-000000000000")
+	second.Quantity = 2
+
+	addBoth := func(t *testing.T, ownerID string) {
+		require.NoError(t, suite.repo.AddItem(t.Context(), ownerID, first))
+		require.NoError(t, suite.repo.AddItem(t.Context(), ownerID, second))
+	}
+
+	suite.Run("increment and decrement: quantities changed", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		addBoth(t, ownerID)
+
+		err := suite.repo.ApplyQuantityDeltas(ctx, ownerID, map[uuid.UUID]int32{first.ProductID: 3, second.ProductID: -1})
+		require.NoError(t, err)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, first.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(5), got.Quantity)
+
+		got, err = suite.repo.GetItem(ctx, ownerID, second.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), got.Quantity)
+	})
+
+	suite.Run("decrement to zero: item deleted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		addBoth(t, ownerID)
+
+		err := suite.repo.ApplyQuantityDeltas(ctx, ownerID, map[uuid.UUID]int32{second.ProductID: -2})
+		require.NoError(t, err)
+
+		_, err = suite.repo.GetItem(ctx, ownerID, second.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		// soft-deleted like DeleteItem
+		require.NoError(t, suite.repo.RestoreItem(ctx, ownerID, second.ProductID))
+	})
+
+	suite.Run("decrement below zero: error naming the product, rolled back", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		addBoth(t, ownerID)
+
+		err := suite.repo.ApplyQuantityDeltas(ctx, ownerID, map[uuid.UUID]int32{first.ProductID: 1, second.ProductID: -3})
+		var negativeErr *domain.NegativeQuantityError
+		require.ErrorAs(t, err, &negativeErr)
+		assert.Equal(t, domain.NegativeQuantityError{ProductID: second.ProductID, Quantity: 2, Delta: -3}, *negativeErr)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, first.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), got.Quantity)
+	})
+
+	suite.Run("missing product: not found, rolled back", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, first))
+
+		err := suite.repo.ApplyQuantityDeltas(ctx, ownerID, map[uuid.UUID]int32{first.ProductID: 1, second.ProductID: 1})
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, first.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), got.Quantity)
+	})
+
+	suite.Run("no deltas: ok", func() {
+		t := suite.T()
+
+		require.NoError(t, suite.repo.ApplyQuantityDeltas(t.Context(), gofakeit.UUID(), nil))
+	})
+}
+
+func (suite *cartRepositorySuite) TestSwapQuantities() {
+	defer suite.deleteAll()
+
+	suite.Run("two items: quantities exchanged, prices kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		itemA := randomCartItem()
+		itemA.Quantity = 1
+		itemB := randomCartItem()
+		itemB.Quantity = 4
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, itemA))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, itemB))
+
+		require.NoError(t, suite.repo.SwapQuantities(ctx, ownerID, itemA.ProductID, itemB.ProductID))
+
+		itemA.Quantity, itemB.Quantity = 4, 1
+		for _, want := range []domain.CartItem{itemA, itemB} {
+			got, err := suite.repo.GetItem(ctx, ownerID, want.ProductID)
+			require.NoError(t, err)
+			assertCartItem(t, want, got)
+			assert.Equal(t, int32(2), got.Version)
+		}
+	})
+
+	suite.Run("same product: unchanged", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		require.NoError(t, suite.repo.SwapQuantities(ctx, ownerID, item.ProductID, item.ProductID))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+	})
+
+	suite.Run("one product missing: not found, nothing changed", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Quantity = 2
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		err := suite.repo.SwapQuantities(ctx, ownerID, item.ProductID, uuid.New())
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+		assert.Equal(t, int32(1), got.Version)
+	})
+
+	suite.Run("deleted product: not found", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		itemA := randomCartItem()
+		itemB := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, itemA))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, itemB))
+		_, err := suite.repo.DeleteItem(ctx, ownerID, itemB.ProductID)
+		require.NoError(t, err)
+
+		err = suite.repo.SwapQuantities(ctx, ownerID, itemA.ProductID, itemB.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+}
+
+func (suite *cartRepositorySuite) TestDeleteItem() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		ownerID    string
+		productID  uuid.UUID
+		setup      func(string, uuid.UUID) error
+		want       bool
+		wantTarget error
+		wantError  string
+	}{
+		{
+			name:      "delete existing item: ok",
+			ownerID:   gofakeit.UUID(),
+			productID: uuid.MustParse(gofakeit.UUID()),
+			setup: func(ownerID string, productID uuid.UUID) error {
+				item := randomCartItem()
+				item.ProductID = productID
+				return suite.repo.AddItem(suite.T().Context(), ownerID, item)
+			},
+			want: true,
+		},
+		{
+			name:       "delete non-existing item: not found",
+			ownerID:    gofakeit.UUID(),
+			productID:  uuid.MustParse(gofakeit.UUID()),
+			want:       false,
+			wantTarget: domain.ErrItemNotFound,
+		},
+		{
+			name:      "delete with empty owner ID: error",
+			ownerID:   "",
+			productID: uuid.MustParse(gofakeit.UUID()),
+			want:      false,
+			wantError: "ownerID is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			if tt.setup != nil {
+				err := tt.setup(tt.ownerID, tt.productID)
+				require.NoError(t, err)
+			}
+
+			deleted, err := suite.repo.DeleteItem(ctx, tt.ownerID, tt.productID)
+			require.Equal(t, tt.want, deleted)
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			// If item was deleted, verify it's no longer in cart
+			if deleted {
+				cart, err := suite.repo.GetCart(ctx, tt.ownerID)
+				require.NoError(t, err)
+
+				for _, item := range cart.Items {
+					assert.NotEqual(t, tt.productID, item.ProductID, "deleted item should not be in cart")
+				}
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestDeleteItems() {
+	defer suite.deleteAll()
+
+	suite.Run("delete three of four items: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		items := []domain.CartItem{randomCartItem(), randomCartItem(), randomCartItem(), randomCartItem()}
+		for _, item := range items {
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		}
+
+		// a repeated product and one not in the cart are not counted
+		productIDs := []uuid.UUID{items[0].ProductID, items[1].ProductID, items[2].ProductID, items[0].ProductID, uuid.New()}
+
+		deleted, err := suite.repo.DeleteItems(ctx, ownerID, productIDs)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), deleted)
+
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, items[3], cart.Items[0])
+	})
+
+	suite.Run("no products: nothing deleted", func() {
+		t := suite.T()
+
+		deleted, err := suite.repo.DeleteItems(t.Context(), gofakeit.UUID(), nil)
+		require.NoError(t, err)
+		assert.Zero(t, deleted)
+	})
+
+	suite.Run("nil product ID: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.DeleteItems(t.Context(), gofakeit.UUID(), []uuid.UUID{uuid.New(), uuid.Nil})
+		require.EqualError(t, err, "productIDs[1] is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestSoftDelete() {
+	defer suite.deleteAll()
+
+	// addDeleted adds the item with a quantity of 2 and deletes it
+	addDeleted := func(t *testing.T, ownerID string) domain.CartItem {
+		t.Helper()
+		ctx := t.Context()
+
+		item := randomCartItem()
+		item.Quantity = 2
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		_, err = suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		return stored
+	}
+
+	suite.Run("deleted item: absent from reads, row kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := addDeleted(t, ownerID)
+
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Empty(t, cart.Items)
+
+		count, err := suite.repo.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		_, err = suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		_, err = suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		var deletedRows int
+		err = suite.pool.QueryRow(ctx,
+			"SELECT COUNT(*) FROM cart_items WHERE owner_id = $1 AND deleted_at IS NOT NULL", ownerID).Scan(&deletedRows)
+		require.NoError(t, err)
+		assert.Equal(t, 1, deletedRows)
+	})
+
+	suite.Run("restored item: back as it was", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := addDeleted(t, ownerID)
+
+		require.NoError(t, suite.repo.RestoreItem(ctx, ownerID, item.ProductID))
+
+		restored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, restored)
+		assert.Equal(t, item.CreatedAt, restored.CreatedAt)
+
+		// the item is not deleted anymore
+		err = suite.repo.RestoreItem(ctx, ownerID, item.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+
+	suite.Run("re-added item: added anew", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := addDeleted(t, ownerID)
+
+		readded := item
+		readded.Price.Amount = readded.Price.Amount.Add(decimal.NewFromInt(1))
+		readded.Quantity = 1
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, readded))
+
+		// the quantity of the deleted item is not added to
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, readded, stored)
+		assert.False(t, stored.CreatedAt.Before(item.CreatedAt))
+	})
+
+	suite.Run("restore missing item: not found", func() {
+		err := suite.repo.RestoreItem(suite.T().Context(), gofakeit.UUID(), uuid.New())
+		suite.Require().ErrorIs(err, domain.ErrItemNotFound)
+	})
+}
+
+func (suite *cartRepositorySuite) TestUpdateItemPrice() {
+	defer suite.deleteAll()
+
+	newPrice := domain.Money{Amount: decimal.RequireFromString("42.50"), Currency: currency.EUR}
+
+	suite.Run("existing item: price updated, created at kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		before, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		updated, err := suite.repo.UpdateItemPrice(ctx, ownerID, item.ProductID, newPrice)
+		require.NoError(t, err)
+		assert.True(t, updated)
+
+		after, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		want := item
+		want.Price = newPrice
+		assertCartItem(t, want, after)
+		assert.Equal(t, before.CreatedAt, after.CreatedAt)
+	})
+
+	suite.Run("non-existing item: not found", func() {
+		t := suite.T()
+
+		updated, err := suite.repo.UpdateItemPrice(t.Context(), gofakeit.UUID(), uuid.New(), newPrice)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+		assert.False(t, updated)
+	})
+
+	suite.Run("empty currency: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.UpdateItemPrice(t.Context(), gofakeit.UUID(), uuid.New(), domain.Money{Amount: decimal.NewFromInt(1)})
+		require.EqualError(t, err, "currency is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestUpdateItemPriceIfVersion() {
+	defer suite.deleteAll()
+
+	newPrice := domain.Money{Amount: decimal.RequireFromString("42.50"), Currency: currency.EUR}
+
+	suite.Run("every change: version incremented", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), stored.Version)
+
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		_, err = suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		require.NoError(t, suite.repo.RestoreItem(ctx, ownerID, item.ProductID))
+
+		stored, err = suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Equal(t, int32(4), stored.Version)
+	})
+
+	suite.Run("expected version: price updated", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		version, err := suite.repo.UpdateItemPriceIfVersion(ctx, ownerID, item.ProductID, newPrice, stored.Version)
+		require.NoError(t, err)
+		assert.Equal(t, stored.Version+1, version)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.True(t, newPrice.Equal(got.Price), "want %v, got %v", newPrice, got.Price)
+		assert.Equal(t, version, got.Version)
+	})
+
+	suite.Run("stale version: conflict and price kept", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		stored, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		// a concurrent writer changes the item first
+		_, err = suite.repo.UpdateItemPrice(ctx, ownerID, item.ProductID, newPrice)
+		require.NoError(t, err)
+
+		otherPrice := domain.Money{Amount: decimal.RequireFromString("1.25"), Currency: currency.EUR}
+		_, err = suite.repo.UpdateItemPriceIfVersion(ctx, ownerID, item.ProductID, otherPrice, stored.Version)
+		require.ErrorIs(t, err, domain.ErrVersionConflict)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.True(t, newPrice.Equal(got.Price), "want %v, got %v", newPrice, got.Price)
+	})
+
+	suite.Run("non-existing item: not found", func() {
+		t := suite.T()
+
+		_, err := suite.repo.UpdateItemPriceIfVersion(t.Context(), gofakeit.UUID(), uuid.New(), newPrice, 1)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+}
+
+func (suite *cartRepositorySuite) TestItemNote() {
+	defer suite.deleteAll()
+
+	suite.Run("with note: round trip", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		note := "gift wrap, please"
+		item := randomCartItem()
+		item.Note = &note
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, item, cart.Items[0])
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		require.NotNil(t, got.Note)
+		assert.Equal(t, note, *got.Note)
+	})
+
+	suite.Run("without note: nil", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		noNote := randomCartItem()
+		emptyNote := randomCartItem()
+		empty := ""
+		emptyNote.Note = &empty
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, noNote))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, emptyNote))
+
+		for _, productID := range []uuid.UUID{noNote.ProductID, emptyNote.ProductID} {
+			got, err := suite.repo.GetItem(ctx, ownerID, productID)
+			require.NoError(t, err)
+			assert.Nil(t, got.Note)
+		}
+	})
+
+	suite.Run("updating the note: replaced", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		first, second := "first", "second"
+		item := randomCartItem()
+		item.Note = &first
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		item.Note = &second
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		require.NotNil(t, got.Note)
+		assert.Equal(t, second, *got.Note)
+
+		item.Note = nil
+		require.NoError(t, suite.repo.SetItem(ctx, ownerID, item))
+
+		got, err = suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assert.Nil(t, got.Note)
+	})
+}
+
+func (suite *cartRepositorySuite) TestGetItem() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	ownerID := gofakeit.UUID()
+	stored := randomCartItem()
+	suite.Require().NoError(suite.repo.AddItem(ctx, ownerID, stored))
+
+	tests := []struct {
+		name       string
+		ownerID    string
+		productID  uuid.UUID
+		want       domain.CartItem
+		wantTarget error
+		wantError  string
+	}{
+		{
+			name:      "item in cart: ok",
+			ownerID:   ownerID,
+			productID: stored.ProductID,
+			want:      stored,
+		},
+		{
+			name:       "item not in cart: not found",
+			ownerID:    ownerID,
+			productID:  uuid.New(),
+			wantTarget: domain.ErrItemNotFound,
+		},
+		{
+			name:       "item in another owner's cart: not found",
+			ownerID:    gofakeit.UUID(),
+			productID:  stored.ProductID,
+			wantTarget: domain.ErrItemNotFound,
+		},
+		{
+			name:      "empty owner ID: error",
+			ownerID:   "",
+			productID: stored.ProductID,
+			wantError: "ownerID is empty",
+		},
+		{
+			name:       "nil product ID: not found",
+			ownerID:    ownerID,
+			productID:  uuid.Nil,
+			wantTarget: domain.ErrItemNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			item, err := suite.repo.GetItem(t.Context(), tt.ownerID, tt.productID)
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assertCartItem(t, tt.want, item)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetItemsByCurrency() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	ownerID := gofakeit.UUID()
+	var usdItems []domain.CartItem
+	for _, cur := range []currency.Unit{currency.USD, currency.EUR, currency.USD, currency.GBP} {
+		item := randomCartItem()
+		item.Price.Currency = cur
+		suite.Require().NoError(suite.repo.AddItem(ctx, ownerID, item))
+
+		if cur == currency.USD {
+			usdItems = append(usdItems, item)
+		}
+	}
+
+	suite.Run("mixed-currency cart: only requested currency", func() {
+		t := suite.T()
+
+		items, err := suite.repo.GetItemsByCurrency(t.Context(), ownerID, currency.USD)
+		require.NoError(t, err)
+		require.Len(t, items, len(usdItems))
+		assert.True(t, domain.CartsEqual(domain.Cart{Items: usdItems}, domain.Cart{Items: items}))
+	})
+
+	suite.Run("no matching items: empty", func() {
+		t := suite.T()
+
+		items, err := suite.repo.GetItemsByCurrency(t.Context(), ownerID, currency.JPY)
+		require.NoError(t, err)
+		assert.NotNil(t, items)
+		assert.Empty(t, items)
+	})
+
+	suite.Run("empty currency: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.GetItemsByCurrency(t.Context(), ownerID, currency.Unit{})
+		require.EqualError(t, err, "currency is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestStreamCart() {
+	defer suite.deleteAll()
+
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name       string
+		itemCount  int
+		stopAfter  int // fn returns errStop on this call, 0 means never
+		wantCalls  int
+		wantError  string
+		wantTarget error
+	}{
+		{
+			name:       "stream never used cart: not found",
+			itemCount:  0,
+			wantCalls:  0,
+			wantError:  "q.CartKnown: cart not found",
+			wantTarget: domain.ErrCartNotFound,
+		},
+		{
+			name:      "stream cart with multiple items: ok",
+			itemCount: 5,
+			wantCalls: 5,
+		},
+		{
+			name:      "stream large cart: ok",
+			itemCount: 100,
+			wantCalls: 100,
+		},
+		{
+			name:       "stop streaming on fn error: error",
+			itemCount:  5,
+			stopAfter:  2,
+			wantCalls:  2,
+			wantError:  "fn: stop",
+			wantTarget: errStop,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			expected := make(map[uuid.UUID]domain.CartItem, tt.itemCount)
+			for i := 0; i < tt.itemCount; i++ {
+				item := randomCartItem()
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+				expected[item.ProductID] = item
+			}
+
+			var streamed []domain.CartItem
+			err := suite.repo.StreamCart(ctx, ownerID, func(item domain.CartItem) error {
+				streamed = append(streamed, item)
+				if len(streamed) == tt.stopAfter {
+					return errStop
+				}
+				return nil
+			})
+			require.Len(t, streamed, tt.wantCalls)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+
+			for _, item := range streamed {
+				assertCartItem(t, expected[item.ProductID], item)
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestClearCurrency() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name          string
+		currencies    []currency.Unit
+		clear         currency.Unit
+		want          int
+		wantRemaining []currency.Unit
+		wantError     string
+	}{
+		{
+			name:          "mixed currency cart: only matching removed",
+			currencies:    []currency.Unit{currency.USD, currency.EUR, currency.USD},
+			clear:         currency.USD,
+			want:          2,
+			wantRemaining: []currency.Unit{currency.EUR},
+		},
+		{
+			name:          "currency not in cart: nothing removed",
+			currencies:    []currency.Unit{currency.EUR},
+			clear:         currency.USD,
+			want:          0,
+			wantRemaining: []currency.Unit{currency.EUR},
+		},
+		{
+			name:       "empty currency: error",
+			currencies: []currency.Unit{currency.EUR},
+			wantError:  "currency is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			for _, cur := range tt.currencies {
+				item := randomCartItem()
+				item.Price.Currency = cur
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			removed, err := suite.repo.ClearCurrency(ctx, ownerID, tt.clear)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, removed)
+
+			cart, err := suite.repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+
+			remaining := make([]currency.Unit, 0, len(cart.Items))
+			for _, item := range cart.Items {
+				remaining = append(remaining, item.Price.Currency)
+			}
+			assert.ElementsMatch(t, tt.wantRemaining, remaining)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestApplyPriceFactor() {
+	defer suite.deleteAll()
+
+	suite.Run("mixed currency cart: only matching repriced", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		var items []domain.CartItem
+		for _, cur := range []currency.Unit{currency.USD, currency.EUR, currency.USD} {
+			item := randomCartItem()
+			item.Price.Currency = cur
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			items = append(items, item)
+		}
+
+		// another owner's USD item is untouched
+		otherOwnerID := gofakeit.UUID()
+		other := randomCartItem()
+		other.Price.Currency = currency.USD
+		require.NoError(t, suite.repo.AddItem(ctx, otherOwnerID, other))
+
+		factor := decimal.RequireFromString("0.9")
+		updated, err := suite.repo.ApplyPriceFactor(ctx, ownerID, currency.USD, factor)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), updated)
+
+		for _, item := range items {
+			want := item
+			if item.Price.Currency == currency.USD {
+				want.Price = item.Price.Multiply(factor)
+			}
+
+			got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+			require.NoError(t, err)
+			assertCartItem(t, want, got)
+		}
+
+		got, err := suite.repo.GetItem(ctx, otherOwnerID, other.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, other, got)
+	})
+
+	suite.Run("currency not in cart: nothing updated", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		item.Price.Currency = currency.EUR
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		updated, err := suite.repo.ApplyPriceFactor(ctx, ownerID, currency.USD, decimal.RequireFromString("0.9"))
+		require.NoError(t, err)
+		assert.Zero(t, updated)
+	})
+
+	tests := []struct {
+		name      string
+		ownerID   string
+		cur       currency.Unit
+		factor    decimal.Decimal
+		wantError string
+	}{
+		{
+			name:      "empty ownerID: error",
+			cur:       currency.USD,
+			factor:    decimal.NewFromInt(2),
+			wantError: "ownerID is empty",
+		},
+		{
+			name:      "empty currency: error",
+			ownerID:   gofakeit.UUID(),
+			factor:    decimal.NewFromInt(2),
+			wantError: "currency is empty",
+		},
+		{
+			name:      "zero factor: error",
+			ownerID:   gofakeit.UUID(),
+			cur:       currency.USD,
+			factor:    decimal.Zero,
+			wantError: "factor[0] is not positive",
+		},
+		{
+			name:      "negative factor: error",
+			ownerID:   gofakeit.UUID(),
+			cur:       currency.USD,
+			factor:    decimal.RequireFromString("-0.5"),
+			wantError: "factor[-0.5] is not positive",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			_, err := suite.repo.ApplyPriceFactor(t.Context(), tt.ownerID, tt.cur, tt.factor)
+			require.EqualError(t, err, tt.wantError)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestCountItems() {
+	defer suite.deleteAll()
+
+	suite.Run("adds and deletes: counted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+
+		count, err := suite.repo.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+
+		items := []domain.CartItem{randomCartItem(), randomCartItem(), randomCartItem()}
+		for _, item := range items {
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		}
+		// adding an item again sums its quantity instead of adding a line
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, items[0]))
+
+		count, err = suite.repo.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		_, err = suite.repo.DeleteItem(ctx, ownerID, items[1].ProductID)
+		require.NoError(t, err)
+
+		count, err = suite.repo.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+	})
+
+	suite.Run("empty owner ID: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.CountItems(t.Context(), "")
+		require.EqualError(t, err, "ownerID is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestPurgeCartsOlderThan() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	oldOwnerID, deletedOwnerID, mixedOwnerID, newOwnerID := gofakeit.UUID(), gofakeit.UUID(), gofakeit.UUID(), gofakeit.UUID()
+	// the newer items of these owners are deleted, so they do not keep the carts
+	staleOwnerID, newDeletedOwnerID := gofakeit.UUID(), gofakeit.UUID()
+
+	// the items are created at the time of the repository clock
+	clock := newFakeClock()
+	repo, err := repository.NewCart(suite.pool, repository.WithClock(clock))
+	require.NoError(t, err)
+
+	deleted := randomCartItem()
+	require.NoError(t, repo.AddItem(ctx, deletedOwnerID, deleted))
+	_, err = repo.DeleteItem(ctx, deletedOwnerID, deleted.ProductID)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddItem(ctx, oldOwnerID, randomCartItem()))
+	require.NoError(t, repo.AddItem(ctx, staleOwnerID, randomCartItem()))
+	mixedOld := randomCartItem()
+	require.NoError(t, repo.AddItem(ctx, mixedOwnerID, mixedOld))
+
+	stored, err := repo.GetItem(ctx, mixedOwnerID, mixedOld.ProductID)
+	require.NoError(t, err)
+	assert.True(t, clock.Now().Equal(stored.CreatedAt), "created at %s", stored.CreatedAt)
+
+	cutoff := clock.Now().Add(time.Minute)
+	clock.Advance(time.Hour)
+
+	require.NoError(t, repo.AddItem(ctx, mixedOwnerID, randomCartItem()))
+	require.NoError(t, repo.AddItem(ctx, newOwnerID, randomCartItem()))
+	for _, ownerID := range []string{staleOwnerID, newDeletedOwnerID} {
+		newDeleted := randomCartItem()
+		require.NoError(t, repo.AddItem(ctx, ownerID, newDeleted))
+		_, err = repo.DeleteItem(ctx, ownerID, newDeleted.ProductID)
+		require.NoError(t, err)
+	}
+
+	suite.Run("zero cutoff: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.PurgeCartsOlderThan(t.Context(), time.Time{})
+		require.EqualError(t, err, "cutoff is zero")
+	})
+
+	suite.Run("carts straddling the cutoff: only old carts purged", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		purged, err := suite.repo.PurgeCartsOlderThan(ctx, cutoff)
+		require.NoError(t, err)
+		// the deleted items are removed for good too
+		assert.Equal(t, int64(5), purged)
+
+		for ownerID, wantCount := range map[string]int64{oldOwnerID: 0, staleOwnerID: 0, mixedOwnerID: 2, newOwnerID: 1} {
+			count, err := suite.repo.CountItems(ctx, ownerID)
+			require.NoError(t, err)
+			assert.Equal(t, wantCount, count, ownerID)
+		}
+
+		err = suite.repo.RestoreItem(ctx, deletedOwnerID, deleted.ProductID)
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+	})
+}
+
+func (suite *cartRepositorySuite) TestCartExists() {
+	defer suite.deleteAll()
+
+	suite.Run("no items: false", func() {
+		t := suite.T()
+
+		exists, err := suite.repo.CartExists(t.Context(), gofakeit.UUID())
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	suite.Run("item added: true", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+
+		exists, err := suite.repo.CartExists(ctx, ownerID)
+		require.NoError(t, err)
+		assert.True(t, exists)
+
+		// soft-deleted items do not count
+		_, err = suite.repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		exists, err = suite.repo.CartExists(ctx, ownerID)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	suite.Run("empty owner ID: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.CartExists(t.Context(), "")
+		require.EqualError(t, err, "ownerID is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestClearCart() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name      string
+		ownerID   string
+		itemCount int
+		want      int64
+		wantError string
+	}{
+		{
+			name:      "several items: all removed",
+			ownerID:   gofakeit.UUID(),
+			itemCount: 3,
+			want:      3,
+		},
+		{
+			name:    "never used cart: nothing removed",
+			ownerID: gofakeit.UUID(),
+			want:    0,
+		},
+		{
+			name:      "empty owner ID: error",
+			ownerID:   "",
+			wantError: "ownerID is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			for range tt.itemCount {
+				require.NoError(t, suite.repo.AddItem(ctx, tt.ownerID, randomCartItem()))
+			}
+
+			otherOwnerID := gofakeit.UUID()
+			require.NoError(t, suite.repo.AddItem(ctx, otherOwnerID, randomCartItem()))
+
+			removed, err := suite.repo.ClearCart(ctx, tt.ownerID)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, removed)
+
+			cart, err := suite.repo.GetCart(ctx, tt.ownerID)
+			if tt.itemCount == 0 {
+				require.ErrorIs(t, err, domain.ErrCartNotFound)
+			} else {
+				require.NoError(t, err)
+				assert.Empty(t, cart.Items)
+			}
+
+			// other carts are not affected
+			other, err := suite.repo.GetCart(ctx, otherOwnerID)
+			require.NoError(t, err)
+			assert.Len(t, other.Items, 1)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestMoveItems() {
+	defer suite.deleteAll()
+
+	suite.Run("overlapping product: quantities merged, source deleted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		guestID, userID := gofakeit.UUID(), gofakeit.UUID()
+		guestOnly, userOnly := randomCartItem(), randomCartItem()
+
+		guestShared := randomCartItem()
+		guestShared.Quantity = 2
+		userShared := guestShared
+		userShared.Price.Amount = userShared.Price.Amount.Add(decimal.NewFromInt(1))
+		userShared.Quantity = 3
+
+		for _, item := range []domain.CartItem{guestOnly, guestShared} {
+			require.NoError(t, suite.repo.AddItem(ctx, guestID, item))
+		}
+		for _, item := range []domain.CartItem{userOnly, userShared} {
+			require.NoError(t, suite.repo.AddItem(ctx, userID, item))
+		}
+
+		moved, err := suite.repo.MoveItems(ctx, guestID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), moved)
+
+		// the target cart keeps its price of the shared product
+		merged := userShared
+		merged.Quantity = 5
+
+		userCart, err := suite.repo.GetCart(ctx, userID)
+		require.NoError(t, err)
+		assert.True(t, domain.CartsEqual(domain.Cart{Items: []domain.CartItem{guestOnly, userOnly, merged}}, userCart))
+
+		guestCart, err := suite.repo.GetCart(ctx, guestID)
+		require.NoError(t, err)
+		assert.Empty(t, guestCart.Items)
+
+		// soft-deleted, so the source item can still be restored
+		require.NoError(t, suite.repo.RestoreItem(ctx, guestID, guestOnly.ProductID))
+	})
+
+	tests := []struct {
+		name        string
+		fromOwnerID string
+		toOwnerID   string
+		wantError   string
+	}{
+		{
+			name:      "empty from owner: error",
+			toOwnerID: "user",
+			wantError: "fromOwnerID is empty",
+		},
+		{
+			name:        "empty to owner: error",
+			fromOwnerID: "guest",
+			wantError:   "toOwnerID is empty",
+		},
+		{
+			name:        "same owners: error",
+			fromOwnerID: "guest",
+			toOwnerID:   "guest",
+			wantError:   "fromOwnerID and toOwnerID are the same",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			_, err := suite.repo.MoveItems(suite.T().Context(), tt.fromOwnerID, tt.toOwnerID)
+			suite.Require().EqualError(err, tt.wantError)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestCartCurrencyReport() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		currencies []currency.Unit
+		want       domain.CurrencyReport
+	}{
+		{
+			name: "empty cart: single currency",
+			want: domain.CurrencyReport{Currencies: []currency.Unit{}, SingleCurrency: true},
+		},
+		{
+			name:       "one currency: single currency",
+			currencies: []currency.Unit{currency.USD, currency.USD},
+			want:       domain.CurrencyReport{Currencies: []currency.Unit{currency.USD}, SingleCurrency: true},
+		},
+		{
+			name:       "mixed currencies: sorted by code",
+			currencies: []currency.Unit{currency.USD, currency.EUR, currency.USD},
+			want:       domain.CurrencyReport{Currencies: []currency.Unit{currency.EUR, currency.USD}, SingleCurrency: false},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			for _, cur := range tt.currencies {
+				item := randomCartItem()
+				item.Price.Currency = cur
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			report, err := suite.repo.CartCurrencyReport(ctx, ownerID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, report)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestCartCurrency() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		currencies []currency.Unit
+		want       currency.Unit
+		wantTarget error
+	}{
+		{
+			name:       "empty cart: error",
+			wantTarget: domain.ErrEmptyCart,
+		},
+		{
+			name:       "one currency: returned",
+			currencies: []currency.Unit{currency.EUR, currency.EUR},
+			want:       currency.EUR,
+		},
+		{
+			name:       "mixed currencies: error",
+			currencies: []currency.Unit{currency.USD, currency.EUR, currency.GBP},
+			wantTarget: domain.ErrMixedCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			for _, cur := range tt.currencies {
+				item := randomCartItem()
+				item.Price.Currency = cur
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			cur, err := suite.repo.CartCurrency(ctx, ownerID)
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cur)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestValidateProducts() {
+	defer suite.deleteAll()
+
+	errCatalog := errors.New("catalog is down")
+
+	tests := []struct {
+		name        string
+		itemCount   int
+		missing     int // how many of the cart products the catalog reports as missing
+		catalogErr  error
+		wantCalled  bool
+		wantMissing int
+		wantError   string
+	}{
+		{
+			name:       "empty cart: catalog not called",
+			itemCount:  0,
+			wantCalled: false,
+		},
+		{
+			name:       "all products exist: none missing",
+			itemCount:  3,
+			wantCalled: true,
+		},
+		{
+			name:        "some products gone: missing returned",
+			itemCount:   3,
+			missing:     2,
+			wantCalled:  true,
+			wantMissing: 2,
+		},
+		{
+			name:       "catalog fails: error",
+			itemCount:  2,
+			catalogErr: errCatalog,
+			wantCalled: true,
+			wantError:  "exists: catalog is down",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			var productIDs []uuid.UUID
+			for i := 0; i < tt.itemCount; i++ {
+				item := randomCartItem()
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+				productIDs = append(productIDs, item.ProductID)
+			}
+
+			wantMissing := productIDs[:tt.missing]
+
+			var called bool
+			missing, err := suite.repo.ValidateProducts(ctx, ownerID, func(ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+				called = true
+				assert.ElementsMatch(t, productIDs, ids)
+
+				if tt.catalogErr != nil {
+					return nil, tt.catalogErr
+				}
+
+				existing := make(map[uuid.UUID]bool, len(ids))
+				for _, id := range productIDs[tt.missing:] {
+					existing[id] = true
+				}
+				return existing, nil
+			})
+			require.Equal(t, tt.wantCalled, called)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, tt.catalogErr)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, missing, tt.wantMissing)
+			assert.ElementsMatch(t, wantMissing, missing)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestItemAddedAt() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		addItem    bool
+		wantError  string
+		wantTarget error
+	}{
+		{
+			name:    "existing item: ok",
+			addItem: true,
+		},
+		{
+			name:       "non-existing item: not found",
+			wantError:  "q.GetItemCreatedAt: item not found",
+			wantTarget: domain.ErrItemNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			item := randomCartItem()
+			if tt.addItem {
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			addedAt, err := suite.repo.ItemAddedAt(ctx, ownerID, item.ProductID)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+
+			cart, err := suite.repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+			require.Len(t, cart.Items, 1)
+			assert.True(t, cart.Items[0].CreatedAt.Equal(addedAt))
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestItemRecencyRank() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	ownerID := gofakeit.UUID()
+	var productIDs []uuid.UUID
+	for i := 0; i < 3; i++ {
+		item := randomCartItem()
+		suite.Require().NoError(suite.repo.AddItem(ctx, ownerID, item))
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	tests := []struct {
+		name       string
+		productID  uuid.UUID
+		want       int
+		wantError  string
+		wantTarget error
+	}{
+		{
+			name:      "most recent item: first",
+			productID: productIDs[2],
+			want:      1,
+		},
+		{
+			name:      "oldest item: last",
+			productID: productIDs[0],
+			want:      3,
+		},
+		{
+			name:       "non-existing item: not found",
+			productID:  uuid.New(),
+			wantError:  "q.GetItemRecencyRank: item not found",
+			wantTarget: domain.ErrItemNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			rank, err := suite.repo.ItemRecencyRank(t.Context(), ownerID, tt.productID)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rank)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestProductWeightedAvgPrice() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	productID := uuid.New()
+	for _, price := range []domain.Money{
+		{Amount: decimal.RequireFromString("10"), Currency: currency.USD},
+		{Amount: decimal.RequireFromString("20"), Currency: currency.USD},
+		{Amount: decimal.RequireFromString("100"), Currency: currency.EUR},
+	} {
+		item := randomCartItem()
+		item.ProductID = productID
+		item.Price = price
+		suite.Require().NoError(suite.repo.AddItem(ctx, gofakeit.UUID(), item))
+	}
+
+	tests := []struct {
+		name      string
+		productID uuid.UUID
+		currency  currency.Unit
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name:      "product in several carts: average in currency",
+			productID: productID,
+			currency:  currency.USD,
+			want:      domain.Money{Amount: decimal.RequireFromString("15"), Currency: currency.USD},
+		},
+		{
+			name:      "product in one cart: its price",
+			productID: productID,
+			currency:  currency.EUR,
+			want:      domain.Money{Amount: decimal.RequireFromString("100"), Currency: currency.EUR},
+		},
+		{
+			name:      "product not held in currency: not found",
+			productID: productID,
+			currency:  currency.GBP,
+			wantError: "q.GetProductWeightedAvgPrice: item not found",
+		},
+		{
+			name:      "product in no cart: not found",
+			productID: uuid.New(),
+			currency:  currency.USD,
+			wantError: "q.GetProductWeightedAvgPrice: item not found",
+		},
+		{
+			name:      "empty product id: error",
+			productID: uuid.Nil,
+			currency:  currency.USD,
+			wantError: "productID is empty",
+		},
+		{
+			name:      "empty currency: error",
+			productID: productID,
+			wantError: "currency is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			avg, err := suite.repo.ProductWeightedAvgPrice(t.Context(), tt.productID, tt.currency)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.Equal(avg), "want %v, got %v", tt.want, avg)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestDemandByProduct() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	popular, rare, absent := uuid.New(), uuid.New(), uuid.New()
+	for _, productID := range []uuid.UUID{popular, popular, popular, rare} {
+		item := randomCartItem()
+		item.ProductID = productID
+		suite.Require().NoError(suite.repo.AddItem(ctx, gofakeit.UUID(), item))
+	}
+
+	tests := []struct {
+		name       string
+		productIDs []uuid.UUID
+		want       map[uuid.UUID]int64
+	}{
+		{
+			name:       "products in carts and absent: ok",
+			productIDs: []uuid.UUID{popular, rare, absent},
+			want:       map[uuid.UUID]int64{popular: 3, rare: 1, absent: 0},
+		},
+		{
+			name:       "no products: empty",
+			productIDs: nil,
+			want:       map[uuid.UUID]int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			demand, err := suite.repo.DemandByProduct(t.Context(), tt.productIDs)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, demand)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetCartsByOwners() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	owner1, owner2, emptyOwner := gofakeit.UUID(), gofakeit.UUID(), gofakeit.UUID()
+	for _, ownerID := range []string{owner1, owner1, owner2} {
+		suite.Require().NoError(suite.repo.AddItem(ctx, ownerID, randomCartItem()))
+	}
+
+	tests := []struct {
+		name      string
+		ownerIDs  []string
+		wantItems map[string]int
+	}{
+		{
+			name:      "populated and empty owners: ok",
+			ownerIDs:  []string{owner1, owner2, emptyOwner},
+			wantItems: map[string]int{owner1: 2, owner2: 1, emptyOwner: 0},
+		},
+		{
+			name:      "duplicated owners: ok",
+			ownerIDs:  []string{owner2, owner2},
+			wantItems: map[string]int{owner2: 1},
+		},
+		{
+			name:      "no owners: empty",
+			ownerIDs:  nil,
+			wantItems: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			carts, err := suite.repo.GetCartsByOwners(ctx, tt.ownerIDs)
+			require.NoError(t, err)
+			require.Len(t, carts, len(tt.wantItems))
+
+			for ownerID, wantItems := range tt.wantItems {
+				cart, ok := carts[ownerID]
+				require.True(t, ok, ownerID)
+				assert.Equal(t, ownerID, cart.OwnerID)
+				assert.NotNil(t, cart.Items)
+				assert.Len(t, cart.Items, wantItems)
+
+				// unlike GetCart, owners who never had a cart get an empty one
+				single, err := suite.repo.GetCart(ctx, ownerID)
+				if wantItems == 0 {
+					require.ErrorIs(t, err, domain.ErrCartNotFound)
+					continue
+				}
+				require.NoError(t, err)
+				assert.ElementsMatch(t, single.Items, cart.Items)
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetRecentItems() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	ownerA, ownerB := gofakeit.UUID(), gofakeit.UUID()
+
+	// added in this order, alternating the owners
+	added := []domain.OwnedCartItem{
+		{OwnerID: ownerA, Item: randomCartItem()},
+		{OwnerID: ownerB, Item: randomCartItem()},
+		{OwnerID: ownerA, Item: randomCartItem()},
+		{OwnerID: ownerB, Item: randomCartItem()},
+	}
+	for _, owned := range added {
+		require.NoError(t, suite.repo.AddItem(ctx, owned.OwnerID, owned.Item))
+		// the creation times have to differ between the items
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertOwnedItems := func(t *testing.T, want, got []domain.OwnedCartItem) {
+		t.Helper()
+
+		require.Len(t, got, len(want))
+		for i := range want {
+			assert.Equal(t, want[i].OwnerID, got[i].OwnerID)
+			assertCartItem(t, want[i].Item, got[i].Item)
+		}
+	}
+
+	suite.Run("limit below item count: newest first", func() {
+		t := suite.T()
+
+		items, err := suite.repo.GetRecentItems(t.Context(), 3)
+		require.NoError(t, err)
+		assertOwnedItems(t, []domain.OwnedCartItem{added[3], added[2], added[1]}, items)
+	})
+
+	suite.Run("limit above item count: all items", func() {
+		t := suite.T()
+
+		items, err := suite.repo.GetRecentItems(t.Context(), 10)
+		require.NoError(t, err)
+		assertOwnedItems(t, []domain.OwnedCartItem{added[3], added[2], added[1], added[0]}, items)
+	})
+
+	suite.Run("deleted item: skipped", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		_, err := suite.repo.DeleteItem(ctx, ownerB, added[3].Item.ProductID)
+		require.NoError(t, err)
+
+		items, err := suite.repo.GetRecentItems(ctx, 2)
+		require.NoError(t, err)
+		assertOwnedItems(t, []domain.OwnedCartItem{added[2], added[1]}, items)
+	})
+
+	suite.Run("non-positive limit: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.GetRecentItems(t.Context(), 0)
+		require.EqualError(t, err, "limit[0] is not positive")
+	})
+}
+
+func (suite *cartRepositorySuite) TestListOwners() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	owners := []string{gofakeit.UUID(), gofakeit.UUID(), gofakeit.UUID()}
+	for _, ownerID := range owners {
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, randomCartItem()))
+	}
+	// a second item does not repeat the owner
+	require.NoError(t, suite.repo.AddItem(ctx, owners[0], randomCartItem()))
+
+	// an owner with only deleted items is not listed
+	deletedOwnerID, deleted := gofakeit.UUID(), randomCartItem()
+	require.NoError(t, suite.repo.AddItem(ctx, deletedOwnerID, deleted))
+	_, err := suite.repo.DeleteItem(ctx, deletedOwnerID, deleted.ProductID)
+	require.NoError(t, err)
+
+	slices.Sort(owners)
+
+	suite.Run("paginated: every owner once, sorted", func() {
+		t := suite.T()
+
+		var got []string
+		for offset := int32(0); ; offset += 2 {
+			page, err := suite.repo.ListOwners(t.Context(), 2, offset)
+			require.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			got = append(got, page...)
+		}
+		assert.Equal(t, owners, got)
+	})
+
+	suite.Run("non-positive limit: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.ListOwners(t.Context(), 0, 0)
+		require.EqualError(t, err, "limit[0] is not positive")
+	})
+
+	suite.Run("negative offset: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.ListOwners(t.Context(), 2, -1)
+		require.EqualError(t, err, "offset[-1] is negative")
+	})
+}
+func (suite *cartRepositorySuite) TestTopProducts() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	owners := []string{gofakeit.UUID(), gofakeit.UUID(), gofakeit.UUID()}
+	everywhere, twice, unique := randomCartItem(), randomCartItem(), randomCartItem()
+
+	for _, ownerID := range owners {
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, everywhere))
+	}
+	for _, ownerID := range owners[:2] {
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, twice))
+	}
+	require.NoError(t, suite.repo.AddItem(ctx, owners[0], unique))
+
+	// a deleted item does not count
+	require.NoError(t, suite.repo.AddItem(ctx, owners[2], unique))
+	_, err := suite.repo.DeleteItem(ctx, owners[2], unique.ProductID)
+	require.NoError(t, err)
+
+	suite.Run("limit below product count: most carts first", func() {
+		t := suite.T()
+
+		counts, err := suite.repo.TopProducts(t.Context(), 2)
+		require.NoError(t, err)
+		assert.Equal(t, []domain.ProductCount{
+			{ProductID: everywhere.ProductID, CartCount: 3},
+			{ProductID: twice.ProductID, CartCount: 2},
+		}, counts)
+	})
+
+	suite.Run("limit above product count: all products", func() {
+		t := suite.T()
+
+		counts, err := suite.repo.TopProducts(t.Context(), 10)
+		require.NoError(t, err)
+		assert.Equal(t, []domain.ProductCount{
+			{ProductID: everywhere.ProductID, CartCount: 3},
+			{ProductID: twice.ProductID, CartCount: 2},
+			{ProductID: unique.ProductID, CartCount: 1},
+		}, counts)
+	})
+
+	suite.Run("non-positive limit: error", func() {
+		t := suite.T()
+
+		_, err := suite.repo.TopProducts(t.Context(), 0)
+		require.EqualError(t, err, "limit[0] is not positive")
+	})
+}
+
+func (suite *cartRepositorySuite) TestDistinctCurrencies() {
+	defer suite.deleteAll()
+
+	suite.Run("no carts: empty", func() {
+		t := suite.T()
+
+		currencies, err := suite.repo.DistinctCurrencies(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, currencies)
+	})
+
+	suite.Run("several carts: distinct sorted by code", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		for _, cur := range []currency.Unit{currency.USD, currency.EUR, currency.USD, currency.GBP} {
+			item := randomCartItem()
+			item.Price.Currency = cur
+			require.NoError(t, suite.repo.AddItem(ctx, gofakeit.UUID(), item))
+		}
+
+		currencies, err := suite.repo.DistinctCurrencies(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []currency.Unit{currency.EUR, currency.GBP, currency.USD}, currencies)
+	})
+}
+
+func (suite *cartRepositorySuite) TestGetCartTotal() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name       string
+		prices     []domain.Money
+		want       domain.Money
+		wantTarget error
+	}{
+		{
+			name: "single currency: sum",
+			prices: []domain.Money{
+				{Amount: decimal.RequireFromString("10.50"), Currency: currency.EUR},
+				{Amount: decimal.RequireFromString("4.25"), Currency: currency.EUR},
+			},
+			want: domain.Money{Amount: decimal.RequireFromString("14.75"), Currency: currency.EUR},
+		},
+		{
+			name: "empty cart: zero",
+			want: domain.Money{Amount: decimal.Zero},
+		},
+		{
+			name: "mixed currencies: error",
+			prices: []domain.Money{
+				{Amount: decimal.RequireFromString("1"), Currency: currency.USD},
+				{Amount: decimal.RequireFromString("2"), Currency: currency.EUR},
+			},
+			wantTarget: domain.ErrMixedCurrency,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			for _, price := range tt.prices {
+				item := randomCartItem()
+				item.Price = price
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			total, err := suite.repo.GetCartTotal(ctx, ownerID)
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.Equal(total), "want %v, got %v", tt.want, total)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetCartTotalIn() {
+	defer suite.deleteAll()
+
+	rates := map[string]decimal.Decimal{"EUR": decimal.RequireFromString("1.10")}
+
+	tests := []struct {
+		name      string
+		prices    []domain.Money
+		target    currency.Unit
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name: "mixed currencies: converted and summed",
+			prices: []domain.Money{
+				{Amount: decimal.RequireFromString("10.50"), Currency: currency.USD},
+				{Amount: decimal.RequireFromString("10"), Currency: currency.EUR},
+			},
+			target: currency.USD,
+			want:   domain.Money{Amount: decimal.RequireFromString("21.50"), Currency: currency.USD},
+		},
+		{
+			name:   "empty cart: zero in target currency",
+			target: currency.USD,
+			want:   domain.Money{Amount: decimal.Zero, Currency: currency.USD},
+		},
+		{
+			name: "missing rate: error",
+			prices: []domain.Money{
+				{Amount: decimal.RequireFromString("1"), Currency: currency.GBP},
+			},
+			target:    currency.USD,
+			wantError: "cart.TotalIn: rate[GBP] is missing",
+		},
+		{
+			name:      "empty target currency: error",
+			wantError: "currency is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			for _, price := range tt.prices {
+				item := randomCartItem()
+				item.Price = price
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			total, err := suite.repo.GetCartTotalIn(ctx, ownerID, tt.target, rates)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.Equal(total), "want %v, got %v", tt.want, total)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestTotalExcluding() {
+	defer suite.deleteAll()
+
+	ctx := suite.T().Context()
+
+	ownerID := gofakeit.UUID()
+	var productIDs []uuid.UUID
+	for _, amount := range []string{"10.50", "20", "5.25"} {
+		item := randomCartItem()
+		item.Price = domain.Money{Amount: decimal.RequireFromString(amount), Currency: currency.USD}
+		suite.Require().NoError(suite.repo.AddItem(ctx, ownerID, item))
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	mixedOwnerID := gofakeit.UUID()
+	mixedItems := make(map[currency.Unit]domain.CartItem)
+	for _, cur := range []currency.Unit{currency.USD, currency.EUR} {
+		item := randomCartItem()
+		item.Price = domain.Money{Amount: decimal.RequireFromString("7.5"), Currency: cur}
+		item.Quantity = 2
+		suite.Require().NoError(suite.repo.AddItem(ctx, mixedOwnerID, item))
+		mixedItems[cur] = item
+	}
+
+	tests := []struct {
+		name      string
+		ownerID   string
+		excluded  []uuid.UUID
+		want      domain.Money
+		wantError string
+	}{
+		{
+			name:    "nothing excluded: cart total",
+			ownerID: ownerID,
+			want:    domain.Money{Amount: decimal.RequireFromString("35.75"), Currency: currency.USD},
+		},
+		{
+			name:     "some excluded: rest total",
+			ownerID:  ownerID,
+			excluded: []uuid.UUID{productIDs[1], uuid.New()},
+			want:     domain.Money{Amount: decimal.RequireFromString("15.75"), Currency: currency.USD},
+		},
+		{
+			name:     "all excluded: zero like an empty cart",
+			ownerID:  ownerID,
+			excluded: productIDs,
+			want:     domain.Money{Amount: decimal.Zero},
+		},
+		{
+			name:      "mixed currencies: error",
+			ownerID:   mixedOwnerID,
+			wantError: "cart has mixed currencies",
+		},
+		{
+			name:     "mixed currencies with the only USD line excluded: EUR total",
+			ownerID:  mixedOwnerID,
+			excluded: []uuid.UUID{mixedItems[currency.USD].ProductID},
+			want:     domain.Money{Amount: decimal.RequireFromString("15"), Currency: currency.EUR},
+		},
+		{
+			name:    "empty cart: zero",
+			ownerID: gofakeit.UUID(),
+			want:    domain.Money{Amount: decimal.Zero},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			total, err := suite.repo.TotalExcluding(t.Context(), tt.ownerID, tt.excluded)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.True(t, tt.want.Equal(total), "want %v, got %v", tt.want, total)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestFallbackCurrency() {
+	defer suite.deleteAll()
+
+	var logs bytes.Buffer
+	lenientRepo, err := repository.NewCart(suite.pool,
+		repository.WithFallbackCurrency(currency.EUR),
+		repository.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))))
+	suite.Require().NoError(err)
+
+	tests := []struct {
+		name         string
+		repo         port.CartRepository
+		wantCurrency currency.Unit
+		wantLog      string
+		wantError    string
+	}{
+		{
+			name:      "strict mode: error",
+			repo:      suite.repo,
+			wantError: "r.mapGetCartRow: currency[] is not valid: currency: tag is not well-formed",
+		},
+		{
+			name:         "lenient mode: fallback used",
+			repo:         lenientRepo,
+			wantCurrency: currency.EUR,
+			wantLog:      `level=WARN msg="cart item currency is not valid, using fallback currency"`,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			logs.Reset()
+			ownerID := gofakeit.UUID()
+			validItem := randomCartItem()
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, validItem))
+
+			// legacy rows were stored before the currency was validated
+			legacyItem := randomCartItem()
+			_, err := suite.pool.Exec(ctx,
+				"INSERT INTO cart_items (owner_id, product_id, price_amount, price_currency) VALUES ($1, $2, $3, '')",
+				ownerID, legacyItem.ProductID, legacyItem.Price.Amount)
+			require.NoError(t, err)
+
+			cart, err := tt.repo.GetCart(ctx, ownerID)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, cart.Items, 2)
+			assert.Contains(t, logs.String(), tt.wantLog)
+			assert.Contains(t, logs.String(), "fallbackCurrency=EUR")
+
+			legacyItem.Price.Currency = tt.wantCurrency
+			for _, item := range cart.Items {
+				if item.ProductID == legacyItem.ProductID {
+					assertCartItem(t, legacyItem, item)
+				} else {
+					assertCartItem(t, validItem, item)
+				}
+			}
+		})
+	}
+
+	suite.Run("lenient mode: fallback used for the previous item", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		legacyItem := randomCartItem()
+		_, err := suite.pool.Exec(ctx,
+			"INSERT INTO cart_items (owner_id, product_id, price_amount, price_currency) VALUES ($1, $2, $3, '')",
+			ownerID, legacyItem.ProductID, legacyItem.Price.Amount)
+		require.NoError(t, err)
+
+		item := randomCartItem()
+		item.ProductID = legacyItem.ProductID
+		previous, err := lenientRepo.AddItemReturningPrevious(ctx, ownerID, item)
+		require.NoError(t, err)
+		require.NotNil(t, previous)
+		assert.Equal(t, currency.EUR, previous.Price.Currency)
+	})
+}
+
+func (suite *cartRepositorySuite) TestLogging() {
+	validItem := randomCartItem()
+	invalidItem := randomCartItem()
+	invalidItem.Quantity = 0
+
+	tests := []struct {
+		name        string
+		item        domain.CartItem
+		wantRecords []map[string]any
+	}{
+		{
+			name: "added item: debug records",
+			item: validItem,
+			wantRecords: []map[string]any{
+				{"level": "DEBUG", "msg": "cart repository call started", "method": "AddItem", "product_id": validItem.ProductID.String()},
+				{"level": "DEBUG", "msg": "cart repository call finished", "method": "AddItem", "product_id": validItem.ProductID.String()},
+			},
+		},
+		{
+			name: "invalid item: error record",
+			item: invalidItem,
+			wantRecords: []map[string]any{
+				{"level": "DEBUG", "msg": "cart repository call started", "method": "AddItem", "product_id": invalidItem.ProductID.String()},
+				{
+					"level": "ERROR", "msg": "cart repository call failed", "method": "AddItem", "product_id": invalidItem.ProductID.String(),
+					"error": fmt.Sprintf("product[%s] is not valid: quantity[0] is not positive", invalidItem.ProductID),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			defer suite.deleteAll()
+
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+			repo, err := repository.NewCart(suite.pool, repository.WithLogger(logger))
+			require.NoError(t, err)
+
+			ownerID := gofakeit.UUID()
+			_ = repo.AddItem(t.Context(), ownerID, tt.item)
+
+			var records []map[string]any
+			decoder := json.NewDecoder(&logs)
+			for decoder.More() {
+				var record map[string]any
+				require.NoError(t, decoder.Decode(&record))
+
+				// time varies between runs
+				assert.Contains(t, record, "time")
+				delete(record, "time")
+
+				records = append(records, record)
+			}
+
+			// the end of the call is logged with its duration
+			require.Len(t, records, 2)
+			assert.Contains(t, records[1], "duration")
+			delete(records[1], "duration")
+
+			for _, record := range tt.wantRecords {
+				record["owner_id"] = ownerID
+			}
+			assert.Equal(t, tt.wantRecords, records)
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestTracing() {
+	t := suite.T()
+	ctx := t.Context()
+	defer suite.deleteAll()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	repo, err := repository.NewCart(suite.pool, repository.WithTracer(provider.Tracer("test")))
+	require.NoError(t, err)
+
+	ownerID := gofakeit.UUID()
+	item := randomCartItem()
+
+	require.NoError(t, repo.AddItem(ctx, ownerID, item))
+	_, err = repo.GetCart(ctx, ownerID)
+	require.NoError(t, err)
+	_, err = repo.DeleteItem(ctx, ownerID, uuid.New())
+	require.ErrorIs(t, err, domain.ErrItemNotFound)
+	// not traced
+	_, err = repo.CountItems(ctx, ownerID)
+	require.NoError(t, err)
+
+	type span struct {
+		name       string
+		attributes []attribute.KeyValue
+		status     sdktrace.Status
+	}
+
+	wantSpans := []span{
+		{
+			name:       "cartRepository.AddItem",
+			attributes: []attribute.KeyValue{attribute.String("owner_id", ownerID)},
+		},
+		{
+			name:       "cartRepository.GetCart",
+			attributes: []attribute.KeyValue{attribute.String("owner_id", ownerID), attribute.Int("rows_returned", 1)},
+		},
+		{
+			name:       "cartRepository.DeleteItem",
+			attributes: []attribute.KeyValue{attribute.String("owner_id", ownerID)},
+			status:     sdktrace.Status{Code: codes.Error, Description: "q.DeleteItem: " + domain.ErrItemNotFound.Error()},
+		},
+	}
+
+	var spans []span
+	for _, ended := range recorder.Ended() {
+		spans = append(spans, span{
+			name:       ended.Name(),
+			attributes: ended.Attributes(),
+			status:     ended.Status(),
+		})
+	}
+	assert.Equal(t, wantSpans, spans)
+
+	// the error is recorded as an event of the failed span
+	events := recorder.Ended()[2].Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "exception", events[0].Name)
+}
+
+type requestIDKey struct{}
+
+func (suite *cartRepositorySuite) TestContextKeys() {
+	t := suite.T()
+	defer suite.deleteAll()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	repo, err := repository.NewCart(suite.pool,
+		repository.WithLogger(logger),
+		repository.WithTracer(provider.Tracer("test")),
+		repository.WithContextKeys(repository.ContextKey{Name: "request_id", Key: requestIDKey{}}))
+	require.NoError(t, err)
+
+	decodeRecords := func(t *testing.T) []map[string]any {
+		t.Helper()
+		defer logs.Reset()
+
+		var records []map[string]any
+		decoder := json.NewDecoder(&logs)
+		for decoder.More() {
+			var record map[string]any
+			require.NoError(t, decoder.Decode(&record))
+			records = append(records, record)
+		}
+		return records
+	}
+
+	suite.Run("key in context: logged and traced", func() {
+		t := suite.T()
+		ctx := context.WithValue(t.Context(), requestIDKey{}, "req-42")
+
+		require.NoError(t, repo.AddItem(ctx, gofakeit.UUID(), randomCartItem()))
+
+		records := decodeRecords(t)
+		require.Len(t, records, 2)
+		for _, record := range records {
+			assert.Equal(t, "req-42", record["request_id"])
+		}
+
+		spans := recorder.Ended()
+		require.NotEmpty(t, spans)
+		assert.Contains(t, spans[len(spans)-1].Attributes(), attribute.String("request_id", "req-42"))
+	})
+
+	suite.Run("key missing: skipped", func() {
+		t := suite.T()
+
+		require.NoError(t, repo.AddItem(t.Context(), gofakeit.UUID(), randomCartItem()))
+
+		records := decodeRecords(t)
+		require.Len(t, records, 2)
+		for _, record := range records {
+			assert.NotContains(t, record, "request_id")
+		}
+
+		spans := recorder.Ended()
+		require.NotEmpty(t, spans)
+		for _, attr := range spans[len(spans)-1].Attributes() {
+			assert.NotEqual(t, attribute.Key("request_id"), attr.Key)
+		}
+	})
+}
+
+func (suite *cartRepositorySuite) TestMetrics() {
+	invalidItem := randomCartItem()
+	invalidItem.Quantity = 0
+
+	tests := []struct {
+		name        string
+		item        domain.CartItem
+		wantOutcome string
+	}{
+		{
+			name:        "added item: ok outcome",
+			item:        randomCartItem(),
+			wantOutcome: repository.OutcomeOK,
+		},
+		{
+			name:        "invalid item: error outcome",
+			item:        invalidItem,
+			wantOutcome: repository.OutcomeError,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			defer suite.deleteAll()
+
+			metrics := &fakeMetrics{}
+			repo, err := repository.NewCart(suite.pool, repository.WithMetrics(metrics))
+			require.NoError(t, err)
+
+			_ = repo.AddItem(t.Context(), gofakeit.UUID(), tt.item)
+
+			require.Len(t, metrics.calls, 1)
+			assert.Equal(t, "AddItem", metrics.calls[0].method)
+			assert.Equal(t, tt.wantOutcome, metrics.calls[0].outcome)
+			assert.Positive(t, metrics.calls[0].duration)
+		})
+	}
+}
+
+type metricsCall struct {
+	method   string
+	outcome  string
+	duration time.Duration
+}
+
+type fakeMetrics struct {
+	mu    sync.Mutex
+	calls []metricsCall
+}
+
+func (m *fakeMetrics) RecordCall(method, outcome string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = append(m.calls, metricsCall{method: method, outcome: outcome, duration: duration})
+}
+
+func (suite *cartRepositorySuite) TestObserver() {
+	defer suite.deleteAll()
+
+	invalidItem := randomCartItem()
+	invalidItem.Quantity = 0
+
+	suite.Run("added and deleted items: notified", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		repo, err := repository.NewCart(suite.pool, repository.WithObserver(observer))
+		require.NoError(t, err)
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, repo.AddItem(ctx, ownerID, item))
+		require.NoError(t, repo.AddItemIdempotent(ctx, ownerID, item, "key"))
+		// the key is already used, nothing is added
+		require.NoError(t, repo.AddItemIdempotent(ctx, ownerID, item, "key"))
+		_, err = repo.DeleteItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"added", "added", "deleted"}, observer.recorded())
+		assertCartItem(t, item, observer.added[0])
+		assertCartItem(t, item, observer.added[1])
+		assert.Equal(t, []uuid.UUID{item.ProductID}, observer.deleted)
+	})
+
+	suite.Run("other changes: notified of the cart", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		repo, err := repository.NewCart(suite.pool, repository.WithObserver(observer))
+		require.NoError(t, err)
+
+		ownerID := gofakeit.UUID()
+		otherOwnerID := gofakeit.UUID()
+		item := randomCartItem()
+		cur := item.Price.Currency
+		desired := item
+		desired.Quantity++
+
+		steps := []func() error{
+			func() error { return repo.SetItem(ctx, ownerID, item) },
+			func() error { return repo.ImportItem(ctx, ownerID, randomCartItem()) },
+			func() error {
+				_, err := repo.UpdateItemPrice(ctx, ownerID, item.ProductID, domain.Money{Amount: decimal.NewFromInt(5), Currency: cur})
+				return err
+			},
+			func() error {
+				_, err := repo.ApplyPriceFactor(ctx, ownerID, cur, decimal.NewFromInt(2))
+				return err
+			},
+			func() error {
+				_, err := repo.DeleteItems(ctx, ownerID, []uuid.UUID{item.ProductID})
+				return err
+			},
+			func() error { return repo.RestoreItem(ctx, ownerID, item.ProductID) },
+			func() error {
+				_, err := repo.ClearCurrency(ctx, ownerID, cur)
+				return err
+			},
+			func() error { return repo.ReplaceCart(ctx, ownerID, []domain.CartItem{item}) },
+			func() error {
+				plan, err := repo.PlanSync(ctx, ownerID, []domain.CartItem{desired})
+				if err != nil {
+					return err
+				}
+				return repo.ApplySync(ctx, ownerID, plan)
+			},
+			func() error {
+				_, err := repo.MoveItems(ctx, ownerID, otherOwnerID)
+				return err
+			},
+			func() error {
+				_, err := repo.ClearCart(ctx, otherOwnerID)
+				return err
+			},
+		}
+		for _, step := range steps {
+			require.NoError(t, step())
+		}
+
+		wantOwners := slices.Repeat([]string{ownerID}, 9)
+		wantOwners = append(wantOwners, ownerID, otherOwnerID, otherOwnerID)
+		assert.Equal(t, wantOwners, observer.changedOwners())
+		assert.Equal(t, slices.Repeat([]string{"changed"}, len(wantOwners)), observer.recorded())
+
+		// nothing left to change
+		_, err = repo.ClearCart(ctx, otherOwnerID)
+		require.NoError(t, err)
+		_, err = repo.DeleteItems(ctx, ownerID, []uuid.UUID{item.ProductID})
+		require.NoError(t, err)
+		assert.Len(t, observer.changedOwners(), len(wantOwners))
+	})
+
+	suite.Run("purged carts: notified once per owner", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		repo, err := repository.NewCart(suite.pool, repository.WithObserver(observer))
+		require.NoError(t, err)
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, repo.AddItem(ctx, ownerID, randomCartItem()))
+		require.NoError(t, repo.AddItem(ctx, ownerID, randomCartItem()))
+
+		purged, err := repo.PurgeCartsOlderThan(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, int64(2))
+
+		changed := observer.changedOwners()
+		assert.Contains(t, changed, ownerID)
+		assert.Len(t, slices.Compact(slices.Sorted(slices.Values(changed))), len(changed))
+	})
+
+	suite.Run("failed calls: not notified", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		repo, err := repository.NewCart(suite.pool, repository.WithObserver(observer))
+		require.NoError(t, err)
+
+		ownerID := gofakeit.UUID()
+		require.Error(t, repo.AddItem(ctx, ownerID, invalidItem))
+		_, err = repo.DeleteItem(ctx, ownerID, uuid.New())
+		require.ErrorIs(t, err, domain.ErrItemNotFound)
+
+		assert.Empty(t, observer.recorded())
+	})
+
+	suite.Run("RunInTx committed: notified after commit", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		item := randomCartItem()
+
+		err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+			if err := repo.AddItem(ctx, gofakeit.UUID(), item); err != nil {
+				return err
+			}
+			assert.Empty(t, observer.recorded())
+			return nil
+		}, repository.WithObserver(observer))
+		require.NoError(t, err)
+
+		require.Equal(t, []string{"added"}, observer.recorded())
+		assertCartItem(t, item, observer.added[0])
+	})
+
+	suite.Run("RunInTx rolled back: not notified", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		observer := &recordingObserver{}
+		errCallback := errors.New("callback failed")
+
+		err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+			if err := repo.AddItem(ctx, gofakeit.UUID(), randomCartItem()); err != nil {
+				return err
+			}
+			return errCallback
+		}, repository.WithObserver(observer))
+		require.ErrorIs(t, err, errCallback)
+
+		assert.Empty(t, observer.recorded())
+	})
+}
+
+// recordingObserver records the notifications in the order they are received.
+type recordingObserver struct {
+	mu      sync.Mutex
+	events  []string
+	added   []domain.CartItem
+	deleted []uuid.UUID
+	changed []string
+}
+
+func (o *recordingObserver) OnItemAdded(_ string, item domain.CartItem) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, "added")
+	o.added = append(o.added, item)
+}
+
+func (o *recordingObserver) OnItemDeleted(_ string, productID uuid.UUID) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, "deleted")
+	o.deleted = append(o.deleted, productID)
+}
+
+func (o *recordingObserver) OnCartChanged(ownerID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.events = append(o.events, "changed")
+	o.changed = append(o.changed, ownerID)
+}
+
+func (o *recordingObserver) changedOwners() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return slices.Clone(o.changed)
+}
+
+func (o *recordingObserver) recorded() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return slices.Clone(o.events)
+}
+
+func (suite *cartRepositorySuite) TestSync() {
+	defer suite.deleteAll()
+
+	suite.Run("plan and apply: stored cart matches desired", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		kept, repriced, removed := randomCartItem(), randomCartItem(), randomCartItem()
+		for _, item := range []domain.CartItem{kept, repriced, removed} {
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+		}
+
+		repriced.Price.Amount = repriced.Price.Amount.Add(decimal.NewFromInt(1))
+		repriced.Quantity = 3 // replaces the stored quantity instead of being added to it
+		added := randomCartItem()
+		desired := []domain.CartItem{kept, repriced, added}
+
+		plan, err := suite.repo.PlanSync(ctx, ownerID, desired)
+		require.NoError(t, err)
+		assert.Equal(t, []domain.CartItem{added}, plan.Adds)
+		assert.Equal(t, []domain.CartItem{repriced}, plan.Updates)
+		assert.Equal(t, []uuid.UUID{removed.ProductID}, plan.Deletes)
+
+		// planning does not change the stored cart
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 3)
+
+		require.NoError(t, suite.repo.ApplySync(ctx, ownerID, plan))
+
+		cart, err = suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		assert.True(t, domain.CartsEqual(domain.Cart{Items: desired}, cart))
+
+		plan, err = suite.repo.PlanSync(ctx, ownerID, desired)
+		require.NoError(t, err)
+		assert.True(t, plan.IsEmpty())
+	})
+
+	suite.Run("desired item without currency: error", func() {
+		t := suite.T()
+
+		item := randomCartItem()
+		item.Price.Currency = currency.Unit{}
+
+		_, err := suite.repo.PlanSync(t.Context(), gofakeit.UUID(), []domain.CartItem{item})
+		require.EqualError(t, err, "desired[0] currency is empty")
+	})
+}
+
+func (suite *cartRepositorySuite) TestReplaceCart() {
+	defer suite.deleteAll()
+
+	duplicate := randomCartItem()
+
+	tests := []struct {
+		name      string
+		items     func(stored []domain.CartItem) []domain.CartItem
+		wantError string
+	}{
+		{
+			name: "three items replaced by two: ok",
+			items: func(stored []domain.CartItem) []domain.CartItem {
+				kept := stored[0]
+				kept.Quantity = 2 // replaces the stored quantity instead of being added to it
+				return []domain.CartItem{kept, randomCartItem()}
+			},
+		},
+		{
+			name: "no items: cart cleared",
+			items: func([]domain.CartItem) []domain.CartItem {
+				return nil
+			},
+		},
+		{
+			name: "duplicated product: error, cart unchanged",
+			items: func([]domain.CartItem) []domain.CartItem {
+				return []domain.CartItem{duplicate, randomCartItem(), duplicate}
+			},
+			wantError: fmt.Sprintf("product[%s] is duplicated", duplicate.ProductID),
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			stored := []domain.CartItem{randomCartItem(), randomCartItem(), randomCartItem()}
+			for _, item := range stored {
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+			}
+
+			items := tt.items(stored)
+			wantItems := items
+
+			err := suite.repo.ReplaceCart(ctx, ownerID, items)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				wantItems = stored
+			} else {
+				require.NoError(t, err)
+			}
+
+			cart, err := suite.repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+			assert.True(t, domain.CartsEqual(domain.Cart{Items: wantItems}, cart))
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestRunInTx() {
+	defer suite.deleteAll()
+
+	errCallback := errors.New("callback failed")
+
+	tests := []struct {
+		name        string
+		callbackErr error
+		wantApplied bool
+	}{
+		{
+			name:        "callback succeeds: both mutations committed",
+			wantApplied: true,
+		},
+		{
+			name:        "callback fails: both mutations rolled back",
+			callbackErr: errCallback,
+			wantApplied: false,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			removed, added := randomCartItem(), randomCartItem()
+			require.NoError(t, suite.repo.AddItem(ctx, ownerID, removed))
+
+			err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+				deleted, err := repo.DeleteItem(ctx, ownerID, removed.ProductID)
+				if err != nil {
+					return err
+				}
+				require.True(t, deleted)
+
+				if err := repo.AddItem(ctx, ownerID, added); err != nil {
+					return err
+				}
+
+				return tt.callbackErr
+			})
+			if tt.callbackErr != nil {
+				require.ErrorIs(t, err, tt.callbackErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			want := removed
+			if tt.wantApplied {
+				want = added
+			}
+
+			cart, err := suite.repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+			require.Len(t, cart.Items, 1)
+			assertCartItem(t, want, cart.Items[0])
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestTxOptions() {
+	defer suite.deleteAll()
+
+	readOnly := repository.WithTxOptions(pgx.TxOptions{AccessMode: pgx.ReadOnly})
+
+	suite.Run("write in read-only RunInTx: error", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+
+		err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+			if _, err := repo.CountItems(ctx, ownerID); err != nil {
+				return err
+			}
+			return repo.AddItem(ctx, ownerID, randomCartItem())
+		}, readOnly)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, "25006", pgErr.Code) // read_only_sql_transaction
+
+		count, err := suite.repo.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	suite.Run("write in read-only method transaction: error", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		repo, err := repository.NewCart(suite.pool, readOnly)
+		require.NoError(t, err)
+
+		err = repo.AddItemIdempotent(ctx, gofakeit.UUID(), randomCartItem(), "key")
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, "25006", pgErr.Code)
+	})
+
+	suite.Run("serializable RunInTx: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+
+		err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+			if _, err := repo.GetCartTotal(ctx, ownerID); err != nil {
+				return err
+			}
+			return repo.AddItemIdempotent(ctx, ownerID, item, "key")
+		}, repository.WithTxOptions(pgx.TxOptions{IsoLevel: pgx.Serializable}))
+		require.NoError(t, err)
+
+		got, err := suite.repo.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+	})
+}
+
+func (suite *cartRepositorySuite) TestCartName() {
+	defer suite.deleteAll()
+
+	_, err := repository.NewCart(suite.pool, repository.WithCartName(""))
+	suite.Require().EqualError(err, "cartName is empty")
+
+	partyRepo, err := repository.NewCart(suite.pool, repository.WithCartName("party"))
+	suite.Require().NoError(err)
+
+	suite.Run("named carts: isolated", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		defaultItem := randomCartItem()
+		partyItem := randomCartItem()
+
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, defaultItem))
+		require.NoError(t, partyRepo.AddItem(ctx, ownerID, partyItem))
+
+		// the same product in both carts does not conflict
+		require.NoError(t, partyRepo.AddItem(ctx, ownerID, defaultItem))
+
+		cart, err := suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, defaultItem, cart.Items[0])
+
+		cart, err = partyRepo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 2)
+
+		deleted, err := partyRepo.DeleteItem(ctx, ownerID, defaultItem.ProductID)
+		require.NoError(t, err)
+		require.True(t, deleted)
+
+		cart, err = suite.repo.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+	})
+
+	suite.Run("list cart names: sorted", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+
+		names, err := suite.repo.ListCartNames(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Empty(t, names)
+
+		require.NoError(t, partyRepo.AddItem(ctx, ownerID, randomCartItem()))
+		require.NoError(t, suite.repo.AddItem(ctx, ownerID, randomCartItem()))
+
+		names, err = partyRepo.ListCartNames(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{repository.DefaultCartName, "party"}, names)
+	})
+}
+
+func (suite *cartRepositorySuite) TestOwnerCurrency() {
+	defer suite.deleteAll()
+
+	t := suite.T()
+	ctx := t.Context()
+
+	ownerID := gofakeit.UUID()
+
+	_, found, err := suite.repo.GetOwnerCurrency(ctx, ownerID)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	err = suite.repo.SetOwnerCurrency(ctx, ownerID, currency.Unit{})
+	require.EqualError(t, err, "currency is empty")
+
+	require.NoError(t, suite.repo.SetOwnerCurrency(ctx, ownerID, currency.USD))
+	require.NoError(t, suite.repo.SetOwnerCurrency(ctx, ownerID, currency.EUR))
+
+	cur, found, err := suite.repo.GetOwnerCurrency(ctx, ownerID)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, currency.EUR, cur)
+}
+
+func (suite *cartRepositorySuite) TestAddItemDefaultCurrency() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name            string
+		defaultCurrency *currency.Unit
+		itemCurrency    currency.Unit
+		wantCurrency    currency.Unit
+		wantError       string
+	}{
+		{
+			name:            "empty currency with owner default: default used",
+			defaultCurrency: &currency.EUR,
+			wantCurrency:    currency.EUR,
+		},
+		{
+			name:            "explicit currency with owner default: explicit used",
+			defaultCurrency: &currency.EUR,
+			itemCurrency:    currency.USD,
+			wantCurrency:    currency.USD,
+		},
+		{
+			name:      "empty currency without owner default: error",
+			wantError: "item currency is empty and owner has no default currency",
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			if tt.defaultCurrency != nil {
+				require.NoError(t, suite.repo.SetOwnerCurrency(ctx, ownerID, *tt.defaultCurrency))
+			}
+
+			item := randomCartItem()
+			item.Price.Currency = tt.itemCurrency
+
+			err := suite.repo.AddItem(ctx, ownerID, item)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				return
+			}
+			require.NoError(t, err)
+
+			cart, err := suite.repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+			require.Len(t, cart.Items, 1)
+
+			item.Price.Currency = tt.wantCurrency
+			assertCartItem(t, item, cart.Items[0])
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestGetCartEnriched() {
+	defer suite.deleteAll()
+
+	errCatalog := errors.New("catalog is down")
+
+	tests := []struct {
+		name       string
+		itemCount  int
+		unknown    int // how many of the cart products the loader has no data for
+		loaderErr  error
+		wantCalled bool
+		wantError  string
+		wantTarget error
+	}{
+		{
+			name:       "never used cart: not found, loader not called",
+			itemCount:  0,
+			wantCalled: false,
+			wantError:  "repo.GetCart: q.CartKnown: cart not found",
+			wantTarget: domain.ErrCartNotFound,
+		},
+		{
+			name:       "all products loaded: ok",
+			itemCount:  3,
+			wantCalled: true,
+		},
+		{
+			name:       "some products unknown: zero data",
+			itemCount:  3,
+			unknown:    1,
+			wantCalled: true,
+		},
+		{
+			name:       "loader fails: error",
+			itemCount:  2,
+			loaderErr:  errCatalog,
+			wantCalled: true,
+			wantError:  "loader: catalog is down",
+			wantTarget: errCatalog,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			ownerID := gofakeit.UUID()
+			var productIDs []uuid.UUID
+			for i := 0; i < tt.itemCount; i++ {
+				item := randomCartItem()
+				require.NoError(t, suite.repo.AddItem(ctx, ownerID, item))
+				productIDs = append(productIDs, item.ProductID)
+			}
+
+			names := make(map[uuid.UUID]string, len(productIDs))
+			for _, id := range productIDs[tt.unknown:] {
+				names[id] = gofakeit.ProductName()
+			}
+
+			var called bool
+			enriched, err := repository.GetCartEnriched(ctx, suite.repo, ownerID, func(_ context.Context, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+				called = true
+				assert.ElementsMatch(t, productIDs, ids)
+
+				if tt.loaderErr != nil {
+					return nil, tt.loaderErr
+				}
+				return names, nil
+			})
+			require.Equal(t, tt.wantCalled, called)
+			if tt.wantError != "" {
+				require.EqualError(t, err, tt.wantError)
+				require.ErrorIs(t, err, tt.wantTarget)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Len(t, enriched, tt.itemCount)
+			for _, e := range enriched {
+				assert.Equal(t, names[e.Item.ProductID], e.Data)
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) TestHealthCheck() {
+	suite.Run("connected: ok", func() {
+		t := suite.T()
+
+		require.NoError(t, suite.repo.HealthCheck(t.Context()))
+	})
+
+	suite.Run("canceled context: error", func() {
+		t := suite.T()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		err := suite.repo.HealthCheck(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	suite.Run("closed pool: error", func() {
+		t := suite.T()
+
+		pool, err := pgxpool.NewWithConfig(t.Context(), suite.pool.Config())
+		require.NoError(t, err)
+
+		repo, err := repository.NewCart(pool)
+		require.NoError(t, err)
+		require.NoError(t, repo.HealthCheck(t.Context()))
+
+		pool.Close()
+
+		require.Error(t, repo.HealthCheck(t.Context()))
+	})
+}
+
+func (suite *cartRepositorySuite) TestShutdown() {
+	defer suite.deleteAll()
+
+	// each case shuts its own pool down, the suite pool stays open
+	newRepo := func(t *testing.T) (port.CartRepository, *pgxpool.Pool) {
+		t.Helper()
+
+		pool, err := pgxpool.NewWithConfig(t.Context(), suite.pool.Config())
+		require.NoError(t, err)
+		t.Cleanup(pool.Close)
+
+		repo, err := repository.NewCart(pool)
+		require.NoError(t, err)
+
+		return repo, pool
+	}
+
+	// blockStream starts StreamCart with a callback which blocks until release is closed
+	blockStream := func(t *testing.T, repo port.CartRepository) (chan<- struct{}, <-chan error) {
+		t.Helper()
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, suite.repo.AddItem(t.Context(), ownerID, randomCartItem()))
+
+		started, release := make(chan struct{}), make(chan struct{})
+		streamErr := make(chan error, 1)
+		go func() {
+			streamErr <- repo.StreamCart(t.Context(), ownerID, func(domain.CartItem) error {
+				close(started)
+				<-release
+				return nil
+			})
+		}()
+		<-started
+
+		return release, streamErr
+	}
+
+	suite.Run("in-flight call: wait and close pool", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		repo, pool := newRepo(t)
+		release, streamErr := blockStream(t, repo)
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- repo.Shutdown(ctx)
+		}()
+
+		require.Eventually(t, func() bool {
+			_, err := repo.GetCart(ctx, gofakeit.UUID())
+			return errors.Is(err, repository.ErrShuttingDown)
+		}, time.Second, 10*time.Millisecond)
+
+		select {
+		case err := <-shutdownErr:
+			require.FailNow(t, "shutdown returned before the in-flight call finished", "err: %v", err)
+		default:
+		}
+
+		close(release)
+		require.NoError(t, <-streamErr)
+		require.NoError(t, <-shutdownErr)
+
+		assert.Error(t, pool.Ping(ctx))
+	})
+
+	suite.Run("context done before drained: error and pool open", func() {
+		t := suite.T()
+
+		repo, pool := newRepo(t)
+		release, streamErr := blockStream(t, repo)
+
+		ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+		defer cancel()
+
+		err := repo.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		assert.NoError(t, pool.Ping(t.Context()))
+
+		close(release)
+		require.NoError(t, <-streamErr)
+	})
+
+	suite.Run("owned pool: later queries fail", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		repo, pool := newRepo(t)
+		require.NoError(t, repo.Shutdown(ctx))
+
+		_, err := repo.GetCart(ctx, gofakeit.UUID())
+		require.ErrorIs(t, err, repository.ErrShuttingDown)
+
+		// the pool itself is closed, not only the repository
+		other, err := repository.NewCart(pool)
+		require.NoError(t, err)
+		_, err = other.GetCart(ctx, gofakeit.UUID())
+		assert.Error(t, err)
+	})
+
+	suite.Run("bound to a transaction: transaction left open", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		tx, err := suite.pool.Begin(ctx)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, tx.Rollback(ctx))
+		}()
+
+		repo, err := repository.NewCart(tx)
+		require.NoError(t, err)
+		require.NoError(t, repo.Shutdown(ctx))
+
+		_, err = tx.Exec(ctx, "SELECT 1")
+		assert.NoError(t, err)
+	})
+}
+
+func (suite *cartRepositorySuite) TestPoolExhaustedError() {
+	defer suite.deleteAll()
+
+	config := suite.pool.Config()
+	config.MaxConns = 1
+
+	pool, err := pgxpool.NewWithConfig(suite.T().Context(), config)
+	suite.Require().NoError(err)
+	defer pool.Close()
+
+	repo, err := repository.NewCart(pool, repository.WithPoolExhaustedError())
+	suite.Require().NoError(err)
+
+	plainRepo, err := repository.NewCart(pool)
+	suite.Require().NoError(err)
+
+	tests := []struct {
+		name              string
+		repo              port.CartRepository
+		holdConn          bool
+		cancelBefore      bool
+		wantPoolExhausted bool
+		wantTarget        error
+	}{
+		{
+			name:              "no connection available: pool exhausted",
+			repo:              repo,
+			holdConn:          true,
+			wantPoolExhausted: true,
+			wantTarget:        context.DeadlineExceeded,
+		},
+		{
+			name:       "no connection available without option: context error",
+			repo:       plainRepo,
+			holdConn:   true,
+			wantTarget: context.DeadlineExceeded,
+		},
+		{
+			name:         "context canceled before call: context error",
+			repo:         repo,
+			cancelBefore: true,
+			wantTarget:   context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			if tt.holdConn {
+				conn, err := pool.Acquire(t.Context())
+				require.NoError(t, err)
+				defer conn.Release()
+			}
+
+			// GetCart goes through Query and ItemAddedAt through QueryRow
+			calls := []func(ctx context.Context) error{
+				func(ctx context.Context) error {
+					_, err := tt.repo.GetCart(ctx, gofakeit.UUID())
+					return err
+				},
+				func(ctx context.Context) error {
+					_, err := tt.repo.ItemAddedAt(ctx, gofakeit.UUID(), uuid.New())
+					return err
+				},
+			}
+
+			for _, call := range calls {
+				ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+				if tt.cancelBefore {
+					cancel()
+				}
+
+				err := call(ctx)
+				cancel()
+
+				require.ErrorIs(t, err, tt.wantTarget)
+				assert.Equal(t, tt.wantPoolExhausted, errors.Is(err, repository.ErrPoolExhausted))
+			}
+		})
+	}
+}
+
+func (suite *cartRepositorySuite) deleteAll() {
+	_, err := suite.pool.Exec(suite.T().Context(), "TRUNCATE TABLE cart_items, owner_settings, cart_item_idempotency CASCADE")
+	suite.NoError(err)
+}
+
+func (suite *cartRepositorySuite) TestQueryTimeout() {
+	defer suite.deleteAll()
+
+	tests := []struct {
+		name         string
+		queryTimeout time.Duration
+		ctxTimeout   time.Duration
+		lockTable    bool
+		wantTarget   error
+	}{
+		{
+			name:         "blocked query: query timeout fires",
+			queryTimeout: 100 * time.Millisecond,
+			lockTable:    true,
+			wantTarget:   context.DeadlineExceeded,
+		},
+		{
+			name:         "blocked query with earlier caller deadline: caller deadline wins",
+			queryTimeout: time.Minute,
+			ctxTimeout:   100 * time.Millisecond,
+			lockTable:    true,
+			wantTarget:   context.DeadlineExceeded,
+		},
+		{
+			name:         "fast query: ok",
+			queryTimeout: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+
+			repo, err := repository.NewCart(suite.pool, repository.WithQueryTimeout(tt.queryTimeout))
+			require.NoError(t, err)
+
+			if tt.lockTable {
+				// reads of cart_items block until the locking transaction ends
+				tx, err := suite.pool.Begin(t.Context())
+				require.NoError(t, err)
+				defer func() { _ = tx.Rollback(context.Background()) }()
+
+				_, err = tx.Exec(t.Context(), "LOCK TABLE cart_items IN ACCESS EXCLUSIVE MODE")
+				require.NoError(t, err)
+			}
+
+			ctx := t.Context()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+
+			start := time.Now()
+			_, err = repo.CountItems(ctx, gofakeit.UUID())
+			if tt.wantTarget != nil {
+				require.ErrorIs(t, err, tt.wantTarget)
+				assert.Less(t, time.Since(start), 10*time.Second)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+
+	suite.Run("negative timeout: error", func() {
+		_, err := repository.NewCart(suite.pool, repository.WithQueryTimeout(-time.Second))
+		suite.Require().EqualError(err, "queryTimeout[-1s] is negative")
+	})
+}
+
+func (suite *cartRepositorySuite) TestMemoryParity() {
+	defer suite.deleteAll()
+
+	item := randomCartItem()
+
+	noCurrency := randomCartItem()
+	noCurrency.Price.Currency = currency.Unit{}
+
+	otherCurrency := randomCartItem()
+	otherCurrency.Price.Currency = currency.USD
+	if item.Price.Currency == currency.USD {
+		otherCurrency.Price.Currency = currency.EUR
+	}
+
+	tests := []struct {
+		name string
+		// run returns the error of its last call, the carts are compared after it
+		run func(ctx context.Context, repo port.CartRepository, ownerID string) error
+	}{
+		{
+			name: "add item: ok",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				return repo.AddItem(ctx, ownerID, item)
+			},
+		},
+		{
+			name: "add item twice: quantities summed",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				return repo.AddItem(ctx, ownerID, item)
+			},
+		},
+		{
+			name: "add item with nil product ID: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				invalid := item
+				invalid.ProductID = uuid.Nil
+				return repo.AddItem(ctx, ownerID, invalid)
+			},
+		},
+		{
+			name: "add item without currency and owner default: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				return repo.AddItem(ctx, ownerID, noCurrency)
+			},
+		},
+		{
+			name: "add item without currency with owner default: ok",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.SetOwnerCurrency(ctx, ownerID, currency.EUR); err != nil {
+					return err
+				}
+				return repo.AddItem(ctx, ownerID, noCurrency)
+			},
+		},
+		{
+			name: "add item idempotent twice: added once",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItemIdempotent(ctx, ownerID, item, "key"); err != nil {
+					return err
+				}
+				return repo.AddItemIdempotent(ctx, ownerID, item, "key")
+			},
+		},
+		{
+			name: "get item of empty owner: error",
+			run: func(ctx context.Context, repo port.CartRepository, _ string) error {
+				_, err := repo.GetItem(ctx, "", item.ProductID)
+				return err
+			},
+		},
+		{
+			name: "get item with nil product ID: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				_, err := repo.GetItem(ctx, ownerID, uuid.Nil)
+				return err
+			},
+		},
+		{
+			name: "get missing item: not found",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				_, err := repo.GetItem(ctx, ownerID, item.ProductID)
+				return err
+			},
+		},
+		{
+			name: "delete missing item: not found",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				_, err := repo.DeleteItem(ctx, ownerID, item.ProductID)
+				return err
+			},
+		},
+		{
+			name: "delete and add item again: quantity reset",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				for range 2 {
+					if err := repo.AddItem(ctx, ownerID, item); err != nil {
+						return err
+					}
+				}
+				if _, err := repo.DeleteItem(ctx, ownerID, item.ProductID); err != nil {
+					return err
+				}
+				return repo.AddItem(ctx, ownerID, item)
+			},
+		},
+		{
+			name: "delete and restore item: ok",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				if _, err := repo.DeleteItem(ctx, ownerID, item.ProductID); err != nil {
+					return err
+				}
+				return repo.RestoreItem(ctx, ownerID, item.ProductID)
+			},
+		},
+		{
+			name: "update missing item price: not found",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				_, err := repo.UpdateItemPrice(ctx, ownerID, item.ProductID, item.Price)
+				return err
+			},
+		},
+		{
+			name: "count items of empty owner: error",
+			run: func(ctx context.Context, repo port.CartRepository, _ string) error {
+				_, err := repo.CountItems(ctx, "")
+				return err
+			},
+		},
+		{
+			name: "count items of whitespace only owner: error",
+			run: func(ctx context.Context, repo port.CartRepository, _ string) error {
+				_, err := repo.CountItems(ctx, " \t ")
+				return err
+			},
+		},
+		{
+			name: "add item with two formats of owner: one cart",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, " "+ownerID+" ", item); err != nil {
+					return err
+				}
+				return repo.AddItem(ctx, ownerID, item)
+			},
+		},
+		{
+			name: "replace cart with duplicated product: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				return repo.ReplaceCart(ctx, ownerID, []domain.CartItem{item, item})
+			},
+		},
+		{
+			name: "total of mixed currencies: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(ctx, ownerID, otherCurrency); err != nil {
+					return err
+				}
+				_, err := repo.GetCartTotal(ctx, ownerID)
+				return err
+			},
+		},
+		{
+			name: "total excluding the other currency: ok",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(ctx, ownerID, otherCurrency); err != nil {
+					return err
+				}
+				_, err := repo.TotalExcluding(ctx, ownerID, []uuid.UUID{otherCurrency.ProductID})
+				return err
+			},
+		},
+		{
+			name: "move items onto existing item: quantities summed",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				fromOwnerID := ownerID + "-from"
+				if err := repo.AddItem(ctx, fromOwnerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				_, err := repo.MoveItems(ctx, fromOwnerID, ownerID)
+				return err
+			},
+		},
+		{
+			name: "add or remove item with zero quantity twice: deleted",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				removed := item
+				removed.Quantity = 0
+				if err := repo.AddOrRemoveItem(ctx, ownerID, removed); err != nil {
+					return err
+				}
+				return repo.AddOrRemoveItem(ctx, ownerID, removed)
+			},
+		},
+		{
+			name: "swap quantities with missing product: not found",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				return repo.SwapQuantities(ctx, ownerID, uuid.New(), item.ProductID)
+			},
+		},
+		{
+			name: "apply quantity delta below zero: error",
+			run: func(ctx context.Context, repo port.CartRepository, ownerID string) error {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+				return repo.ApplyQuantityDeltas(ctx, ownerID, map[uuid.UUID]int32{item.ProductID: -item.Quantity - 1})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		suite.Run(tt.name, func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			mem := memory.NewMemoryCart()
+			ownerID := gofakeit.UUID()
+
+			wantErr := tt.run(ctx, suite.repo, ownerID)
+			gotErr := tt.run(ctx, mem, ownerID)
+			assertSameError(t, wantErr, gotErr)
+
+			// a cart never used is not found in both
+			want, wantErr := suite.repo.GetCart(ctx, ownerID)
+			got, gotErr := mem.GetCart(ctx, ownerID)
+			assertSameError(t, wantErr, gotErr)
+			assert.True(t, domain.CartsEqual(want, got), "want %v, got %v", want.Items, got.Items)
+		})
+	}
+}
+
+func TestValidateAddItem(t *testing.T) {
+	// the pool connects lazily and nothing listens on the port, so any query would fail
+	pool, err := pgxpool.New(t.Context(), "postgres://localhost:1/cart")
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	repo, err := repository.NewCart(pool, repository.WithV4ProductIDs())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		modify    func(item *domain.CartItem)
+		wantError string
+	}{
+		{
+			name:   "valid item: no query",
+			modify: func(*domain.CartItem) {},
+		},
+		{
+			name:      "nil product ID: error",
+			modify:    func(item *domain.CartItem) { item.ProductID = uuid.Nil },
+			wantError: "productID[00000000-0000-0000-0000-000000000000] is not a v4 UUID",
+		},
+		{
+			name:      "v1 product ID: error",
+			modify:    func(item *domain.CartItem) { item.ProductID = uuid.Must(uuid.NewUUID()) },
+			wantError: "is not a v4 UUID",
+		},
+		{
+			name:      "zero quantity: error",
+			modify:    func(item *domain.CartItem) { item.Quantity = 0 },
+			wantError: "quantity[0] is not positive",
+		},
+		{
+			name:      "negative price: error",
+			modify:    func(item *domain.CartItem) { item.Price.Amount = decimal.RequireFromString("-1") },
+			wantError: "price amount[-1] is not positive",
+		},
+		{
+			name:      "zero price and quantity: both errors",
+			modify:    func(item *domain.CartItem) { item.Price.Amount, item.Quantity = decimal.Zero, 0 },
+			wantError: "quantity[0] is not positive\nprice amount[0] is not positive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := randomCartItem()
+			tt.modify(&item)
+
+			err := repo.ValidateAddItem(t.Context(), gofakeit.UUID(), item)
+			if tt.wantError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantError)
+
+			// the write path fails the same way before reaching the database
+			addErr := repo.AddItem(t.Context(), gofakeit.UUID(), item)
+			assert.EqualError(t, addErr, err.Error())
+		})
+	}
+}
+
+// assertSameError checks that both errors wrap the same domain error or have the same message otherwise.
+func assertSameError(t *testing.T, expected, actual error) {
+	t.Helper()
+
+	if expected == nil {
+		assert.NoError(t, actual)
+		return
+	}
+
+	for _, target := range []error{domain.ErrItemNotFound, domain.ErrCartNotFound, domain.ErrEmptyCart, domain.ErrMixedCurrency} {
+		if errors.Is(expected, target) {
+			assert.ErrorIs(t, actual, target)
+			return
+		}
+	}
+
+	assert.EqualError(t, actual, expected.Error())
 }
 
 func randomCartItem() domain.CartItem {
@@ -285,6 +4798,7 @@ func randomCartItem() domain.CartItem {
 			Amount:   decimal.NewFromFloat(price),
 			Currency: currencyUnit,
 		},
+		Quantity: 1,
 	}
 }
 
@@ -312,8 +4826,9 @@ func assertCartItem(t *testing.T, expected, actual domain.CartItem) {
 		return x.String() == y.String()
 	})
 
+	// only GetItem reads the version, the tests needing it check it explicitly
 	opts := cmp.Options{
-		cmpopts.IgnoreFields(domain.CartItem{}, "CreatedAt"),
+		cmpopts.IgnoreFields(domain.CartItem{}, "CreatedAt", "Version"),
 		currencyComparer,
 	}
 
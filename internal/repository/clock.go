@@ -0,0 +1,18 @@
+package repository
+
+import "time"
+
+// Clock provides the current time for cutoffs computed in the application, e.g. the circuit breaker timeouts,
+// and for the timestamps written by the repository, see WithClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock used when none is configured.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
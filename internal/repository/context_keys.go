@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ContextKey names a request-scoped value of the call context, e.g. a request ID put there by a middleware,
+// Key being the key passed to context.WithValue.
+type ContextKey struct {
+	Name string
+	Key  any
+}
+
+// contextHandler adds the values of the context keys found in the context to every record.
+type contextHandler struct {
+	slog.Handler
+	keys []ContextKey
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	for _, key := range h.keys {
+		if value := ctx.Value(key.Key); value != nil {
+			record.AddAttrs(slog.Any(key.Name, value))
+		}
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs), keys: h.keys}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name), keys: h.keys}
+}
+
+// contextSpanAttrs formats the values of the context keys found in the context as span attributes.
+func contextSpanAttrs(ctx context.Context, keys []ContextKey) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, key := range keys {
+		if value := ctx.Value(key.Key); value != nil {
+			attrs = append(attrs, attribute.String(key.Name, fmt.Sprint(value)))
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+)
+
+// execModeDBTX runs every query with the mode, pgx takes a QueryExecMode passed as the first query argument
+// instead of the default mode of the connection. Transactions started through it use the mode too.
+type execModeDBTX struct {
+	dbtx db.DBTX
+	mode pgx.QueryExecMode
+}
+
+func (d execModeDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return d.dbtx.Exec(ctx, sql, d.args(args)...)
+}
+
+func (d execModeDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return d.dbtx.Query(ctx, sql, d.args(args)...)
+}
+
+func (d execModeDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return d.dbtx.QueryRow(ctx, sql, d.args(args)...)
+}
+
+func (d execModeDBTX) args(args []any) []any {
+	return append([]any{d.mode}, args...)
+}
+
+func (d execModeDBTX) Begin(ctx context.Context) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transactions", d.dbtx)
+	}
+
+	tx, err := beginner.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return execModeTx{Tx: tx, mode: d.mode}, nil
+}
+
+func (d execModeDBTX) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transaction options", d.dbtx)
+	}
+
+	tx, err := beginner.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return execModeTx{Tx: tx, mode: d.mode}, nil
+}
+
+func (d execModeDBTX) Close() {
+	if pool, ok := d.dbtx.(interface{ Close() }); ok {
+		pool.Close()
+	}
+}
+
+// execModeTx overrides the query methods of the embedded transaction.
+type execModeTx struct {
+	pgx.Tx
+	mode pgx.QueryExecMode
+}
+
+func (t execModeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return execModeDBTX{dbtx: t.Tx, mode: t.mode}.Exec(ctx, sql, args...)
+}
+
+func (t execModeTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return execModeDBTX{dbtx: t.Tx, mode: t.mode}.Query(ctx, sql, args...)
+}
+
+func (t execModeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return execModeDBTX{dbtx: t.Tx, mode: t.mode}.QueryRow(ctx, sql, args...)
+}
+
+func (t execModeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return execModeDBTX{dbtx: t.Tx, mode: t.mode}.Begin(ctx)
+}
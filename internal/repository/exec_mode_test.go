@@ -0,0 +1,93 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+var queryExecModes = []pgx.QueryExecMode{
+	pgx.QueryExecModeCacheStatement,
+	pgx.QueryExecModeCacheDescribe,
+	pgx.QueryExecModeDescribeExec,
+	pgx.QueryExecModeExec,
+	pgx.QueryExecModeSimpleProtocol,
+}
+
+func (suite *cartRepositorySuite) TestQueryExecMode() {
+	defer suite.deleteAll()
+
+	for _, mode := range queryExecModes {
+		suite.Run(mode.String()+": same results", func() {
+			t := suite.T()
+			ctx := t.Context()
+
+			repo, err := repository.NewCart(suite.pool, repository.WithQueryExecMode(mode))
+			require.NoError(t, err)
+
+			ownerID := gofakeit.UUID()
+			item := randomCartItem()
+			require.NoError(t, repo.AddItem(ctx, ownerID, item))
+
+			// the owner's default currency is resolved in a transaction
+			require.NoError(t, repo.SetOwnerCurrency(ctx, ownerID, currency.EUR))
+			noCurrency := randomCartItem()
+			noCurrency.Price.Currency = currency.Unit{}
+			require.NoError(t, repo.AddItem(ctx, ownerID, noCurrency))
+			noCurrency.Price.Currency = currency.EUR
+
+			err = repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+				_, err := repo.DeleteItem(ctx, ownerID, item.ProductID)
+				return err
+			}, repository.WithQueryExecMode(mode))
+			require.NoError(t, err)
+
+			cart, err := repo.GetCart(ctx, ownerID)
+			require.NoError(t, err)
+			require.Len(t, cart.Items, 1)
+			assertCartItem(t, noCurrency, cart.Items[0])
+
+			_, err = repo.GetItem(ctx, ownerID, item.ProductID)
+			assert.ErrorIs(t, err, domain.ErrItemNotFound)
+		})
+	}
+}
+
+// BenchmarkAddItem compares the query exec modes, e.g. the prepared statements of pgx.QueryExecModeCacheStatement
+// against pgx.QueryExecModeExec parsing the query every time:
+//
+//	go test ./internal/repository -run '^$' -bench AddItem
+func BenchmarkAddItem(b *testing.B) {
+	ctx := b.Context()
+
+	_, connStr, err := startPostgres(ctx)
+	require.NoError(b, err)
+
+	pool, err := pgxpool.New(ctx, connStr)
+	require.NoError(b, err)
+	b.Cleanup(pool.Close)
+
+	for _, mode := range queryExecModes {
+		b.Run(mode.String(), func(b *testing.B) {
+			repo, err := repository.NewCart(pool, repository.WithQueryExecMode(mode))
+			require.NoError(b, err)
+
+			ownerID := gofakeit.UUID()
+			item := randomCartItem()
+
+			for b.Loop() {
+				if err := repo.AddItem(ctx, ownerID, item); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,1398 @@
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/shopspring/decimal"
+	"golang.org/x/text/currency"
+)
+
+type cartMemory struct {
+	mu      sync.RWMutex
+	closing bool
+	clock   repository.Clock
+
+	// items are keyed by owner and product, deleted ones are kept to be restored like in the database
+	items           map[string]map[uuid.UUID]*cartEntry
+	ownerCurrencies map[string]currency.Unit
+	idempotencyKeys map[string]map[string]bool
+}
+
+type cartEntry struct {
+	item    domain.CartItem
+	deleted bool
+	// version is kept apart from item, so only GetItem returns it like the database repository
+	version int32
+}
+
+// Option configures the CartRepository created by NewMemoryCart.
+type Option func(*options)
+
+type options struct {
+	lowerCaseOwnerIDs bool
+	clock             repository.Clock
+}
+
+// WithLowerCaseOwnerIDs also lower-cases the owner IDs like repository.WithLowerCaseOwnerIDs.
+func WithLowerCaseOwnerIDs() Option {
+	return func(o *options) {
+		o.lowerCaseOwnerIDs = true
+	}
+}
+
+// WithClock sets the clock of the item creation times like repository.WithClock, a nil clock keeps
+// repository.SystemClock.
+func WithClock(clock repository.Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// NewMemoryCart creates a CartRepository keeping the default cart of every owner in memory,
+// it validates and upserts items and normalizes owner IDs like the one created by repository.NewCart
+// with the same options.
+func NewMemoryCart(opts ...Option) port.CartRepository {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = repository.SystemClock
+	}
+
+	mem := &cartMemory{
+		clock:           o.clock,
+		items:           make(map[string]map[uuid.UUID]*cartEntry),
+		ownerCurrencies: make(map[string]currency.Unit),
+		idempotencyKeys: make(map[string]map[string]bool),
+	}
+
+	// it only fails for a nil inner repository
+	repo, _ := repository.NewCartWithNormalizedOwnerIDs(mem, o.lowerCaseOwnerIDs)
+	return repo
+}
+
+// Shutdown makes new calls fail with repository.ErrShuttingDown, there is nothing to release.
+func (r *cartMemory) Shutdown(_ context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closing = true
+
+	return nil
+}
+
+// HealthCheck only fails once the repository is shut down.
+func (r *cartMemory) HealthCheck(_ context.Context) error {
+	if err := r.rlock(); err != nil {
+		return err
+	}
+	defer r.mu.RUnlock()
+
+	return nil
+}
+
+// lock acquires the write lock which must be released with r.mu.Unlock.
+func (r *cartMemory) lock() error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return repository.ErrShuttingDown
+	}
+
+	return nil
+}
+
+// rlock acquires the read lock which must be released with r.mu.RUnlock.
+func (r *cartMemory) rlock() error {
+	r.mu.RLock()
+	if r.closing {
+		r.mu.RUnlock()
+		return repository.ErrShuttingDown
+	}
+
+	return nil
+}
+
+func (r *cartMemory) GetCart(ctx context.Context, ownerID string) (domain.Cart, error) {
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.Cart{}, err
+	}
+
+	if len(cart.Items) == 0 {
+		r.mu.RLock()
+		// deleted items are kept, so an owner without any entry never had the cart
+		_, known := r.items[ownerID]
+		r.mu.RUnlock()
+
+		if !known {
+			return domain.Cart{}, domain.ErrCartNotFound
+		}
+	}
+
+	return cart, nil
+}
+
+// getCart reads the cart like GetCart, returning an empty cart instead of domain.ErrCartNotFound.
+func (r *cartMemory) getCart(_ context.Context, ownerID string) (domain.Cart, error) {
+	if err := r.rlock(); err != nil {
+		return domain.Cart{}, err
+	}
+	defer r.mu.RUnlock()
+
+	return domain.Cart{
+		OwnerID: ownerID,
+		Items:   r.activeItems(ownerID),
+	}, nil
+}
+
+func (r *cartMemory) GetCartWithLineTotals(_ context.Context, ownerID string) ([]domain.CartLine, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	items := r.activeItems(ownerID)
+	lines := make([]domain.CartLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, domain.CartLine{Item: item, LineTotal: item.Subtotal()})
+	}
+
+	return lines, nil
+}
+
+func (r *cartMemory) GetCartAfter(_ context.Context, ownerID string, afterCreatedAt time.Time, afterProductID uuid.UUID, limit int32) ([]domain.CartItem, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	cursor := domain.CartItem{ProductID: afterProductID, CreatedAt: afterCreatedAt}
+
+	items := make([]domain.CartItem, 0)
+	for _, item := range r.activeItems(ownerID) {
+		if compareItems(item, cursor) <= 0 {
+			continue
+		}
+		if len(items) == int(limit) {
+			break
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (r *cartMemory) GetCartInRange(_ context.Context, ownerID string, from, to time.Time) ([]domain.CartItem, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return nil, fmt.Errorf("from[%s] is after to[%s]", from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano))
+	}
+
+	items := make([]domain.CartItem, 0)
+	for _, item := range r.activeItems(ownerID) {
+		if !from.IsZero() && item.CreatedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !item.CreatedAt.Before(to) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+func (r *cartMemory) GetCartsByOwners(_ context.Context, ownerIDs []string) (map[string]domain.Cart, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	carts := make(map[string]domain.Cart, len(ownerIDs))
+	for _, ownerID := range ownerIDs {
+		carts[ownerID] = domain.Cart{
+			OwnerID: ownerID,
+			Items:   r.activeItems(ownerID),
+		}
+	}
+
+	return carts, nil
+}
+
+func (r *cartMemory) GetRecentItems(_ context.Context, limit int32) ([]domain.OwnedCartItem, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	items := make([]domain.OwnedCartItem, 0)
+	for ownerID := range r.items {
+		for _, item := range r.activeItems(ownerID) {
+			items = append(items, domain.OwnedCartItem{OwnerID: ownerID, Item: item})
+		}
+	}
+
+	// newest first, ties broken by product ID like the query
+	slices.SortFunc(items, func(a, b domain.OwnedCartItem) int {
+		if c := b.Item.CreatedAt.Compare(a.Item.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.Item.ProductID[:], b.Item.ProductID[:])
+	})
+
+	return items[:min(len(items), int(limit))], nil
+}
+
+func (r *cartMemory) AddItem(_ context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	item, err := r.resolveItem(ownerID, item)
+	if err != nil {
+		return err
+	}
+
+	r.upsert(ownerID, item, false, r.now())
+
+	return nil
+}
+
+func (r *cartMemory) ValidateAddItem(_ context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.rlock(); err != nil {
+		return err
+	}
+	defer r.mu.RUnlock()
+
+	_, err := r.resolveItem(ownerID, item)
+	return err
+}
+
+func (r *cartMemory) SetItem(_ context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	if err := validateItem(item); err != nil {
+		return err
+	}
+
+	r.upsert(ownerID, item, true, r.now())
+
+	return nil
+}
+
+func (r *cartMemory) AddOrRemoveItem(_ context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	if item.Quantity != 0 {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+
+		r.upsert(ownerID, item, true, r.now())
+		return nil
+	}
+
+	if entry, ok := r.active(ownerID, item.ProductID); ok {
+		entry.deleted = true
+		entry.version++
+	}
+
+	return nil
+}
+
+func (r *cartMemory) ImportItem(_ context.Context, ownerID string, item domain.CartItem) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	if err := validateItem(item); err != nil {
+		return err
+	}
+
+	if item.CreatedAt.IsZero() {
+		r.upsert(ownerID, item, true, r.now())
+		return nil
+	}
+
+	createdAt := item.CreatedAt.UTC().Truncate(time.Microsecond)
+	r.upsert(ownerID, item, true, createdAt)
+
+	entry, _ := r.active(ownerID, item.ProductID)
+	entry.item.CreatedAt = createdAt
+
+	return nil
+}
+
+func (r *cartMemory) AddItemIdempotent(_ context.Context, ownerID string, item domain.CartItem, idempotencyKey string) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	if idempotencyKey == "" {
+		return fmt.Errorf("idempotencyKey is empty")
+	}
+	// an item without currency is validated once it is resolved
+	if item.Price.Currency != (currency.Unit{}) {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+	}
+
+	if r.idempotencyKeys[ownerID][idempotencyKey] {
+		return nil
+	}
+
+	// the key is only kept when the item is added, like the rolled back transaction of the database
+	item, err := r.resolveItem(ownerID, item)
+	if err != nil {
+		return err
+	}
+
+	if r.idempotencyKeys[ownerID] == nil {
+		r.idempotencyKeys[ownerID] = make(map[string]bool)
+	}
+	r.idempotencyKeys[ownerID][idempotencyKey] = true
+
+	r.upsert(ownerID, item, false, r.now())
+
+	return nil
+}
+
+func (r *cartMemory) AddItemReturning(_ context.Context, ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	if err := r.lock(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.mu.Unlock()
+
+	item, err := r.resolveItem(ownerID, item)
+	if err != nil {
+		return domain.CartItem{}, err
+	}
+
+	r.upsert(ownerID, item, false, r.now())
+
+	entry, _ := r.active(ownerID, item.ProductID)
+	return entry.item, nil
+}
+
+func (r *cartMemory) AddItemReturningPrevious(_ context.Context, ownerID string, item domain.CartItem) (*domain.CartItem, error) {
+	if err := r.lock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.Unlock()
+
+	item, err := r.resolveItem(ownerID, item)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *domain.CartItem
+	if entry, ok := r.active(ownerID, item.ProductID); ok {
+		stored := entry.item
+		previous = &stored
+	}
+
+	r.upsert(ownerID, item, false, r.now())
+
+	return previous, nil
+}
+
+// AddItemsBestEffort only returns an error when the context is done, along with the results of the items
+// attempted before it.
+func (r *cartMemory) AddItemsBestEffort(ctx context.Context, ownerID string, items []domain.CartItem) ([]domain.ItemResult, error) {
+	results := make([]domain.ItemResult, 0, len(items))
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return results, fmt.Errorf("ctx.Err: %w", err)
+		}
+
+		err := r.AddItem(ctx, ownerID, item)
+		if errors.Is(err, repository.ErrShuttingDown) {
+			return nil, err
+		}
+
+		results = append(results, domain.ItemResult{
+			ProductID: item.ProductID,
+			Err:       err,
+		})
+	}
+
+	return results, nil
+}
+
+func (r *cartMemory) GetItem(_ context.Context, ownerID string, productID uuid.UUID) (domain.CartItem, error) {
+	if err := r.rlock(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.mu.RUnlock()
+
+	if ownerID == "" {
+		return domain.CartItem{}, fmt.Errorf("ownerID is empty")
+	}
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return domain.CartItem{}, domain.ErrItemNotFound
+	}
+
+	item := entry.item
+	item.Version = entry.version
+	return item, nil
+}
+
+func (r *cartMemory) UpdateItemPrice(_ context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money) (bool, error) {
+	if err := r.lock(); err != nil {
+		return false, err
+	}
+	defer r.mu.Unlock()
+
+	if err := validatePrice(newPrice); err != nil {
+		return false, err
+	}
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return false, domain.ErrItemNotFound
+	}
+
+	entry.item.Price = newPrice
+	entry.version++
+
+	return true, nil
+}
+
+func (r *cartMemory) UpdateItemPriceIfVersion(_ context.Context, ownerID string, productID uuid.UUID, newPrice domain.Money, expectedVersion int32) (int32, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if err := validatePrice(newPrice); err != nil {
+		return 0, err
+	}
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return 0, domain.ErrItemNotFound
+	}
+	if entry.version != expectedVersion {
+		return 0, domain.ErrVersionConflict
+	}
+
+	entry.item.Price = newPrice
+	entry.version++
+
+	return entry.version, nil
+}
+
+func (r *cartMemory) DeleteItem(_ context.Context, ownerID string, productID uuid.UUID) (bool, error) {
+	if err := r.lock(); err != nil {
+		return false, err
+	}
+	defer r.mu.Unlock()
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return false, domain.ErrItemNotFound
+	}
+
+	entry.deleted = true
+	entry.version++
+
+	return true, nil
+}
+
+func (r *cartMemory) DecrementItem(_ context.Context, ownerID string, productID uuid.UUID, by int32) (domain.CartItem, error) {
+	if err := r.lock(); err != nil {
+		return domain.CartItem{}, err
+	}
+	defer r.mu.Unlock()
+
+	if by <= 0 {
+		return domain.CartItem{}, fmt.Errorf("by[%d] is not positive", by)
+	}
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return domain.CartItem{}, domain.ErrItemNotFound
+	}
+
+	entry.version++
+	if entry.item.Quantity <= by {
+		entry.deleted = true
+		return domain.CartItem{}, nil
+	}
+
+	entry.item.Quantity -= by
+	return entry.item, nil
+}
+
+func (r *cartMemory) ApplyQuantityDeltas(_ context.Context, ownerID string, deltas map[uuid.UUID]int32) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	// every delta is checked before any is applied like the rolled back transaction,
+	// in the same product order so the same product is reported
+	productIDs := slices.SortedFunc(maps.Keys(deltas), func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	for _, productID := range productIDs {
+		entry, ok := r.active(ownerID, productID)
+		if !ok {
+			return domain.ErrItemNotFound
+		}
+		if entry.item.Quantity+deltas[productID] < 0 {
+			return &domain.NegativeQuantityError{ProductID: productID, Quantity: entry.item.Quantity, Delta: deltas[productID]}
+		}
+	}
+
+	for _, productID := range productIDs {
+		entry, _ := r.active(ownerID, productID)
+		entry.version++
+		if entry.item.Quantity+deltas[productID] == 0 {
+			entry.deleted = true
+			continue
+		}
+
+		entry.item.Quantity += deltas[productID]
+	}
+
+	return nil
+}
+
+func (r *cartMemory) SwapQuantities(_ context.Context, ownerID string, productA, productB uuid.UUID) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	a, ok := r.active(ownerID, productA)
+	if !ok {
+		return domain.ErrItemNotFound
+	}
+	b, ok := r.active(ownerID, productB)
+	if !ok {
+		return domain.ErrItemNotFound
+	}
+
+	a.item.Quantity, b.item.Quantity = b.item.Quantity, a.item.Quantity
+	a.version++
+	// a product swapped with itself is a single row updated once
+	if b != a {
+		b.version++
+	}
+
+	return nil
+}
+
+func (r *cartMemory) DeleteItems(_ context.Context, ownerID string, productIDs []uuid.UUID) (int64, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	for i, productID := range productIDs {
+		if productID == uuid.Nil {
+			return 0, fmt.Errorf("productIDs[%d] is empty", i)
+		}
+	}
+
+	return r.deleteActive(ownerID, func(item domain.CartItem) bool {
+		return slices.Contains(productIDs, item.ProductID)
+	}), nil
+}
+
+func (r *cartMemory) RestoreItem(_ context.Context, ownerID string, productID uuid.UUID) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	entry, ok := r.items[ownerID][productID]
+	if !ok || !entry.deleted {
+		return domain.ErrItemNotFound
+	}
+
+	entry.deleted = false
+	entry.version++
+
+	return nil
+}
+
+func (r *cartMemory) GetItemsByCurrency(_ context.Context, ownerID string, cur currency.Unit) ([]domain.CartItem, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if cur == (currency.Unit{}) {
+		return nil, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return nil, err
+	}
+
+	items := r.activeItems(ownerID)
+
+	return slices.DeleteFunc(items, func(item domain.CartItem) bool {
+		return item.Price.Currency != cur
+	}), nil
+}
+
+// StreamCart passes each item to fn, stopping at the first error returned by fn.
+// The items are read at once, so fn may call the repository.
+func (r *cartMemory) StreamCart(ctx context.Context, ownerID string, fn func(domain.CartItem) error) error {
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	cart, err := r.GetCart(ctx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range cart.Items {
+		if err := fn(item); err != nil {
+			return fmt.Errorf("fn: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *cartMemory) ClearCurrency(_ context.Context, ownerID string, cur currency.Unit) (int, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if cur == (currency.Unit{}) {
+		return 0, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return 0, err
+	}
+
+	deleted := r.deleteActive(ownerID, func(item domain.CartItem) bool {
+		return item.Price.Currency == cur
+	})
+
+	return int(deleted), nil
+}
+
+func (r *cartMemory) ApplyPriceFactor(_ context.Context, ownerID string, cur currency.Unit, factor decimal.Decimal) (int64, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+	if cur == (currency.Unit{}) {
+		return 0, fmt.Errorf("currency is empty")
+	}
+	if _, err := domain.ParseCurrency(cur.String()); err != nil {
+		return 0, err
+	}
+	if !factor.IsPositive() {
+		return 0, fmt.Errorf("factor[%s] is not positive", factor)
+	}
+
+	var updated int64
+	for _, entry := range r.items[ownerID] {
+		if !entry.deleted && entry.item.Price.Currency == cur {
+			entry.item.Price = entry.item.Price.Multiply(factor)
+			entry.version++
+			updated++
+		}
+	}
+
+	return updated, nil
+}
+
+func (r *cartMemory) CartExists(_ context.Context, ownerID string) (bool, error) {
+	if err := r.rlock(); err != nil {
+		return false, err
+	}
+	defer r.mu.RUnlock()
+
+	if ownerID == "" {
+		return false, fmt.Errorf("ownerID is empty")
+	}
+
+	return len(r.activeItems(ownerID)) > 0, nil
+}
+
+func (r *cartMemory) CountItems(_ context.Context, ownerID string) (int64, error) {
+	if err := r.rlock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.RUnlock()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+
+	return int64(len(r.activeItems(ownerID))), nil
+}
+
+func (r *cartMemory) ClearCart(_ context.Context, ownerID string) (int64, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if ownerID == "" {
+		return 0, fmt.Errorf("ownerID is empty")
+	}
+
+	return r.deleteActive(ownerID, func(domain.CartItem) bool { return true }), nil
+}
+
+func (r *cartMemory) MoveItems(_ context.Context, fromOwnerID, toOwnerID string) (int64, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if fromOwnerID == "" {
+		return 0, fmt.Errorf("fromOwnerID is empty")
+	}
+	if toOwnerID == "" {
+		return 0, fmt.Errorf("toOwnerID is empty")
+	}
+	if fromOwnerID == toOwnerID {
+		return 0, fmt.Errorf("fromOwnerID and toOwnerID are the same")
+	}
+
+	moved := r.activeItems(fromOwnerID)
+	r.deleteActive(fromOwnerID, func(domain.CartItem) bool { return true })
+	for _, item := range moved {
+		// the target keeps its price and CreatedAt, a soft-deleted target item is replaced
+		if entry, ok := r.active(toOwnerID, item.ProductID); ok {
+			entry.item.Quantity += item.Quantity
+			entry.version++
+			continue
+		}
+		r.put(toOwnerID, item)
+	}
+
+	return int64(len(moved)), nil
+}
+
+func (r *cartMemory) PurgeCartsOlderThan(_ context.Context, cutoff time.Time) (int64, error) {
+	if err := r.lock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	if cutoff.IsZero() {
+		return 0, fmt.Errorf("cutoff is zero")
+	}
+
+	// only the default cart is kept, so all items are in the cart the database repository is scoped to
+	var purged int64
+	for ownerID, entries := range r.items {
+		// deleted items do not keep the cart, a cart holding only deleted ones is purged
+		var newest time.Time
+		for _, entry := range entries {
+			if !entry.deleted && entry.item.CreatedAt.After(newest) {
+				newest = entry.item.CreatedAt
+			}
+		}
+		if newest.Before(cutoff) {
+			purged += int64(len(entries))
+			delete(r.items, ownerID)
+		}
+	}
+
+	return purged, nil
+}
+
+func (r *cartMemory) CartCurrencyReport(_ context.Context, ownerID string) (domain.CurrencyReport, error) {
+	if err := r.rlock(); err != nil {
+		return domain.CurrencyReport{}, err
+	}
+	defer r.mu.RUnlock()
+
+	currencies := distinctCurrencies(r.activeItems(ownerID))
+
+	return domain.CurrencyReport{
+		Currencies:     currencies,
+		SingleCurrency: len(currencies) <= 1,
+	}, nil
+}
+
+func (r *cartMemory) CartCurrency(_ context.Context, ownerID string) (currency.Unit, error) {
+	if err := r.rlock(); err != nil {
+		return currency.Unit{}, err
+	}
+	defer r.mu.RUnlock()
+
+	currencies := distinctCurrencies(r.activeItems(ownerID))
+
+	switch {
+	case len(currencies) == 0:
+		return currency.Unit{}, domain.ErrEmptyCart
+	case len(currencies) > 1:
+		return currency.Unit{}, domain.ErrMixedCurrency
+	}
+
+	return currencies[0], nil
+}
+
+func (r *cartMemory) GetCartTotal(ctx context.Context, ownerID string) (domain.Money, error) {
+	return r.TotalExcluding(ctx, ownerID, nil)
+}
+
+func (r *cartMemory) GetCartTotalIn(ctx context.Context, ownerID string, target currency.Unit, rates map[string]decimal.Decimal) (domain.Money, error) {
+	if target == (currency.Unit{}) {
+		return domain.Money{}, fmt.Errorf("currency is empty")
+	}
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.Money{}, err
+	}
+
+	total, err := cart.TotalIn(target, rates)
+	if err != nil {
+		return domain.Money{}, fmt.Errorf("cart.TotalIn: %w", err)
+	}
+
+	return total, nil
+}
+
+func (r *cartMemory) TotalExcluding(_ context.Context, ownerID string, excluded []uuid.UUID) (domain.Money, error) {
+	if err := r.rlock(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.mu.RUnlock()
+
+	// the excluded items are filtered out before the currency check, like in the database query
+	items := slices.DeleteFunc(r.activeItems(ownerID), func(item domain.CartItem) bool {
+		return slices.Contains(excluded, item.ProductID)
+	})
+
+	switch currencies := distinctCurrencies(items); {
+	case len(currencies) == 0:
+		return domain.Money{Amount: decimal.Zero}, nil
+	case len(currencies) > 1:
+		return domain.Money{}, domain.ErrMixedCurrency
+	}
+
+	total := domain.Money{Amount: decimal.Zero, Currency: items[0].Price.Currency}
+	for _, item := range items {
+		total.Amount = total.Amount.Add(item.Price.Amount.Mul(decimal.NewFromInt32(item.Quantity)))
+	}
+
+	return total, nil
+}
+
+func (r *cartMemory) ListCartNames(_ context.Context, ownerID string) ([]string, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, 1)
+	if len(r.activeItems(ownerID)) > 0 {
+		names = append(names, repository.DefaultCartName)
+	}
+
+	return names, nil
+}
+
+func (r *cartMemory) ListOwners(_ context.Context, limit, offset int32) ([]string, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("offset[%d] is negative", offset)
+	}
+
+	owners := make([]string, 0)
+	for ownerID := range r.items {
+		if len(r.activeItems(ownerID)) > 0 {
+			owners = append(owners, ownerID)
+		}
+	}
+	slices.Sort(owners)
+
+	owners = owners[min(int(offset), len(owners)):]
+	return owners[:min(int(limit), len(owners))], nil
+}
+
+func (r *cartMemory) PlanSync(ctx context.Context, ownerID string, desired []domain.CartItem) (domain.SyncPlan, error) {
+	for i, item := range desired {
+		if item.Price.Currency == (currency.Unit{}) {
+			return domain.SyncPlan{}, fmt.Errorf("desired[%d] currency is empty", i)
+		}
+	}
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return domain.SyncPlan{}, err
+	}
+
+	plan, err := domain.PlanSync(cart.Items, desired)
+	if err != nil {
+		return domain.SyncPlan{}, fmt.Errorf("domain.PlanSync: %w", err)
+	}
+
+	return plan, nil
+}
+
+func (r *cartMemory) ApplySync(_ context.Context, ownerID string, plan domain.SyncPlan) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	upserts := slices.Concat(plan.Adds, plan.Updates)
+	for _, item := range upserts {
+		if err := validateItem(item); err != nil {
+			return err
+		}
+	}
+
+	// the desired quantities replace the stored ones instead of being added to them
+	createdAt := r.now()
+	for _, item := range upserts {
+		r.upsert(ownerID, item, true, createdAt)
+	}
+
+	for _, productID := range plan.Deletes {
+		if entry, ok := r.active(ownerID, productID); ok {
+			entry.deleted = true
+			entry.version++
+		}
+	}
+
+	return nil
+}
+
+func (r *cartMemory) ReplaceCart(_ context.Context, ownerID string, items []domain.CartItem) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	seen := make(map[uuid.UUID]bool, len(items))
+	for _, item := range items {
+		if seen[item.ProductID] {
+			return fmt.Errorf("product[%s] is duplicated", item.ProductID)
+		}
+		seen[item.ProductID] = true
+
+		if err := validateItem(item); err != nil {
+			return err
+		}
+	}
+
+	r.deleteActive(ownerID, func(domain.CartItem) bool { return true })
+
+	createdAt := r.now()
+	for _, item := range items {
+		r.upsert(ownerID, item, false, createdAt)
+	}
+
+	return nil
+}
+
+// ValidateProducts reads the cart products at once, so exists may call the repository.
+func (r *cartMemory) ValidateProducts(ctx context.Context, ownerID string, exists func([]uuid.UUID) (map[uuid.UUID]bool, error)) ([]uuid.UUID, error) {
+	if exists == nil {
+		return nil, fmt.Errorf("exists is nil")
+	}
+
+	cart, err := r.getCart(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]uuid.UUID, 0)
+	if len(cart.Items) == 0 {
+		return missing, nil
+	}
+
+	productIDs := make([]uuid.UUID, 0, len(cart.Items))
+	for _, item := range cart.Items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	existing, err := exists(productIDs)
+	if err != nil {
+		return nil, fmt.Errorf("exists: %w", err)
+	}
+
+	for _, productID := range productIDs {
+		if !existing[productID] {
+			missing = append(missing, productID)
+		}
+	}
+
+	return missing, nil
+}
+
+func (r *cartMemory) ItemAddedAt(_ context.Context, ownerID string, productID uuid.UUID) (time.Time, error) {
+	if err := r.rlock(); err != nil {
+		return time.Time{}, err
+	}
+	defer r.mu.RUnlock()
+
+	entry, ok := r.active(ownerID, productID)
+	if !ok {
+		return time.Time{}, domain.ErrItemNotFound
+	}
+
+	return entry.item.CreatedAt, nil
+}
+
+func (r *cartMemory) ItemRecencyRank(_ context.Context, ownerID string, productID uuid.UUID) (int, error) {
+	if err := r.rlock(); err != nil {
+		return 0, err
+	}
+	defer r.mu.RUnlock()
+
+	items := r.activeItems(ownerID)
+
+	// the most recent item first, ties broken by product like the ROW_NUMBER of the database query
+	slices.SortFunc(items, func(a, b domain.CartItem) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ProductID[:], b.ProductID[:])
+	})
+
+	i := slices.IndexFunc(items, func(item domain.CartItem) bool {
+		return item.ProductID == productID
+	})
+	if i < 0 {
+		return 0, domain.ErrItemNotFound
+	}
+
+	return i + 1, nil
+}
+
+func (r *cartMemory) ProductWeightedAvgPrice(_ context.Context, productID uuid.UUID, cur currency.Unit) (domain.Money, error) {
+	if err := r.rlock(); err != nil {
+		return domain.Money{}, err
+	}
+	defer r.mu.RUnlock()
+
+	if productID == uuid.Nil {
+		return domain.Money{}, fmt.Errorf("productID is empty")
+	}
+	if cur == (currency.Unit{}) {
+		return domain.Money{}, fmt.Errorf("currency is empty")
+	}
+
+	sum, quantity := decimal.Zero, decimal.Zero
+	for ownerID := range r.items {
+		entry, ok := r.active(ownerID, productID)
+		if !ok || entry.item.Price.Currency != cur {
+			continue
+		}
+
+		q := decimal.NewFromInt32(entry.item.Quantity)
+		sum = sum.Add(entry.item.Price.Amount.Mul(q))
+		quantity = quantity.Add(q)
+	}
+
+	if quantity.IsZero() {
+		return domain.Money{}, domain.ErrItemNotFound
+	}
+
+	return domain.Money{
+		Amount:   sum.Div(quantity),
+		Currency: cur,
+	}, nil
+}
+
+func (r *cartMemory) DemandByProduct(_ context.Context, productIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	demand := make(map[uuid.UUID]int64, len(productIDs))
+	for _, productID := range productIDs {
+		demand[productID] = 0
+
+		for ownerID := range r.items {
+			if entry, ok := r.active(ownerID, productID); ok {
+				demand[productID] += int64(entry.item.Quantity)
+			}
+		}
+	}
+
+	return demand, nil
+}
+
+func (r *cartMemory) TopProducts(_ context.Context, limit int32) ([]domain.ProductCount, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit[%d] is not positive", limit)
+	}
+
+	carts := make(map[uuid.UUID]int64)
+	for ownerID := range r.items {
+		for _, item := range r.activeItems(ownerID) {
+			carts[item.ProductID]++
+		}
+	}
+
+	counts := make([]domain.ProductCount, 0, len(carts))
+	for productID, count := range carts {
+		counts = append(counts, domain.ProductCount{ProductID: productID, CartCount: count})
+	}
+	slices.SortFunc(counts, func(a, b domain.ProductCount) int {
+		if c := cmp.Compare(b.CartCount, a.CartCount); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ProductID[:], b.ProductID[:])
+	})
+
+	return counts[:min(int(limit), len(counts))], nil
+}
+
+func (r *cartMemory) DistinctCurrencies(_ context.Context) ([]currency.Unit, error) {
+	if err := r.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.mu.RUnlock()
+
+	var items []domain.CartItem
+	for ownerID := range r.items {
+		items = append(items, r.activeItems(ownerID)...)
+	}
+
+	return distinctCurrencies(items), nil
+}
+
+func (r *cartMemory) SetOwnerCurrency(_ context.Context, ownerID string, cur currency.Unit) error {
+	if err := r.lock(); err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	if cur == (currency.Unit{}) {
+		return fmt.Errorf("currency is empty")
+	}
+
+	r.ownerCurrencies[ownerID] = cur
+
+	return nil
+}
+
+func (r *cartMemory) GetOwnerCurrency(_ context.Context, ownerID string) (currency.Unit, bool, error) {
+	if err := r.rlock(); err != nil {
+		return currency.Unit{}, false, err
+	}
+	defer r.mu.RUnlock()
+
+	cur, ok := r.ownerCurrencies[ownerID]
+
+	return cur, ok, nil
+}
+
+// resolveItem falls back to the owner's default currency when the item currency is the zero value,
+// then validates the item.
+func (r *cartMemory) resolveItem(ownerID string, item domain.CartItem) (domain.CartItem, error) {
+	if item.Price.Currency == (currency.Unit{}) {
+		cur, ok := r.ownerCurrencies[ownerID]
+		if !ok {
+			return domain.CartItem{}, fmt.Errorf("item currency is empty and owner has no default currency")
+		}
+		item.Price.Currency = cur
+	}
+
+	if err := validateItem(item); err != nil {
+		return domain.CartItem{}, err
+	}
+
+	return item, nil
+}
+
+// upsert adds the quantity to the stored one unless setQuantity is true, the price and note are always replaced.
+// A soft-deleted item is added again from scratch.
+func (r *cartMemory) upsert(ownerID string, item domain.CartItem, setQuantity bool, createdAt time.Time) {
+	entry, ok := r.active(ownerID, item.ProductID)
+	if !ok {
+		item.CreatedAt = createdAt
+		r.put(ownerID, item)
+		return
+	}
+
+	entry.item.Price = item.Price
+	entry.item.Note = copyNote(item.Note)
+	if setQuantity {
+		entry.item.Quantity = item.Quantity
+	} else {
+		entry.item.Quantity += item.Quantity
+	}
+	entry.version++
+}
+
+func (r *cartMemory) put(ownerID string, item domain.CartItem) {
+	if r.items[ownerID] == nil {
+		r.items[ownerID] = make(map[uuid.UUID]*cartEntry)
+	}
+
+	// a soft-deleted item replaced by put keeps counting its versions
+	version := int32(1)
+	if entry, ok := r.items[ownerID][item.ProductID]; ok {
+		version = entry.version + 1
+	}
+
+	item.Note = copyNote(item.Note)
+	r.items[ownerID][item.ProductID] = &cartEntry{item: item, version: version}
+}
+
+// copyNote keeps the caller from changing a stored note, an empty note is stored as nil like in the database.
+func copyNote(note *string) *string {
+	if note == nil || *note == "" {
+		return nil
+	}
+
+	n := *note
+	return &n
+}
+
+func (r *cartMemory) active(ownerID string, productID uuid.UUID) (*cartEntry, bool) {
+	entry, ok := r.items[ownerID][productID]
+	if !ok || entry.deleted {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// activeItems returns the items which are not soft-deleted ordered by CreatedAt and ProductID.
+func (r *cartMemory) activeItems(ownerID string) []domain.CartItem {
+	items := make([]domain.CartItem, 0, len(r.items[ownerID]))
+	for _, entry := range r.items[ownerID] {
+		if !entry.deleted {
+			items = append(items, entry.item)
+		}
+	}
+
+	slices.SortFunc(items, compareItems)
+
+	return items
+}
+
+// deleteActive soft-deletes the items matching fn, returning how many were deleted.
+func (r *cartMemory) deleteActive(ownerID string, fn func(domain.CartItem) bool) int64 {
+	var deleted int64
+	for _, entry := range r.items[ownerID] {
+		if !entry.deleted && fn(entry.item) {
+			entry.deleted = true
+			entry.version++
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+func compareItems(a, b domain.CartItem) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+
+	return bytes.Compare(a.ProductID[:], b.ProductID[:])
+}
+
+// distinctCurrencies returns the currencies of the items sorted by code.
+func distinctCurrencies(items []domain.CartItem) []currency.Unit {
+	currencies := make([]currency.Unit, 0)
+	for _, item := range items {
+		if !slices.Contains(currencies, item.Price.Currency) {
+			currencies = append(currencies, item.Price.Currency)
+		}
+	}
+
+	slices.SortFunc(currencies, func(a, b currency.Unit) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return currencies
+}
+
+func validateItem(item domain.CartItem) error {
+	if err := item.Validate(); err != nil {
+		return fmt.Errorf("product[%s] is not valid: %w", item.ProductID, err)
+	}
+
+	return nil
+}
+
+func validatePrice(price domain.Money) error {
+	if price.Currency == (currency.Unit{}) {
+		return fmt.Errorf("currency is empty")
+	}
+	if price.Amount.LessThanOrEqual(decimal.Zero) {
+		return fmt.Errorf("price amount[%s] is not positive", price.Amount)
+	}
+	if _, err := domain.ParseCurrency(price.Currency.String()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// now is truncated to microseconds, the precision of the database timestamps.
+func (r *cartMemory) now() time.Time {
+	return r.clock.Now().UTC().Truncate(time.Microsecond)
+}
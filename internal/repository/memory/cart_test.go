@@ -0,0 +1,618 @@
+package memory_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository/memory"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestMemoryCart(t *testing.T) {
+	const ownerID = "owner"
+
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("10.50"), Currency: currency.USD},
+		Quantity:  1,
+	}
+
+	repriced := item
+	repriced.Price.Amount = decimal.RequireFromString("12")
+	repriced.Quantity = 2
+
+	tests := []struct {
+		name          string
+		run           func(repo port.CartRepository) error
+		wantError     string
+		wantItems     []domain.CartItem
+		wantCartError error // of the GetCart call after run
+	}{
+		{
+			name:          "never used cart: not found",
+			wantCartError: domain.ErrCartNotFound,
+		},
+		{
+			name: "cart with deleted item: empty",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				_, err := repo.DeleteItem(t.Context(), ownerID, item.ProductID)
+				return err
+			},
+		},
+		{
+			name: "add item: ok",
+			run: func(repo port.CartRepository) error {
+				return repo.AddItem(t.Context(), ownerID, item)
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "add item again: quantity summed and price replaced",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				return repo.AddItem(t.Context(), ownerID, repriced)
+			},
+			wantItems: []domain.CartItem{withQuantity(repriced, 3)},
+		},
+		{
+			name: "add item with nil product ID: error",
+			run: func(repo port.CartRepository) error {
+				invalid := item
+				invalid.ProductID = uuid.Nil
+				return repo.AddItem(t.Context(), ownerID, invalid)
+			},
+			wantError: "product[00000000-0000-0000-0000-000000000000] is not valid: productID is empty",
+		},
+		{
+			name: "add item without currency and owner default: error",
+			run: func(repo port.CartRepository) error {
+				noCurrency := item
+				noCurrency.Price.Currency = currency.Unit{}
+				return repo.AddItem(t.Context(), ownerID, noCurrency)
+			},
+			wantError: "item currency is empty and owner has no default currency",
+		},
+		{
+			name: "delete and restore item: ok",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if _, err := repo.DeleteItem(t.Context(), ownerID, item.ProductID); err != nil {
+					return err
+				}
+				return repo.RestoreItem(t.Context(), ownerID, item.ProductID)
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "delete and add item again: quantity reset",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, repriced); err != nil {
+					return err
+				}
+				if _, err := repo.DeleteItem(t.Context(), ownerID, item.ProductID); err != nil {
+					return err
+				}
+				return repo.AddItem(t.Context(), ownerID, item)
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "delete missing item: error",
+			run: func(repo port.CartRepository) error {
+				_, err := repo.DeleteItem(t.Context(), ownerID, item.ProductID)
+				return err
+			},
+			wantError: domain.ErrItemNotFound.Error(),
+		},
+		{
+			name: "get item of empty owner: error",
+			run: func(repo port.CartRepository) error {
+				_, err := repo.GetItem(t.Context(), "", item.ProductID)
+				return err
+			},
+			wantError: "ownerID is empty",
+		},
+		{
+			name: "move items: ok",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), "other owner", repriced); err != nil {
+					return err
+				}
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				_, err := repo.MoveItems(t.Context(), "other owner", ownerID)
+				return err
+			},
+			wantItems: []domain.CartItem{withQuantity(item, 3)},
+		},
+		{
+			name: "move items: source soft-deleted",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if _, err := repo.MoveItems(t.Context(), ownerID, "other owner"); err != nil {
+					return err
+				}
+				return repo.RestoreItem(t.Context(), ownerID, item.ProductID)
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "update price with expected version: ok",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				_, err := repo.UpdateItemPriceIfVersion(t.Context(), ownerID, item.ProductID, repriced.Price, 1)
+				return err
+			},
+			wantItems: []domain.CartItem{withQuantity(repriced, 1)},
+		},
+		{
+			name: "update price with stale version: error",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				_, err := repo.UpdateItemPriceIfVersion(t.Context(), ownerID, item.ProductID, repriced.Price, 1)
+				return err
+			},
+			wantError: domain.ErrVersionConflict.Error(),
+		},
+		{
+			name: "decrement item partially: quantity reduced",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, withQuantity(item, 3)); err != nil {
+					return err
+				}
+				_, err := repo.DecrementItem(t.Context(), ownerID, item.ProductID, 2)
+				return err
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "decrement item to zero: deleted",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				got, err := repo.DecrementItem(t.Context(), ownerID, item.ProductID, 1)
+				if err != nil {
+					return err
+				}
+				if got != (domain.CartItem{}) {
+					return fmt.Errorf("deleted item reported as %v", got)
+				}
+				return nil
+			},
+		},
+		{
+			name: "add or remove item with quantity: quantity set",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, withQuantity(item, 3)); err != nil {
+					return err
+				}
+				return repo.AddOrRemoveItem(t.Context(), ownerID, item)
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "add or remove item with zero quantity: deleted",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddOrRemoveItem(t.Context(), ownerID, withQuantity(item, 0)); err != nil {
+					return err
+				}
+				// removing it again is not an error
+				return repo.AddOrRemoveItem(t.Context(), ownerID, withQuantity(item, 0))
+			},
+		},
+		{
+			name: "add or remove item with negative quantity: error",
+			run: func(repo port.CartRepository) error {
+				return repo.AddOrRemoveItem(t.Context(), ownerID, withQuantity(item, -1))
+			},
+			wantError: "product[" + item.ProductID.String() + "] is not valid: quantity[-1] is not positive",
+		},
+		{
+			name: "apply quantity deltas: updated and deleted",
+			run: func(repo port.CartRepository) error {
+				other := withQuantity(item, 2)
+				other.ProductID = uuid.New()
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(t.Context(), ownerID, other); err != nil {
+					return err
+				}
+				return repo.ApplyQuantityDeltas(t.Context(), ownerID, map[uuid.UUID]int32{item.ProductID: 2, other.ProductID: -2})
+			},
+			wantItems: []domain.CartItem{withQuantity(item, 3)},
+		},
+		{
+			name: "apply quantity delta below zero: error naming the product, nothing changed",
+			run: func(repo port.CartRepository) error {
+				other := withQuantity(item, 2)
+				other.ProductID = uuid.New()
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(t.Context(), ownerID, other); err != nil {
+					return err
+				}
+
+				err := repo.ApplyQuantityDeltas(t.Context(), ownerID, map[uuid.UUID]int32{item.ProductID: -2, other.ProductID: 1})
+				var negativeErr *domain.NegativeQuantityError
+				if !errors.As(err, &negativeErr) || negativeErr.ProductID != item.ProductID {
+					return fmt.Errorf("unexpected error: %w", err)
+				}
+
+				got, err := repo.GetItem(t.Context(), ownerID, other.ProductID)
+				if err != nil {
+					return err
+				}
+				if got.Quantity != 2 {
+					return fmt.Errorf("quantity[%d] changed", got.Quantity)
+				}
+				_, err = repo.DeleteItem(t.Context(), ownerID, other.ProductID)
+				return err
+			},
+			wantItems: []domain.CartItem{item},
+		},
+		{
+			name: "apply quantity delta to missing product: not found",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				return repo.ApplyQuantityDeltas(t.Context(), ownerID, map[uuid.UUID]int32{uuid.New(): 1})
+			},
+			wantError: domain.ErrItemNotFound.Error(),
+		},
+		{
+			name: "swap quantities: quantities exchanged",
+			run: func(repo port.CartRepository) error {
+				other := withQuantity(item, 3)
+				other.ProductID = uuid.New()
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				if err := repo.AddItem(t.Context(), ownerID, other); err != nil {
+					return err
+				}
+				if err := repo.SwapQuantities(t.Context(), ownerID, item.ProductID, other.ProductID); err != nil {
+					return err
+				}
+				_, err := repo.DeleteItem(t.Context(), ownerID, other.ProductID)
+				return err
+			},
+			wantItems: []domain.CartItem{withQuantity(item, 3)},
+		},
+		{
+			name: "swap quantities with missing product: not found",
+			run: func(repo port.CartRepository) error {
+				if err := repo.AddItem(t.Context(), ownerID, item); err != nil {
+					return err
+				}
+				return repo.SwapQuantities(t.Context(), ownerID, item.ProductID, uuid.New())
+			},
+			wantError: domain.ErrItemNotFound.Error(),
+		},
+		{
+			name: "replace cart with duplicated product: error",
+			run: func(repo port.CartRepository) error {
+				return repo.ReplaceCart(t.Context(), ownerID, []domain.CartItem{item, item})
+			},
+			wantError: "product[" + item.ProductID.String() + "] is duplicated",
+		},
+		{
+			name: "shut down: error",
+			run: func(repo port.CartRepository) error {
+				if err := repo.Shutdown(t.Context()); err != nil {
+					return err
+				}
+				return repo.AddItem(t.Context(), ownerID, item)
+			},
+			wantError: repository.ErrShuttingDown.Error(),
+		},
+		{
+			name: "health check: ok",
+			run: func(repo port.CartRepository) error {
+				return repo.HealthCheck(t.Context())
+			},
+			wantCartError: domain.ErrCartNotFound,
+		},
+		{
+			name: "health check after shut down: error",
+			run: func(repo port.CartRepository) error {
+				if err := repo.Shutdown(t.Context()); err != nil {
+					return err
+				}
+				return repo.HealthCheck(t.Context())
+			},
+			wantError: repository.ErrShuttingDown.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := memory.NewMemoryCart()
+
+			if tt.run != nil {
+				err := tt.run(repo)
+				if tt.wantError != "" {
+					require.EqualError(t, err, tt.wantError)
+					return
+				}
+				require.NoError(t, err)
+			}
+
+			cart, err := repo.GetCart(t.Context(), ownerID)
+			if tt.wantCartError != nil {
+				require.ErrorIs(t, err, tt.wantCartError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, ownerID, cart.OwnerID)
+			require.NotNil(t, cart.Items)
+			assert.True(t, domain.CartsEqual(domain.Cart{Items: tt.wantItems}, cart), "got %v", cart.Items)
+
+			for _, item := range cart.Items {
+				assert.False(t, item.CreatedAt.IsZero())
+			}
+		})
+	}
+}
+
+func TestMemoryCartConcurrentAdds(t *testing.T) {
+	repo := memory.NewMemoryCart()
+
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.EUR},
+		Quantity:  1,
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			assert.NoError(t, repo.AddItem(t.Context(), "owner", item))
+		})
+	}
+	wg.Wait()
+
+	got, err := repo.GetItem(t.Context(), "owner", item.ProductID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(10), got.Quantity)
+}
+
+func TestMemoryCartValidateAddItem(t *testing.T) {
+	repo := memory.NewMemoryCart()
+
+	valid := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.EUR},
+		Quantity:  1,
+	}
+	noCurrency := valid
+	noCurrency.Price.Currency = currency.Unit{}
+
+	require.NoError(t, repo.ValidateAddItem(t.Context(), "owner", valid))
+
+	for _, item := range []domain.CartItem{withQuantity(valid, 0), noCurrency} {
+		err := repo.ValidateAddItem(t.Context(), "owner", item)
+		require.Error(t, err)
+		assert.EqualError(t, repo.AddItem(t.Context(), "owner", item), err.Error())
+	}
+
+	count, err := repo.CountItems(t.Context(), "owner")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestMemoryCartOwnerIDNormalization(t *testing.T) {
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.EUR},
+		Quantity:  1,
+	}
+
+	t.Run("surrounding whitespace: same cart", func(t *testing.T) {
+		repo := memory.NewMemoryCart()
+
+		require.NoError(t, repo.AddItem(t.Context(), " owner ", item))
+		require.NoError(t, repo.AddItem(t.Context(), "owner", item))
+
+		cart, err := repo.GetCart(t.Context(), "\towner\n")
+		require.NoError(t, err)
+		assert.Equal(t, "owner", cart.OwnerID)
+		require.Len(t, cart.Items, 1)
+		assert.Equal(t, int32(2), cart.Items[0].Quantity)
+	})
+
+	t.Run("other case: other cart", func(t *testing.T) {
+		repo := memory.NewMemoryCart()
+
+		require.NoError(t, repo.AddItem(t.Context(), "OWNER", item))
+
+		exists, err := repo.CartExists(t.Context(), "owner")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("other case with WithLowerCaseOwnerIDs: same cart", func(t *testing.T) {
+		repo := memory.NewMemoryCart(memory.WithLowerCaseOwnerIDs())
+
+		require.NoError(t, repo.AddItem(t.Context(), " OWNER", item))
+
+		exists, err := repo.CartExists(t.Context(), "owner")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("whitespace only: error", func(t *testing.T) {
+		repo := memory.NewMemoryCart()
+
+		_, err := repo.CountItems(t.Context(), " \t ")
+		require.EqualError(t, err, "ownerID is empty")
+
+		_, err = repo.MoveItems(t.Context(), "owner", "  ")
+		require.EqualError(t, err, "toOwnerID is empty")
+	})
+}
+
+func TestMemoryCartTotalExcluding(t *testing.T) {
+	repo := memory.NewMemoryCart()
+
+	usd := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("10"), Currency: currency.USD},
+		Quantity:  1,
+	}
+	eur := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("7.5"), Currency: currency.EUR},
+		Quantity:  2,
+	}
+	require.NoError(t, repo.AddItem(t.Context(), "owner", usd))
+	require.NoError(t, repo.AddItem(t.Context(), "owner", eur))
+
+	_, err := repo.TotalExcluding(t.Context(), "owner", nil)
+	require.ErrorIs(t, err, domain.ErrMixedCurrency)
+
+	total, err := repo.TotalExcluding(t.Context(), "owner", []uuid.UUID{usd.ProductID})
+	require.NoError(t, err)
+	want := domain.Money{Amount: decimal.RequireFromString("15"), Currency: currency.EUR}
+	assert.True(t, want.Equal(total), "want %v, got %v", want, total)
+
+	total, err = repo.TotalExcluding(t.Context(), "owner", []uuid.UUID{usd.ProductID, eur.ProductID})
+	require.NoError(t, err)
+	assert.True(t, domain.Money{Amount: decimal.Zero}.Equal(total), "want zero, got %v", total)
+}
+
+func TestMemoryCartGetCartWithLineTotals(t *testing.T) {
+	repo := memory.NewMemoryCart()
+
+	usd := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("10.25"), Currency: currency.USD},
+		Quantity:  3,
+	}
+	eur := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("7.5"), Currency: currency.EUR},
+		Quantity:  2,
+	}
+	require.NoError(t, repo.AddItem(t.Context(), "owner", usd))
+	require.NoError(t, repo.AddItem(t.Context(), "owner", eur))
+
+	lines, err := repo.GetCartWithLineTotals(t.Context(), "owner")
+	require.NoError(t, err)
+	require.Len(t, lines, 2)
+
+	want := map[uuid.UUID]domain.Money{
+		usd.ProductID: {Amount: decimal.RequireFromString("30.75"), Currency: currency.USD},
+		eur.ProductID: {Amount: decimal.RequireFromString("15"), Currency: currency.EUR},
+	}
+	for _, line := range lines {
+		assert.True(t, want[line.Item.ProductID].Equal(line.LineTotal), "want %v, got %v", want[line.Item.ProductID], line.LineTotal)
+	}
+
+	lines, err = repo.GetCartWithLineTotals(t.Context(), "other")
+	require.NoError(t, err)
+	assert.NotNil(t, lines)
+	assert.Empty(t, lines)
+}
+
+func TestMemoryCartClock(t *testing.T) {
+	clock := fixedClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	repo := memory.NewMemoryCart(memory.WithClock(clock))
+
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.EUR},
+		Quantity:  1,
+	}
+	require.NoError(t, repo.AddItem(t.Context(), "owner", item))
+
+	got, err := repo.GetItem(t.Context(), "owner", item.ProductID)
+	require.NoError(t, err)
+	assert.True(t, clock.now.Equal(got.CreatedAt), "created at %s", got.CreatedAt)
+
+	purged, err := repo.PurgeCartsOlderThan(t.Context(), clock.now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestMemoryCartPurgeCartsOlderThan(t *testing.T) {
+	repo := memory.NewMemoryCart()
+	cutoff := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	newItem := func(createdAt time.Time) domain.CartItem {
+		return domain.CartItem{
+			ProductID: uuid.New(),
+			Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.EUR},
+			Quantity:  1,
+			CreatedAt: createdAt,
+		}
+	}
+
+	// the newer item of the stale owner is deleted, so it does not keep the cart
+	for _, ownerID := range []string{"stale", "mixed"} {
+		require.NoError(t, repo.ImportItem(t.Context(), ownerID, newItem(cutoff.Add(-time.Hour))))
+
+		newer := newItem(cutoff.Add(time.Hour))
+		require.NoError(t, repo.ImportItem(t.Context(), ownerID, newer))
+		if ownerID == "stale" {
+			_, err := repo.DeleteItem(t.Context(), ownerID, newer.ProductID)
+			require.NoError(t, err)
+		}
+	}
+
+	purged, err := repo.PurgeCartsOlderThan(t.Context(), cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	for ownerID, wantCount := range map[string]int64{"stale": 0, "mixed": 2} {
+		count, err := repo.CountItems(t.Context(), ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, wantCount, count, ownerID)
+	}
+}
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+func withQuantity(item domain.CartItem, quantity int32) domain.CartItem {
+	item.Quantity = quantity
+	return item
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+)
+
+// Observer is notified of the changes of a cart once they are committed, never when the call fails.
+// It is called synchronously, so it should not block.
+type Observer interface {
+	// OnItemAdded receives the item as passed to the adding method, with the resolved currency.
+	OnItemAdded(ownerID string, item domain.CartItem)
+	OnItemDeleted(ownerID string, productID uuid.UUID)
+	// OnCartChanged is called once per owner by the other methods changing items, e.g. SetItem or ClearCart,
+	// when they changed any.
+	OnCartChanged(ownerID string)
+}
+
+type noopObserver struct{}
+
+func (noopObserver) OnItemAdded(string, domain.CartItem) {}
+
+func (noopObserver) OnItemDeleted(string, uuid.UUID) {}
+
+func (noopObserver) OnCartChanged(string) {}
+
+// txObserver keeps the notifications of the calls made in RunInTx until the transaction commits.
+type txObserver struct {
+	events []func(Observer)
+}
+
+func (o *txObserver) OnItemAdded(ownerID string, item domain.CartItem) {
+	o.events = append(o.events, func(observer Observer) {
+		observer.OnItemAdded(ownerID, item)
+	})
+}
+
+func (o *txObserver) OnItemDeleted(ownerID string, productID uuid.UUID) {
+	o.events = append(o.events, func(observer Observer) {
+		observer.OnItemDeleted(ownerID, productID)
+	})
+}
+
+func (o *txObserver) OnCartChanged(ownerID string) {
+	o.events = append(o.events, func(observer Observer) {
+		observer.OnCartChanged(ownerID)
+	})
+}
+
+func (o *txObserver) flush(observer Observer) {
+	for _, event := range o.events {
+		event(observer)
+	}
+}
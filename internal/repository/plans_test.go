@@ -0,0 +1,156 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+const queryPlansGolden = "testdata/query_plans.golden"
+
+type knownQuery struct {
+	name string
+	sql  string
+	args []any
+}
+
+// knownQueries lists every generated query with representative params, new queries have to be added here.
+func knownQueries() []knownQuery {
+	const ownerID = "owner"
+	cartName := repository.DefaultCartName
+	productID := uuid.MustParse("4f7b8a2e-9c1d-4e5f-8a6b-1c2d3e4f5a6b")
+	amount := decimal.RequireFromString("9.99")
+	now := time.Now()
+
+	return []knownQuery{
+		{"AddIdempotencyKey", db.AddIdempotencyKey, []any{ownerID, "key", now}},
+		{"AddItem", db.AddItem, []any{ownerID, cartName, productID, amount, "USD", 1, nil, now}},
+		{"AddItemReturning", db.AddItemReturning, []any{ownerID, cartName, productID, amount, "USD", 1, nil, now}},
+		{"AddItemReturningPrevious", db.AddItemReturningPrevious, []any{ownerID, cartName, productID, amount, "USD", 1, nil, now}},
+		{"ApplyPriceFactor", db.ApplyPriceFactor, []any{amount, ownerID, cartName, "USD"}},
+		{"ApplyQuantityDelta", db.ApplyQuantityDelta, []any{1, now, ownerID, cartName, productID}},
+		{"CartExists", db.CartExists, []any{ownerID, cartName}},
+		{"CartKnown", db.CartKnown, []any{ownerID, cartName}},
+		{"ClearCart", db.ClearCart, []any{ownerID, cartName, now}},
+		{"CountItems", db.CountItems, []any{ownerID, cartName}},
+		{"DecrementItem", db.DecrementItem, []any{1, now, ownerID, cartName, productID}},
+		{"DeleteItem", db.DeleteItem, []any{ownerID, cartName, productID, now}},
+		{"DeleteItems", db.DeleteItems, []any{now, ownerID, cartName, []uuid.UUID{productID}}},
+		{"DeleteItemsByCurrency", db.DeleteItemsByCurrency, []any{ownerID, cartName, "USD", now}},
+		{"GetCart", db.GetCart, []any{ownerID, cartName}},
+		{"GetCartCurrency", db.GetCartCurrency, []any{ownerID, cartName}},
+		{"GetCartAfter", db.GetCartAfter, []any{ownerID, cartName, time.Time{}, uuid.Nil, 10}},
+		{"GetCartCurrencies", db.GetCartCurrencies, []any{ownerID, cartName}},
+		{"GetCartInRange", db.GetCartInRange, []any{ownerID, cartName, time.Time{}, time.Time{}}},
+		{"GetCartNames", db.GetCartNames, []any{ownerID}},
+		{"GetCartWithLineTotals", db.GetCartWithLineTotals, []any{ownerID, cartName}},
+		{"GetCartsByOwners", db.GetCartsByOwners, []any{[]string{ownerID}, cartName}},
+		{"GetDemandByProduct", db.GetDemandByProduct, []any{[]uuid.UUID{productID}}},
+		{"GetDistinctCurrencies", db.GetDistinctCurrencies, nil},
+		{"GetItem", db.GetItem, []any{ownerID, cartName, productID}},
+		{"GetItemCreatedAt", db.GetItemCreatedAt, []any{ownerID, cartName, productID}},
+		{"GetItemRecencyRank", db.GetItemRecencyRank, []any{ownerID, cartName, productID}},
+		{"GetItemsByCurrency", db.GetItemsByCurrency, []any{ownerID, cartName, "USD"}},
+		{"GetOwnerCurrency", db.GetOwnerCurrency, []any{ownerID}},
+		{"GetProductIDs", db.GetProductIDs, []any{ownerID, cartName}},
+		{"GetProductWeightedAvgPrice", db.GetProductWeightedAvgPrice, []any{productID, "USD"}},
+		{"GetRecentItems", db.GetRecentItems, []any{10}},
+		{"GetTopProducts", db.GetTopProducts, []any{10}},
+		{"GetTotalExcluding", db.GetTotalExcluding, []any{ownerID, cartName, []uuid.UUID{productID}}},
+		{"ImportItem", db.ImportItem, []any{ownerID, cartName, productID, amount, "USD", 1, nil, nil, now}},
+		{"ListOwners", db.ListOwners, []any{cartName, 10, 0}},
+		{"MoveItems", db.MoveItems, []any{now, ownerID, cartName, "other owner"}},
+		{"Ping", db.Ping, nil},
+		{"PurgeCartsOlderThan", db.PurgeCartsOlderThan, []any{cartName, now}},
+		{"RestoreItem", db.RestoreItem, []any{ownerID, cartName, productID}},
+		{"SetItem", db.SetItem, []any{ownerID, cartName, productID, amount, "USD", 1, nil, now}},
+		{"SetOwnerCurrency", db.SetOwnerCurrency, []any{ownerID, "USD", now}},
+		{"SwapQuantities", db.SwapQuantities, []any{ownerID, cartName, productID, uuid.Nil}},
+		{"UpdateItemPrice", db.UpdateItemPrice, []any{ownerID, cartName, productID, amount, "USD"}},
+		{"UpdateItemPriceIfVersion", db.UpdateItemPriceIfVersion, []any{ownerID, cartName, productID, amount, "USD", 1}},
+	}
+}
+
+// DumpPlans writes the EXPLAIN plan of every known query to w.
+//
+// Costs are left out and sequential scans are disabled so the plans only change when a query can no longer
+// use an index, not when the table statistics change. EXPLAIN does not execute the writing queries.
+func DumpPlans(ctx context.Context, pool *pgxpool.Pool, w io.Writer) (txErr error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("pool.Begin: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			txErr = errors.Join(txErr, fmt.Errorf("tx.Rollback: %w", err))
+		}
+	}()
+
+	if _, err := tx.Exec(ctx, "SET LOCAL enable_seqscan = off"); err != nil {
+		return fmt.Errorf("tx.Exec: %w", err)
+	}
+
+	for _, query := range knownQueries() {
+		rows, err := tx.Query(ctx, "EXPLAIN (COSTS OFF) "+query.sql, query.args...)
+		if err != nil {
+			return fmt.Errorf("tx.Query[%s]: %w", query.name, err)
+		}
+
+		lines, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return fmt.Errorf("pgx.CollectRows[%s]: %w", query.name, err)
+		}
+
+		if _, err := fmt.Fprintf(w, "-- %s\n", query.name); err != nil {
+			return fmt.Errorf("fmt.Fprintf: %w", err)
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+				return fmt.Errorf("fmt.Fprintln: %w", err)
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return fmt.Errorf("fmt.Fprintln: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (suite *cartRepositorySuite) TestQueryPlans() {
+	t := suite.T()
+
+	var plans strings.Builder
+	require.NoError(t, DumpPlans(t.Context(), suite.pool, &plans))
+
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(filepath.Dir(queryPlansGolden), 0o755))
+		require.NoError(t, os.WriteFile(queryPlansGolden, []byte(plans.String()), 0o644))
+		return
+	}
+
+	// a missing golden file fails, so a plan losing an index can not go unnoticed
+	golden, err := os.ReadFile(queryPlansGolden)
+	if errors.Is(err, os.ErrNotExist) {
+		require.FailNow(t, fmt.Sprintf("%s is missing, run the test with -update to create it", queryPlansGolden))
+	}
+	require.NoError(t, err)
+
+	require.Equal(t, string(golden), plans.String(), "query plans changed, run the test with -update if it is expected")
+}
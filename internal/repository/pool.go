@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+)
+
+var ErrPoolExhausted = errors.New("pool is exhausted")
+
+// poolErrorDBTX reports the context expiring while waiting for a pool connection as ErrPoolExhausted.
+//
+// pgxpool returns the bare context error when no connection is acquired in time, while pgconn wraps
+// the context errors of queries which already got a connection, so the two can be told apart.
+type poolErrorDBTX struct {
+	dbtx db.DBTX
+}
+
+func (d poolErrorDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	done := ctx.Err() != nil
+	tag, err := d.dbtx.Exec(ctx, sql, args...)
+	return tag, poolExhaustedError(ctx, done, err)
+}
+
+func (d poolErrorDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	done := ctx.Err() != nil
+	rows, err := d.dbtx.Query(ctx, sql, args...)
+	return rows, poolExhaustedError(ctx, done, err)
+}
+
+func (d poolErrorDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return poolErrorRow{
+		row:  d.dbtx.QueryRow(ctx, sql, args...),
+		ctx:  ctx,
+		done: ctx.Err() != nil,
+	}
+}
+
+func (d poolErrorDBTX) Begin(ctx context.Context) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transactions", d.dbtx)
+	}
+
+	done := ctx.Err() != nil
+	tx, err := beginner.Begin(ctx)
+	return tx, poolExhaustedError(ctx, done, err)
+}
+
+func (d poolErrorDBTX) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transaction options", d.dbtx)
+	}
+
+	done := ctx.Err() != nil
+	tx, err := beginner.BeginTx(ctx, txOptions)
+	return tx, poolExhaustedError(ctx, done, err)
+}
+
+func (d poolErrorDBTX) Close() {
+	if pool, ok := d.dbtx.(interface{ Close() }); ok {
+		pool.Close()
+	}
+}
+
+type poolErrorRow struct {
+	row  pgx.Row
+	ctx  context.Context
+	done bool
+}
+
+func (r poolErrorRow) Scan(dest ...any) error {
+	return poolExhaustedError(r.ctx, r.done, r.row.Scan(dest...))
+}
+
+// poolExhaustedError keeps the context error visible to errors.Is, done tells whether ctx had expired before the call.
+func poolExhaustedError(ctx context.Context, done bool, err error) error {
+	if err == nil || done || err != ctx.Err() {
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", ErrPoolExhausted, err)
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+)
+
+// Repositories holds every repository of the package wired to the same pool, InTx binds them all
+// to a single transaction.
+type Repositories struct {
+	Cart port.CartRepository
+
+	dbtx     db.DBTX
+	cartOpts []CartOption
+}
+
+// New creates the repositories on the pool, the options configure the CartRepository as in NewCart.
+func New(pool *pgxpool.Pool, opts ...CartOption) (*Repositories, error) {
+	if pool == nil {
+		return nil, fmt.Errorf("pool is nil")
+	}
+
+	return newRepositories(pool, opts)
+}
+
+func newRepositories(dbtx db.DBTX, cartOpts []CartOption) (*Repositories, error) {
+	cart, err := NewCart(dbtx, cartOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("NewCart: %w", err)
+	}
+
+	return &Repositories{
+		Cart:     cart,
+		dbtx:     dbtx,
+		cartOpts: cartOpts,
+	}, nil
+}
+
+// InTx runs fn with the repositories bound to one transaction, which is committed when fn succeeds
+// and rolled back otherwise, a failed rollback is joined to the error of fn. InTx of the repositories
+// passed to fn uses a savepoint of the same transaction. The observer of WithObserver is only notified
+// after the commit.
+func (r *Repositories) InTx(ctx context.Context, fn func(tx *Repositories) error) error {
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	return runInTx(ctx, r.dbtx, r.cartOpts, func(tx pgx.Tx, cartOpts []CartOption) error {
+		repos, err := newRepositories(tx, cartOpts)
+		if err != nil {
+			return fmt.Errorf("newRepositories: %w", err)
+		}
+
+		return fn(repos)
+	})
+}
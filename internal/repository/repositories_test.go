@@ -0,0 +1,82 @@
+package repository_test
+
+import (
+	"errors"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *cartRepositorySuite) TestRepositories() {
+	defer suite.deleteAll()
+
+	repos, err := repository.New(suite.pool)
+	suite.Require().NoError(err)
+
+	suite.Run("cart: ok", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, repos.Cart.AddItem(ctx, ownerID, item))
+
+		cart, err := repos.Cart.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, item, cart.Items[0])
+	})
+
+	suite.Run("InTx callback succeeds: committed", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		err := repos.InTx(ctx, func(tx *repository.Repositories) error {
+			return tx.Cart.AddItem(ctx, ownerID, item)
+		})
+		require.NoError(t, err)
+
+		got, err := repos.Cart.GetItem(ctx, ownerID, item.ProductID)
+		require.NoError(t, err)
+		assertCartItem(t, item, got)
+	})
+
+	suite.Run("InTx callback fails: rolled back", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		errCallback := errors.New("callback failed")
+		ownerID := gofakeit.UUID()
+		items := []domain.CartItem{randomCartItem(), randomCartItem()}
+		err := repos.InTx(ctx, func(tx *repository.Repositories) error {
+			for _, item := range items {
+				if err := tx.Cart.AddItem(ctx, ownerID, item); err != nil {
+					return err
+				}
+			}
+			return errCallback
+		})
+		require.ErrorIs(t, err, errCallback)
+
+		exists, err := repos.Cart.CartExists(ctx, ownerID)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	suite.Run("nil pool: error", func() {
+		_, err := repository.New(nil)
+		require.EqualError(suite.T(), err, "pool is nil")
+	})
+
+	suite.Run("nil fn: error", func() {
+		t := suite.T()
+
+		err := repos.InTx(t.Context(), nil)
+		require.EqualError(t, err, "fn is nil")
+	})
+}
@@ -11,7 +11,14 @@ func startPostgres(ctx context.Context) (*postgres.PostgresContainer, string, er
 	postgresContainer, err := postgres.Run(ctx, "postgres:17.7-alpine3.23",
 		postgres.BasicWaitStrategies(),
 		postgres.WithInitScripts(
-			"../migrations/01_cart_items.up.sql"),
+			"../migrations/01_cart_items.up.sql",
+			"../migrations/02_owner_settings.up.sql",
+			"../migrations/03_cart_name.up.sql",
+			"../migrations/04_quantity.up.sql",
+			"../migrations/05_cart_item_idempotency.up.sql",
+			"../migrations/06_deleted_at.up.sql",
+			"../migrations/07_version.up.sql",
+			"../migrations/08_note.up.sql"),
 	)
 	if err != nil {
 		return nil, "", fmt.Errorf("postgres.Run: %w", err)
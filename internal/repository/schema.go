@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+)
+
+// schemaDBTX runs every query in a transaction with the search_path set to the schema, so the unqualified
+// table names of the queries resolve in it. The setting is local to the transaction, the connection goes back
+// to the pool unchanged. Transactions started through it set it once for all of their queries.
+//
+// The transaction of Query and QueryRow is committed once the rows are read.
+type schemaDBTX struct {
+	dbtx   db.DBTX
+	schema string
+}
+
+func (d schemaDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return inTx(ctx, d, pgx.TxOptions{}, func(tx pgx.Tx) (pgconn.CommandTag, error) {
+		return tx.Exec(ctx, sql, args...)
+	})
+}
+
+func (d schemaDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	tx, err := d.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			err = errors.Join(err, fmt.Errorf("tx.Rollback: %w", rbErr))
+		}
+		return nil, err
+	}
+
+	return &schemaRows{Rows: rows, ctx: ctx, tx: tx}, nil
+}
+
+func (d schemaDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	rows, err := d.Query(ctx, sql, args...)
+	return schemaRow{rows: rows, err: err}
+}
+
+func (d schemaDBTX) Begin(ctx context.Context) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transactions", d.dbtx)
+	}
+
+	tx, err := beginner.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.setSearchPath(ctx, tx)
+}
+
+func (d schemaDBTX) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transaction options", d.dbtx)
+	}
+
+	tx, err := beginner.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.setSearchPath(ctx, tx)
+}
+
+func (d schemaDBTX) Close() {
+	if pool, ok := d.dbtx.(interface{ Close() }); ok {
+		pool.Close()
+	}
+}
+
+// setSearchPath sets the search_path until the end of the transaction, rolling it back on failure.
+func (d schemaDBTX) setSearchPath(ctx context.Context, tx pgx.Tx) (pgx.Tx, error) {
+	searchPath := pgx.Identifier{d.schema}.Sanitize()
+
+	if _, err := tx.Exec(ctx, "SELECT set_config('search_path', $1, true)", searchPath); err != nil {
+		err = fmt.Errorf("set_config: %w", err)
+		if rbErr := tx.Rollback(ctx); rbErr != nil {
+			err = errors.Join(err, fmt.Errorf("tx.Rollback: %w", rbErr))
+		}
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// schemaRows ends the transaction of the query once the rows are read or closed,
+// a failed commit is reported by Err.
+type schemaRows struct {
+	pgx.Rows
+	ctx   context.Context
+	tx    pgx.Tx
+	ended bool
+	err   error
+}
+
+func (r *schemaRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.end()
+	return false
+}
+
+func (r *schemaRows) Err() error {
+	if err := r.Rows.Err(); err != nil {
+		return err
+	}
+
+	return r.err
+}
+
+func (r *schemaRows) Close() {
+	r.Rows.Close()
+	r.end()
+}
+
+func (r *schemaRows) end() {
+	if r.ended {
+		return
+	}
+	r.ended = true
+
+	if r.Rows.Err() != nil {
+		_ = r.tx.Rollback(r.ctx)
+		return
+	}
+
+	if err := r.tx.Commit(r.ctx); err != nil {
+		r.err = fmt.Errorf("tx.Commit: %w", err)
+	}
+}
+
+// schemaRow reads the first row like pgx.Row does, ending the transaction of the query.
+type schemaRow struct {
+	rows pgx.Rows
+	err  error
+}
+
+func (r schemaRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	defer r.rows.Close()
+
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return pgx.ErrNoRows
+	}
+
+	if err := r.rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	r.rows.Close()
+	return r.rows.Err()
+}
@@ -0,0 +1,102 @@
+package repository_test
+
+import (
+	"fmt"
+
+	"github.com/brianvoe/gofakeit/v7"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func (suite *cartRepositorySuite) TestSchema() {
+	t := suite.T()
+	ctx := t.Context()
+	defer suite.deleteAll()
+
+	schemas := []string{"tenant_a", "tenant_b"}
+	for _, schema := range schemas {
+		for _, statement := range []string{
+			"CREATE SCHEMA %[1]s",
+			"CREATE TABLE %[1]s.cart_items (LIKE public.cart_items INCLUDING ALL)",
+			"CREATE TABLE %[1]s.owner_settings (LIKE public.owner_settings INCLUDING ALL)",
+			"CREATE TABLE %[1]s.cart_item_idempotency (LIKE public.cart_item_idempotency INCLUDING ALL)",
+		} {
+			_, err := suite.pool.Exec(ctx, fmt.Sprintf(statement, schema))
+			require.NoError(t, err)
+		}
+	}
+	defer func() {
+		for _, schema := range schemas {
+			_, err := suite.pool.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+			assert.NoError(t, err)
+		}
+	}()
+
+	repoA, err := repository.NewCart(suite.pool, repository.WithSchema(schemas[0]))
+	require.NoError(t, err)
+	repoB, err := repository.NewCart(suite.pool, repository.WithSchema(schemas[1]))
+	require.NoError(t, err)
+
+	suite.Run("item added in one schema: isolated", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		item := randomCartItem()
+		require.NoError(t, repoA.AddItem(ctx, ownerID, item))
+
+		cart, err := repoA.GetCart(ctx, ownerID)
+		require.NoError(t, err)
+		require.Len(t, cart.Items, 1)
+		assertCartItem(t, item, cart.Items[0])
+
+		for _, repo := range []port.CartRepository{repoB, suite.repo} {
+			exists, err := repo.CartExists(ctx, ownerID)
+			require.NoError(t, err)
+			assert.False(t, exists)
+		}
+	})
+
+	suite.Run("transactions in one schema: isolated", func() {
+		t := suite.T()
+		ctx := t.Context()
+
+		ownerID := gofakeit.UUID()
+		require.NoError(t, repoB.SetOwnerCurrency(ctx, ownerID, currency.EUR))
+
+		// the owner's default currency is resolved in a transaction
+		item := randomCartItem()
+		item.Price.Currency = currency.Unit{}
+		require.NoError(t, repoB.AddItem(ctx, ownerID, item))
+
+		err := repository.RunInTx(ctx, suite.pool, func(repo port.CartRepository) error {
+			return repo.AddItem(ctx, ownerID, randomCartItem())
+		}, repository.WithSchema(schemas[1]))
+		require.NoError(t, err)
+
+		count, err := repoB.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		_, ok, err := repoA.GetOwnerCurrency(ctx, ownerID)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		count, err = repoA.CountItems(ctx, ownerID)
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	suite.Run("missing schema: error", func() {
+		t := suite.T()
+
+		repo, err := repository.NewCart(suite.pool, repository.WithSchema("missing"))
+		require.NoError(t, err)
+
+		err = repo.AddItem(t.Context(), gofakeit.UUID(), randomCartItem())
+		require.ErrorContains(t, err, `relation "cart_items" does not exist`)
+	})
+}
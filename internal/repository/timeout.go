@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+)
+
+// timeoutDBTX bounds every query by the timeout, an earlier deadline of the caller's context still wins.
+//
+// The context of Query and QueryRow is canceled once the rows are read, transactions started through it
+// apply the timeout to their queries too.
+type timeoutDBTX struct {
+	dbtx    db.DBTX
+	timeout time.Duration
+}
+
+func (d timeoutDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	return d.dbtx.Exec(ctx, sql, args...)
+}
+
+func (d timeoutDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+
+	rows, err := d.dbtx.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (d timeoutDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+
+	return timeoutRow{
+		row:    d.dbtx.QueryRow(ctx, sql, args...),
+		cancel: cancel,
+	}
+}
+
+func (d timeoutDBTX) Begin(ctx context.Context) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		Begin(ctx context.Context) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transactions", d.dbtx)
+	}
+
+	beginCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	tx, err := beginner.Begin(beginCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return timeoutTx{Tx: tx, timeout: d.timeout}, nil
+}
+
+func (d timeoutDBTX) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	beginner, ok := d.dbtx.(interface {
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transaction options", d.dbtx)
+	}
+
+	beginCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	tx, err := beginner.BeginTx(beginCtx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return timeoutTx{Tx: tx, timeout: d.timeout}, nil
+}
+
+func (d timeoutDBTX) Close() {
+	if pool, ok := d.dbtx.(interface{ Close() }); ok {
+		pool.Close()
+	}
+}
+
+// timeoutTx overrides the query methods of the embedded transaction, Commit and Rollback are left unbounded.
+type timeoutTx struct {
+	pgx.Tx
+	timeout time.Duration
+}
+
+func (t timeoutTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return timeoutDBTX{dbtx: t.Tx, timeout: t.timeout}.Exec(ctx, sql, args...)
+}
+
+func (t timeoutTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return timeoutDBTX{dbtx: t.Tx, timeout: t.timeout}.Query(ctx, sql, args...)
+}
+
+func (t timeoutTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return timeoutDBTX{dbtx: t.Tx, timeout: t.timeout}.QueryRow(ctx, sql, args...)
+}
+
+func (t timeoutTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return timeoutDBTX{dbtx: t.Tx, timeout: t.timeout}.Begin(ctx)
+}
+
+type timeoutRows struct {
+	pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r timeoutRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.cancel()
+	return false
+}
+
+func (r timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+type timeoutRow struct {
+	row    pgx.Row
+	cancel context.CancelFunc
+}
+
+func (r timeoutRow) Scan(dest ...any) error {
+	defer r.cancel()
+	return r.row.Scan(dest...)
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/db"
+	"github.com/nikolayk812/sqlcpp-demo/internal/port"
+)
+
+// RunInTx runs fn with a CartRepository bound to a transaction started on dbtx, the transaction is committed
+// when fn succeeds and rolled back otherwise. Calls of the repository needing a transaction themselves
+// use savepoints of the same one. The transaction is started with the options of WithTxOptions if given.
+// The observer of WithObserver is only notified after the commit.
+func RunInTx(ctx context.Context, dbtx db.DBTX, fn func(repo port.CartRepository) error, opts ...CartOption) error {
+	if fn == nil {
+		return fmt.Errorf("fn is nil")
+	}
+
+	return runInTx(ctx, dbtx, opts, func(tx pgx.Tx, opts []CartOption) error {
+		repo, err := NewCart(tx, opts...)
+		if err != nil {
+			return fmt.Errorf("NewCart: %w", err)
+		}
+
+		return fn(repo)
+	})
+}
+
+// runInTx runs fn in a transaction started with the options of WithTxOptions, passing fn the options
+// to construct the repositories with. The observer of WithObserver is swapped for one notified after the commit.
+func runInTx(ctx context.Context, dbtx db.DBTX, opts []CartOption, fn func(tx pgx.Tx, opts []CartOption) error) error {
+	var options cartOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	observer := &txObserver{}
+	if options.observer != nil {
+		// clipped, so appending does not overwrite the caller's backing array
+		opts = append(slices.Clip(opts), WithObserver(observer))
+	}
+
+	_, err := inTx(ctx, dbtx, options.txOptions, func(tx pgx.Tx) (struct{}, error) {
+		return struct{}{}, fn(tx, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	if options.observer != nil {
+		observer.flush(options.observer)
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction started on dbtx, a savepoint is used when dbtx is already a pgx.Tx.
+// Zero txOptions keep the defaults of the database.
+func withTx[T any](ctx context.Context, dbtx db.DBTX, txOptions pgx.TxOptions, fn func(q *db.Queries) (T, error)) (T, error) {
+	return inTx(ctx, dbtx, txOptions, func(tx pgx.Tx) (T, error) {
+		return fn(db.New(tx))
+	})
+}
+
+// txRetry bounds the attempts of withTxRetry, the backoff doubles after every failed attempt.
+type txRetry struct {
+	maxAttempts int
+	backoff     time.Duration
+}
+
+// withTxRetry runs withTx again while it fails with a serialization failure or a deadlock,
+// fn must be safe to re-run as it is called once per attempt. Other errors are returned at once.
+func withTxRetry[T any](ctx context.Context, dbtx db.DBTX, txOptions pgx.TxOptions, retry txRetry, fn func(q *db.Queries) (T, error)) (T, error) {
+	backoff := retry.backoff
+
+	for attempt := 1; ; attempt++ {
+		result, err := withTx(ctx, dbtx, txOptions, fn)
+		if err == nil || attempt >= retry.maxAttempts || !isRetriable(err) {
+			return result, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return result, errors.Join(err, fmt.Errorf("ctx.Done: %w", ctx.Err()))
+		}
+
+		backoff *= 2
+	}
+}
+
+// isRetriable reports whether the transaction failed with SQLSTATE 40001 serialization_failure
+// or 40P01 deadlock_detected, both rolling back the whole transaction.
+func isRetriable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+func inTx[T any](ctx context.Context, dbtx db.DBTX, txOptions pgx.TxOptions, fn func(tx pgx.Tx) (T, error)) (_ T, txErr error) {
+	var zero T
+
+	tx, err := begin(ctx, dbtx, txOptions)
+	if err != nil {
+		return zero, err
+	}
+
+	defer func() {
+		if txErr == nil {
+			return
+		}
+		if err := tx.Rollback(ctx); err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			txErr = errors.Join(txErr, fmt.Errorf("tx.Rollback: %w", err))
+		}
+	}()
+
+	result, err := fn(tx)
+	if err != nil {
+		return zero, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return zero, fmt.Errorf("tx.Commit: %w", err)
+	}
+
+	return result, nil
+}
+
+// begin starts a transaction with the given options, zero options start it with Begin, which makes a savepoint
+// of a pgx.Tx. A savepoint cannot change the options of its transaction, so a pgx.Tx only accepts zero options.
+func begin(ctx context.Context, dbtx db.DBTX, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	if txOptions == (pgx.TxOptions{}) {
+		beginner, ok := dbtx.(interface {
+			Begin(ctx context.Context) (pgx.Tx, error)
+		})
+		if !ok {
+			return nil, fmt.Errorf("dbtx[%T] does not support transactions", dbtx)
+		}
+
+		tx, err := beginner.Begin(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("dbtx.Begin: %w", err)
+		}
+
+		return tx, nil
+	}
+
+	beginner, ok := dbtx.(interface {
+		BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("dbtx[%T] does not support transaction options", dbtx)
+	}
+
+	tx, err := beginner.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, fmt.Errorf("dbtx.BeginTx: %w", err)
+	}
+
+	return tx, nil
+}
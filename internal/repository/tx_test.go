@@ -0,0 +1,149 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nikolayk812/sqlcpp-demo/internal/domain"
+	"github.com/nikolayk812/sqlcpp-demo/internal/repository"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/currency"
+)
+
+func TestTxRetry(t *testing.T) {
+	serializationFailure := &pgconn.PgError{Code: "40001"}
+	deadlock := &pgconn.PgError{Code: "40P01"}
+	uniqueViolation := &pgconn.PgError{Code: "23505"}
+
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.USD},
+		Quantity:  1,
+	}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantCode     string
+	}{
+		{
+			name:         "serialization failure once: retried",
+			errs:         []error{serializationFailure},
+			wantAttempts: 2,
+		},
+		{
+			name:         "deadlock twice: retried",
+			errs:         []error{deadlock, deadlock},
+			wantAttempts: 3,
+		},
+		{
+			name:         "serialization failure on every attempt: error",
+			errs:         []error{serializationFailure, serializationFailure, serializationFailure},
+			wantAttempts: 3,
+			wantCode:     "40001",
+		},
+		{
+			name:         "not retriable: error at once",
+			errs:         []error{uniqueViolation},
+			wantAttempts: 1,
+			wantCode:     "23505",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbtx := &fakeTxDBTX{errs: tt.errs}
+			observer := &recordingObserver{}
+
+			repo, err := repository.NewCart(dbtx, repository.WithTxRetry(3, time.Millisecond), repository.WithObserver(observer))
+			require.NoError(t, err)
+
+			err = repo.AddItemIdempotent(t.Context(), "owner", item, "key")
+			assert.Equal(t, tt.wantAttempts, dbtx.begins)
+
+			if tt.wantCode != "" {
+				var pgErr *pgconn.PgError
+				require.ErrorAs(t, err, &pgErr)
+				assert.Equal(t, tt.wantCode, pgErr.Code)
+				// rolled back attempts are not notified
+				assert.Empty(t, observer.recorded())
+				return
+			}
+			require.NoError(t, err)
+			// notified once, not per attempt
+			assert.Equal(t, []string{"added"}, observer.recorded())
+		})
+	}
+}
+
+func TestTxOptionsNotSupported(t *testing.T) {
+	dbtx := &fakeTxDBTX{}
+
+	repo, err := repository.NewCart(dbtx, repository.WithTxOptions(pgx.TxOptions{AccessMode: pgx.ReadOnly}))
+	require.NoError(t, err)
+
+	item := domain.CartItem{
+		ProductID: uuid.New(),
+		Price:     domain.Money{Amount: decimal.RequireFromString("1"), Currency: currency.USD},
+		Quantity:  1,
+	}
+
+	err = repo.AddItemIdempotent(t.Context(), "owner", item, "key")
+	require.EqualError(t, err, "dbtx[*repository_test.fakeTxDBTX] does not support transaction options")
+	assert.Zero(t, dbtx.begins)
+}
+
+// fakeTxDBTX starts transactions whose statements fail with the error of the attempt, errs[0] for the first one.
+// The attempts after the last error succeed.
+type fakeTxDBTX struct {
+	errs   []error
+	begins int
+}
+
+func (d *fakeTxDBTX) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("not in a transaction")
+}
+
+func (d *fakeTxDBTX) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("not in a transaction")
+}
+
+func (d *fakeTxDBTX) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return nil
+}
+
+func (d *fakeTxDBTX) Begin(context.Context) (pgx.Tx, error) {
+	tx := &fakeTx{}
+	if d.begins < len(d.errs) {
+		tx.err = d.errs[d.begins]
+	}
+	d.begins++
+
+	return tx, nil
+}
+
+// fakeTx only implements the methods used by the repository transactions, the others panic.
+type fakeTx struct {
+	pgx.Tx
+	err error
+}
+
+func (t *fakeTx) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.NewCommandTag("INSERT 0 1"), t.err
+}
+
+func (t *fakeTx) Commit(context.Context) error {
+	return nil
+}
+
+func (t *fakeTx) Rollback(context.Context) error {
+	return nil
+}